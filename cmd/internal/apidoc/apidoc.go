@@ -0,0 +1,318 @@
+package apidoc
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// описание API; при изменении хендлеров обновляется вместе с ними (см. контрактные тесты handlers)
+//
+//go:embed openapi.yaml
+var specYAML []byte
+
+//go:embed docs.html
+var docsHTML []byte
+
+var (
+	document = mustParse(specYAML)
+	specJSON = mustJSON(document)
+)
+
+func mustParse(data []byte) map[string]any {
+	var doc map[string]any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		panic("apidoc: invalid openapi.yaml: " + err.Error())
+	}
+	return doc
+}
+
+func mustJSON(doc map[string]any) []byte {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		panic("apidoc: openapi.yaml is not representable as JSON: " + err.Error())
+	}
+	return data
+}
+
+// ServeYAML отдаёт документ как есть
+func ServeYAML(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml; charset=utf-8")
+	w.Write(specYAML)
+}
+
+// ServeJSON отдаёт документ в JSON (для страницы документации и генераторов клиентов)
+func ServeJSON(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(specJSON)
+}
+
+// ServeDocs отдаёт страницу документации; она читает /api/v1/openapi.json
+func ServeDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsHTML)
+}
+
+// Operation - метод и шаблон пути из документа
+type Operation struct {
+	Method string
+	Path   string
+}
+
+var methods = []string{"get", "put", "post", "delete", "patch", "head", "options"}
+
+// Operations возвращает все описанные операции, отсортированные по пути
+func Operations() []Operation {
+	var ops []Operation
+	for path, item := range asMap(document["paths"]) {
+		for _, method := range methods {
+			if _, ok := asMap(item)[method]; ok {
+				ops = append(ops, Operation{Method: strings.ToUpper(method), Path: path})
+			}
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i].Path != ops[j].Path {
+			return ops[i].Path < ops[j].Path
+		}
+		return ops[i].Method < ops[j].Method
+	})
+	return ops
+}
+
+// FindOperation ищет операцию по методу и пути запроса ({id} совпадает с любым сегментом)
+func FindOperation(method, path string) (Operation, bool) {
+	for _, op := range Operations() {
+		if op.Method == method && matchPath(op.Path, path) {
+			return op, true
+		}
+	}
+	return Operation{}, false
+}
+
+func matchPath(template, path string) bool {
+	templateParts := strings.Split(template, "/")
+	pathParts := strings.Split(path, "/")
+	if len(templateParts) != len(pathParts) {
+		return false
+	}
+	for i, part := range templateParts {
+		isParam := strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}")
+		if !isParam && part != pathParts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// SchemaEnum возвращает enum свойства схемы из components (например коды в Error)
+func SchemaEnum(schema, property string) []string {
+	props := asMap(asMap(resolve(map[string]any{"$ref": "#/components/schemas/" + schema}))["properties"])
+	var values []string
+	for _, value := range asSlice(asMap(props[property])["enum"]) {
+		values = append(values, fmt.Sprint(value))
+	}
+	return values
+}
+
+// ValidateResponse проверяет ответ по документу: описан ли статус, тип содержимого и JSON по схеме
+func ValidateResponse(method, path string, status int, contentType string, body []byte) error {
+	op, ok := FindOperation(method, path)
+	if !ok {
+		return fmt.Errorf("%s %s: operation is not documented", method, path)
+	}
+	responses := asMap(asMap(asMap(asMap(document["paths"])[op.Path])[strings.ToLower(method)])["responses"])
+	response, ok := responses[fmt.Sprint(status)]
+	if !ok {
+		response, ok = responses["default"]
+	}
+	if !ok {
+		return fmt.Errorf("%s %s: status %d is not documented", method, op.Path, status)
+	}
+
+	content := asMap(asMap(resolve(response))["content"])
+	if len(content) == 0 {
+		return nil
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	media, ok := content[mediaType]
+	if !ok {
+		return fmt.Errorf("%s %s %d: content type %q is not documented", method, op.Path, status, contentType)
+	}
+	if mediaType != "application/json" {
+		return nil
+	}
+
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Errorf("%s %s %d: body is not JSON: %w", method, op.Path, status, err)
+	}
+	if err := validate(asMap(media)["schema"], value, "body"); err != nil {
+		return fmt.Errorf("%s %s %d: %w", method, op.Path, status, err)
+	}
+	return nil
+}
+
+// проверка значения по схеме; поддерживается подмножество OpenAPI, которое используется в документе.
+// в отличие от OpenAPI лишние поля объекта - ошибка: так ловится расхождение имён (groupid и groupId)
+func validate(schemaNode any, value any, at string) error {
+	schema := asMap(resolve(schemaNode))
+
+	if parts, ok := schema["allOf"]; ok {
+		return validate(mergeAllOf(asSlice(parts)), value, at)
+	}
+	if variants, ok := schema["oneOf"]; ok {
+		matched := 0
+		var errs []string
+		for _, variant := range asSlice(variants) {
+			if err := validate(variant, value, at); err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			matched++
+		}
+		if matched != 1 {
+			return fmt.Errorf("%s: matches %d of oneOf variants (%s)", at, matched, strings.Join(errs, "; "))
+		}
+		return nil
+	}
+
+	if value == nil {
+		if schema["nullable"] == true {
+			return nil
+		}
+		return fmt.Errorf("%s: null is not allowed", at)
+	}
+
+	if enum, ok := schema["enum"]; ok {
+		found := false
+		for _, allowed := range asSlice(enum) {
+			if allowed == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", at, value, enum)
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected object, got %T", at, value)
+		}
+		for _, name := range asSlice(schema["required"]) {
+			if _, ok := object[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing required property %q", at, name)
+			}
+		}
+		properties := asMap(schema["properties"])
+		for name, propertyValue := range object {
+			propertySchema, ok := properties[name]
+			if !ok {
+				additional, isSchema := schema["additionalProperties"].(map[string]any)
+				if !isSchema {
+					if schema["additionalProperties"] == true {
+						continue
+					}
+					return fmt.Errorf("%s: unexpected property %q", at, name)
+				}
+				propertySchema = additional
+			}
+			if err := validate(propertySchema, propertyValue, at+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected array, got %T", at, value)
+		}
+		for i, item := range items {
+			if err := validate(schema["items"], item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected string, got %T", at, value)
+		}
+		if schema["format"] == "date-time" {
+			if err := checkDateTime(text); err != nil {
+				return fmt.Errorf("%s: %w", at, err)
+			}
+		}
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != float64(int64(number)) {
+			return fmt.Errorf("%s: expected integer, got %v", at, value)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s: expected number, got %T", at, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected boolean, got %T", at, value)
+		}
+	}
+	return nil
+}
+
+func checkDateTime(text string) error {
+	if _, err := time.Parse(time.RFC3339Nano, text); err != nil {
+		return fmt.Errorf("expected date-time, got %q", text)
+	}
+	return nil
+}
+
+// allOf из объектов сводится к одному объекту со всеми свойствами
+func mergeAllOf(parts []any) map[string]any {
+	properties := map[string]any{}
+	var required []any
+	for _, part := range parts {
+		schema := asMap(resolve(part))
+		for name, property := range asMap(schema["properties"]) {
+			properties[name] = property
+		}
+		required = append(required, asSlice(schema["required"])...)
+	}
+	return map[string]any{"type": "object", "properties": properties, "required": required}
+}
+
+// $ref только внутри документа: #/components/...
+func resolve(node any) any {
+	for {
+		ref, ok := asMap(node)["$ref"].(string)
+		if !ok {
+			return node
+		}
+		node = document
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			node = asMap(node)[part]
+		}
+		if node == nil {
+			panic("apidoc: unresolved reference " + ref)
+		}
+	}
+}
+
+func asMap(node any) map[string]any {
+	m, _ := node.(map[string]any)
+	return m
+}
+
+func asSlice(node any) []any {
+	s, _ := node.([]any)
+	return s
+}
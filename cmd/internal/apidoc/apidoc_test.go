@@ -0,0 +1,84 @@
+package apidoc
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestDocumentReferences проверяет что все $ref указывают на существующие объекты
+func TestDocumentReferences(t *testing.T) {
+	var walk func(node any, at string)
+	walk = func(node any, at string) {
+		switch n := node.(type) {
+		case map[string]any:
+			if ref, ok := n["$ref"].(string); ok {
+				func() {
+					defer func() {
+						if recover() != nil {
+							t.Errorf("%s: unresolved reference %s", at, ref)
+						}
+					}()
+					resolve(n)
+				}()
+			}
+			for key, child := range n {
+				walk(child, at+"/"+key)
+			}
+		case []any:
+			for _, child := range n {
+				walk(child, at)
+			}
+		}
+	}
+	walk(document, "#")
+
+	if document["openapi"] != "3.0.3" {
+		t.Errorf("Unexpected openapi version %v", document["openapi"])
+	}
+	for _, op := range Operations() {
+		item := asMap(asMap(document["paths"])[op.Path])
+		if len(asMap(asMap(item[strings.ToLower(op.Method)])["responses"])) == 0 {
+			t.Errorf("%s %s has no responses", op.Method, op.Path)
+		}
+	}
+}
+
+// TestValidate проверяет поддерживаемое подмножество схем
+func TestValidate(t *testing.T) {
+	lesson := `{"id":1,"name_lesson":"Math","date":"2024-01-01","type_les":"Лекция","qr_token":"t","is_active":true,"teacher_id":2,"session_start":"2024-01-01T10:00:00Z","session_end":null,"is_open":true}`
+	testCases := []struct {
+		name    string
+		method  string
+		path    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{"valid", "GET", "/api/v1/lessons", 200, `{"success":true,"message":"ok","fullname":"T","lessons":[` + lesson + `]}`, ""},
+		{"nullable array", "GET", "/api/v1/lessons", 200, `{"success":true,"message":"ok","fullname":"T","lessons":null}`, ""},
+		{"allOf", "GET", "/api/v1/lessons/5", 200, `{"success":true,"message":"ok",` + lesson[1:], ""},
+		{"renamed field", "GET", "/student/getInfo", 200, `{"success":true,"message":"ok","fullname":"S","groupid":1,"groupId":1}`, `unexpected property "groupId"`},
+		{"missing field", "GET", "/api/v1/student", 200, `{"success":true,"message":"ok","fullname":"S"}`, `missing required property "groupid"`},
+		{"wrong type", "GET", "/api/v1/student", 200, `{"success":true,"message":"ok","fullname":"S","groupid":"1"}`, "expected integer"},
+		{"bad date-time", "GET", "/api/v1/lessons", 200, `{"success":true,"message":"ok","fullname":"T","lessons":[` + strings.Replace(lesson, "2024-01-01T10:00:00Z", "2024-01-01 10:00", 1) + `]}`, "expected date-time"},
+		{"error envelope", "DELETE", "/api/v1/archive/3", 404, `{"success":false,"code":"LESSON_NOT_FOUND","message":"Lesson not found"}`, ""},
+		{"unknown code", "DELETE", "/api/v1/archive/3", 404, `{"success":false,"code":"OOPS","message":"?"}`, "is not one of"},
+		{"oneOf", "POST", "/api/v1/auth/login", 401, `{"success":false,"code":"INVALID_CREDENTIALS","message":"no"}`, ""},
+		{"undocumented route", "GET", "/api/v2/nothing", 200, `{}`, "not documented"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateResponse(tc.method, tc.path, tc.status, "application/json", []byte(tc.body))
+			if tc.wantErr == "" && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Errorf("Expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+
+	if err := ValidateResponse("GET", "/api/v1/lessons", 200, "text/html", []byte("<html>")); err == nil {
+		t.Error("Expected error for undocumented content type")
+	}
+}
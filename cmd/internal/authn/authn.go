@@ -0,0 +1,45 @@
+package authn
+
+import (
+	"context"
+	"errors"
+)
+
+// пользователь после успешной проверки пароля (id - локальный, из таблицы user)
+type Identity struct {
+	UserID   int64
+	Login    string
+	FullName string
+	Role     string
+	GroupID  int64
+}
+
+var (
+	ErrInvalidCredentials = errors.New("invalid login or password")
+	// пароль верный, но локальной учётной записи нет и создавать её нельзя
+	ErrNoAccount = errors.New("no account for this user")
+)
+
+// проверка логина и пароля одним из источников (база, каталог LDAP)
+type Authenticator interface {
+	Authenticate(ctx context.Context, login, password string) (*Identity, error)
+}
+
+// Chain пробует источники по порядку до первого успеха.
+// неверный пароль в одном источнике не мешает проверить следующий,
+// остальные ошибки возвращаются если никто не подтвердил пароль
+type Chain []Authenticator
+
+func (c Chain) Authenticate(ctx context.Context, login, password string) (*Identity, error) {
+	result := ErrInvalidCredentials
+	for _, a := range c {
+		identity, err := a.Authenticate(ctx, login, password)
+		if err == nil {
+			return identity, nil
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			result = err
+		}
+	}
+	return nil, result
+}
@@ -0,0 +1,162 @@
+package authn
+
+import (
+	"database/sql"
+	"log/slog"
+)
+
+// пользователь по данным внешнего провайдера
+type ExternalUser struct {
+	// "oidc" или "ldap"
+	Provider string
+	// постоянный id пользователя у провайдера: sub из id_token, DN в каталоге
+	Subject string
+	Login   string
+	Email   string
+	// провайдер подтвердил владение почтой (email_verified)
+	EmailVerified bool
+	FullName      string
+	Role          string
+	GroupName     string
+}
+
+// сопоставление внешнего пользователя с учётной записью.
+// роль и группу провайдер меняет только у записей, созданных им же (AuthProvider, ExternalSubject);
+// группа не сбрасывается, если провайдер её не прислал или её нет в базе.
+// с linkLocal вход в совпавшую по подтверждённой почте чужую запись разрешён без изменения роли и группы,
+// иначе совпадение по логину или почте - конфликт и ErrNoAccount. при provision недостающая запись создаётся
+func UpsertExternalUser(db *sql.DB, user ExternalUser, provision, linkLocal bool) (*Identity, error) {
+	var groupID sql.NullInt64
+	if user.Role == "Student" && user.GroupName != "" {
+		err := db.QueryRow(`SELECT id FROM groups WHERE NumGroup = ?`, user.GroupName).Scan(&groupID)
+		if err == sql.ErrNoRows && provision {
+			result, err := db.Exec(`INSERT INTO groups (NumGroup) VALUES (?)`, user.GroupName)
+			if err != nil {
+				return nil, err
+			}
+			id, _ := result.LastInsertId()
+			groupID = sql.NullInt64{Int64: id, Valid: true}
+		} else if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+
+	var id int64
+	err := db.QueryRow(`SELECT id FROM user WHERE AuthProvider = ? AND ExternalSubject = ?`,
+		user.Provider, user.Subject).Scan(&id)
+	if err == sql.ErrNoRows {
+		id, err = matchExternalUser(db, user, provision, linkLocal, groupID)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else {
+		// провайдер - источник правды по роли и группе своих записей
+		_, err = db.Exec(`
+			UPDATE user SET Role = ?, GroupId = COALESCE(?, GroupId), FullName = COALESCE(NULLIF(?, ''), FullName)
+			WHERE id = ?`,
+			user.Role, groupID, user.FullName, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// логин, роль и группа из базы: у привязанной записи они могут отличаться
+	identity := &Identity{UserID: id}
+	var storedGroup sql.NullInt64
+	err = db.QueryRow(`SELECT Login, FullName, Role, GroupId FROM user WHERE id = ?`, id).
+		Scan(&identity.Login, &identity.FullName, &identity.Role, &storedGroup)
+	if err != nil {
+		return nil, err
+	}
+	identity.GroupID = storedGroup.Int64
+	return identity, nil
+}
+
+// поиск записи, ещё не привязанной к провайдеру, иначе создание.
+// с linkLocal ищется только по подтверждённой почте, без linkLocal - по логину или почте.
+// несколько совпавших записей - неоднозначность, вход отклоняется
+func matchExternalUser(db *sql.DB, user ExternalUser, provision, linkLocal bool, groupID sql.NullInt64) (int64, error) {
+	query := `SELECT id, COALESCE(PassHash, ''), AuthProvider FROM user
+		WHERE Login = ? OR (Email IS NOT NULL AND Email = ?) LIMIT 2`
+	args := []any{user.Login, user.Email}
+	if linkLocal {
+		if user.Email == "" || !user.EmailVerified {
+			slog.Warn("external login without verified email", "provider", user.Provider, "login", user.Login)
+			return 0, ErrNoAccount
+		}
+		query = `SELECT id, COALESCE(PassHash, ''), AuthProvider FROM user
+			WHERE Email = ? COLLATE NOCASE LIMIT 2`
+		args = []any{user.Email}
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var (
+		id       int64
+		passHash string
+		provider sql.NullString
+		matches  int
+	)
+	for rows.Next() {
+		if err := rows.Scan(&id, &passHash, &provider); err != nil {
+			return 0, err
+		}
+		matches++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	if matches > 1 {
+		slog.Warn("external login matches several accounts", "provider", user.Provider, "login", user.Login)
+		return 0, ErrNoAccount
+	}
+	if matches == 0 {
+		if !provision {
+			return 0, ErrNoAccount
+		}
+		// логин занят записью с другой почтой - не создаём и не привязываем
+		var taken int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM user WHERE Login = ?`, user.Login).Scan(&taken); err != nil {
+			return 0, err
+		}
+		if taken > 0 {
+			slog.Warn("external login is taken by another account", "provider", user.Provider, "login", user.Login)
+			return 0, ErrNoAccount
+		}
+		result, err := db.Exec(`
+			INSERT INTO user (Login, PassHash, FullName, Role, GroupId, Email, AuthProvider, ExternalSubject)
+			VALUES (?, '', COALESCE(NULLIF(?, ''), ?), ?, ?, NULLIF(?, ''), ?, ?)`,
+			user.Login, user.FullName, user.Login, user.Role, groupID, user.Email, user.Provider, user.Subject)
+		if err != nil {
+			return 0, err
+		}
+		id, _ = result.LastInsertId()
+		slog.Info("provisioned user", "provider", user.Provider, "login", user.Login, "role", user.Role)
+		return id, nil
+	}
+
+	// без пароля и без отметки - создана провайдером до появления AuthProvider, привязывается
+	if !provider.Valid && passHash == "" {
+		_, err = db.Exec(`
+			UPDATE user SET AuthProvider = ?, ExternalSubject = ?, Role = ?, GroupId = COALESCE(?, GroupId),
+				FullName = COALESCE(NULLIF(?, ''), FullName)
+			WHERE id = ?`,
+			user.Provider, user.Subject, user.Role, groupID, user.FullName, id)
+		return id, err
+	}
+
+	// локальная запись или запись другого провайдера: роль и группу не трогаем
+	if !linkLocal {
+		slog.Warn("external login matches account of another source",
+			"provider", user.Provider, "login", user.Login, "user_id", id, "account_provider", provider.String)
+		return 0, ErrNoAccount
+	}
+	return id, nil
+}
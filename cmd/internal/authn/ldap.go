@@ -0,0 +1,215 @@
+package authn
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"qr_code/internal/database"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// настройки каталога (заполняются из config.LDAP)
+type LDAPSettings struct {
+	URL                string
+	StartTLS           bool
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+	// служебная учётка для поиска; пустой BindDN - анонимный поиск
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// %s заменяется на экранированный логин
+	UserFilter string
+	// атрибуты записи пользователя
+	FullNameAttribute string
+	EmailAttribute    string
+	RoleAttribute     string
+	GroupAttribute    string
+	// значения RoleAttribute (например DN групп в memberOf); остальные - Student
+	TeacherValues []string
+	AdminValues   []string
+	// создавать локального пользователя при первом входе
+	JITProvisioning bool
+}
+
+// то, что нужно от соединения с каталогом; в тестах подменяется
+type ldapConn interface {
+	StartTLS(config *tls.Config) error
+	Bind(username, password string) error
+	Search(request *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+// данные пользователя из каталога
+type directoryEntry struct {
+	DN        string
+	FullName  string
+	Email     string
+	Role      string
+	GroupName string
+}
+
+// проверка пароля bind'ом в LDAP: поиск записи по логину, bind её DN с паролем
+type LDAP struct {
+	settings LDAPSettings
+	dial     func(settings LDAPSettings) (ldapConn, error)
+}
+
+func NewLDAP(settings LDAPSettings) *LDAP {
+	if settings.UserFilter == "" {
+		settings.UserFilter = "(uid=%s)"
+	}
+	if settings.Timeout <= 0 {
+		settings.Timeout = 5 * time.Second
+	}
+	return &LDAP{settings: settings, dial: dialLDAP}
+}
+
+// tls для ldaps:// и StartTLS; StartTLS в go-ldap не подставляет ServerName сам,
+// без него проверка сертификата не проходит
+func ldapTLSConfig(settings LDAPSettings) (*tls.Config, error) {
+	u, err := url.Parse(settings.URL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap url: %w", err)
+	}
+	return &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: settings.InsecureSkipVerify,
+	}, nil
+}
+
+func dialLDAP(settings LDAPSettings) (ldapConn, error) {
+	tlsConfig, err := ldapTLSConfig(settings)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := ldap.DialURL(settings.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: settings.Timeout}),
+		ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(settings.Timeout)
+	if settings.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// входить можно только в записи, созданные из каталога: совпадение uid или почты
+// с локальной учётной записью - конфликт (ErrNoAccount), а не вход в неё
+func (l *LDAP) Authenticate(ctx context.Context, login, password string) (*Identity, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	entry, err := l.lookup(login, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return UpsertExternalUser(database.Get(), ExternalUser{
+		Provider:  "ldap",
+		Subject:   entry.DN,
+		Login:     login,
+		Email:     entry.Email,
+		FullName:  entry.FullName,
+		Role:      entry.Role,
+		GroupName: entry.GroupName,
+	}, l.settings.JITProvisioning, false)
+}
+
+// поиск и проверка пароля в каталоге, без обращения к базе
+func (l *LDAP) lookup(login, password string) (*directoryEntry, error) {
+	// пустой пароль в LDAP - анонимный bind, который "успешен" для любого DN
+	if password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := l.dial(l.settings)
+	if err != nil {
+		return nil, fmt.Errorf("ldap dial: %w", err)
+	}
+	defer conn.Close()
+
+	if l.settings.BindDN != "" {
+		if err := conn.Bind(l.settings.BindDN, l.settings.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap service bind: %w", err)
+		}
+	}
+
+	attributes := []string{"dn"}
+	for _, attr := range []string{l.settings.FullNameAttribute, l.settings.EmailAttribute,
+		l.settings.RoleAttribute, l.settings.GroupAttribute} {
+		if attr != "" {
+			attributes = append(attributes, attr)
+		}
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(
+		l.settings.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(l.settings.Timeout.Seconds()), false,
+		strings.ReplaceAll(l.settings.UserFilter, "%s", ldap.EscapeFilter(login)),
+		attributes,
+		nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("ldap search: %w", err)
+	}
+	// не найден или неоднозначен - как неверный пароль
+	if result == nil || len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	record := result.Entries[0]
+
+	if err := conn.Bind(record.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap bind: %w", err)
+	}
+
+	entry := &directoryEntry{
+		DN:   record.DN,
+		Role: l.role(record.GetAttributeValues(l.settings.RoleAttribute)),
+	}
+	if l.settings.FullNameAttribute != "" {
+		entry.FullName = record.GetAttributeValue(l.settings.FullNameAttribute)
+	}
+	if l.settings.EmailAttribute != "" {
+		entry.Email = strings.ToLower(record.GetAttributeValue(l.settings.EmailAttribute))
+	}
+	if l.settings.GroupAttribute != "" {
+		entry.GroupName = record.GetAttributeValue(l.settings.GroupAttribute)
+	}
+	return entry, nil
+}
+
+// роль по значениям атрибута; DN сравниваются без учёта регистра
+func (l *LDAP) role(values []string) string {
+	if containsFold(values, l.settings.AdminValues) {
+		return "Admin"
+	}
+	if containsFold(values, l.settings.TeacherValues) {
+		return "Teacher"
+	}
+	return "Student"
+}
+
+func containsFold(values, wanted []string) bool {
+	for _, v := range values {
+		for _, w := range wanted {
+			if strings.EqualFold(strings.TrimSpace(v), strings.TrimSpace(w)) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,272 @@
+package authn
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"qr_code/internal/config"
+	"qr_code/internal/database"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// fakeDirectory - каталог в памяти вместо LDAP сервера
+type fakeDirectory struct {
+	serviceDN       string
+	servicePassword string
+	// uid -> запись
+	users map[string]fakeUser
+	binds []string
+}
+
+type fakeUser struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+type fakeConn struct {
+	dir *fakeDirectory
+}
+
+func (c *fakeConn) StartTLS(*tls.Config) error { return nil }
+func (c *fakeConn) Close() error               { return nil }
+
+func (c *fakeConn) Bind(username, password string) error {
+	c.dir.binds = append(c.dir.binds, username)
+	if username == c.dir.serviceDN && password == c.dir.servicePassword {
+		return nil
+	}
+	for _, u := range c.dir.users {
+		if u.dn == username && u.password == password {
+			return nil
+		}
+	}
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+}
+
+func (c *fakeConn) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	result := &ldap.SearchResult{}
+	for uid, u := range c.dir.users {
+		if request.Filter != "(uid="+ldap.EscapeFilter(uid)+")" {
+			continue
+		}
+		entry := &ldap.Entry{DN: u.dn}
+		for _, name := range request.Attributes {
+			if values, ok := u.attrs[name]; ok {
+				entry.Attributes = append(entry.Attributes, &ldap.EntryAttribute{Name: name, Values: values})
+			}
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+	return result, nil
+}
+
+func newTestLDAP(dir *fakeDirectory) *LDAP {
+	l := NewLDAP(LDAPSettings{
+		URL:               "ldap://directory.test",
+		BindDN:            "cn=reader,dc=uni,dc=example",
+		BindPassword:      "reader-secret",
+		BaseDN:            "ou=people,dc=uni,dc=example",
+		FullNameAttribute: "cn",
+		EmailAttribute:    "mail",
+		RoleAttribute:     "memberOf",
+		GroupAttribute:    "departmentNumber",
+		TeacherValues:     []string{"cn=teachers,ou=groups,dc=uni,dc=example"},
+		AdminValues:       []string{"cn=admins,ou=groups,dc=uni,dc=example"},
+	})
+	l.dial = func(LDAPSettings) (ldapConn, error) { return &fakeConn{dir: dir}, nil }
+	return l
+}
+
+func newFakeDirectory() *fakeDirectory {
+	return &fakeDirectory{
+		serviceDN:       "cn=reader,dc=uni,dc=example",
+		servicePassword: "reader-secret",
+		users: map[string]fakeUser{
+			"petrov": {
+				dn:       "uid=petrov,ou=people,dc=uni,dc=example",
+				password: "secret-1",
+				attrs: map[string][]string{
+					"cn":       {"Петров Пётр"},
+					"mail":     {"Petrov@Uni.example"},
+					"memberOf": {"CN=Teachers,OU=Groups,DC=uni,DC=example"},
+				},
+			},
+			"sidorov": {
+				dn:       "uid=sidorov,ou=people,dc=uni,dc=example",
+				password: "secret-2",
+				attrs: map[string][]string{
+					"cn":               {"Сидоров Сидор"},
+					"departmentNumber": {"Д-Э 309"},
+				},
+			},
+		},
+	}
+}
+
+// TestLDAPLookup проверяет чтение атрибутов и сопоставление роли
+func TestLDAPLookup(t *testing.T) {
+	l := newTestLDAP(newFakeDirectory())
+
+	entry, err := l.lookup("petrov", "secret-1")
+	if err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	if entry.FullName != "Петров Пётр" || entry.Email != "petrov@uni.example" || entry.Role != "Teacher" {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+
+	entry, err = l.lookup("sidorov", "secret-2")
+	if err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	if entry.Role != "Student" || entry.GroupName != "Д-Э 309" {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+}
+
+// TestLDAPLookupRejects проверяет что неверные данные дают ErrInvalidCredentials
+func TestLDAPLookupRejects(t *testing.T) {
+	testCases := []struct {
+		name     string
+		login    string
+		password string
+	}{
+		{"wrong password", "petrov", "wrong"},
+		{"unknown user", "nobody", "secret-1"},
+		{"empty password", "petrov", ""},
+		{"filter injection", "*", "secret-1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := newTestLDAP(newFakeDirectory())
+			if _, err := l.lookup(tc.login, tc.password); !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("Expected ErrInvalidCredentials, got %v", err)
+			}
+		})
+	}
+}
+
+// TestLDAPServiceBindFailure проверяет что сбой служебной учётки не выдаётся за неверный пароль
+func TestLDAPServiceBindFailure(t *testing.T) {
+	dir := newFakeDirectory()
+	dir.servicePassword = "rotated"
+	l := newTestLDAP(dir)
+
+	_, err := l.lookup("petrov", "secret-1")
+	if err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected backend error, got %v", err)
+	}
+}
+
+type stubAuthenticator struct {
+	identity *Identity
+	err      error
+}
+
+func (s stubAuthenticator) Authenticate(context.Context, string, string) (*Identity, error) {
+	return s.identity, s.err
+}
+
+// TestChain проверяет порядок источников и выбор ошибки
+func TestChain(t *testing.T) {
+	ok := stubAuthenticator{identity: &Identity{UserID: 7}}
+	invalid := stubAuthenticator{err: ErrInvalidCredentials}
+	down := stubAuthenticator{err: errors.New("directory unavailable")}
+
+	if identity, err := (Chain{invalid, ok}).Authenticate(context.Background(), "a", "b"); err != nil || identity.UserID != 7 {
+		t.Errorf("Expected second backend to succeed, got %v, %v", identity, err)
+	}
+	if _, err := (Chain{invalid, invalid}).Authenticate(context.Background(), "a", "b"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials, got %v", err)
+	}
+	if _, err := (Chain{invalid, down}).Authenticate(context.Background(), "a", "b"); err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected backend error, got %v", err)
+	}
+}
+
+// TestLDAPAuthenticateAccounts проверяет создание записи из каталога и отказ входить в локальную
+func TestLDAPAuthenticateAccounts(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "test.yaml")
+	configYAML := "env: \"local\"\nstorage_path: \"" + filepath.Join(dir, "db.sqlite") + "\"\n"
+	if err := os.WriteFile(configPath, []byte(configYAML), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", configPath)
+	config.MustLoad()
+	database.MustInit()
+	defer database.Close()
+	db := database.Get()
+
+	// локальный преподаватель с тем же логином, что у студента в каталоге
+	_, err := db.Exec(`INSERT INTO user (Login, PassHash, FullName, Role) VALUES ('sidorov', '5f4dcc3b5aa765d61d8327deb882cf99', 'Сидоров Локальный', 'Teacher')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := newTestLDAP(newFakeDirectory())
+	l.settings.JITProvisioning = true
+	if _, err := l.Authenticate(context.Background(), "sidorov", "secret-2"); !errors.Is(err, ErrNoAccount) {
+		t.Errorf("Expected ErrNoAccount for local account, got %v", err)
+	}
+	var role string
+	var provider sql.NullString
+	db.QueryRow(`SELECT Role, AuthProvider FROM user WHERE Login = 'sidorov'`).Scan(&role, &provider)
+	if role != "Teacher" || provider.Valid {
+		t.Errorf("Local account changed: %s, %v", role, provider)
+	}
+
+	identity, err := l.Authenticate(context.Background(), "petrov", "secret-1")
+	if err != nil || identity.Login != "petrov" || identity.Role != "Teacher" {
+		t.Fatalf("Unexpected identity %+v, %v", identity, err)
+	}
+	again, err := l.Authenticate(context.Background(), "petrov", "secret-1")
+	if err != nil || again.UserID != identity.UserID {
+		t.Errorf("Expected the same provisioned account, got %+v, %v", again, err)
+	}
+}
+
+// TestLDAPTLSConfig проверяет что StartTLS проверяет сертификат по хосту из url:
+// go-ldap вызывает tls.Client с этим конфигом, как и тест ниже
+func TestLDAPTLSConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	address := strings.TrimPrefix(server.URL, "https://")
+
+	tlsConfig, err := ldapTLSConfig(LDAPSettings{URL: "ldap://" + address})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ServerName != "127.0.0.1" || tlsConfig.InsecureSkipVerify {
+		t.Fatalf("Unexpected tls config: server name %q, insecure %v", tlsConfig.ServerName, tlsConfig.InsecureSkipVerify)
+	}
+	tlsConfig.RootCAs = roots
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := tls.Client(conn, tlsConfig).Handshake(); err != nil {
+		t.Errorf("Handshake with verification failed: %v", err)
+	}
+
+	tlsConfig, err = ldapTLSConfig(LDAPSettings{URL: "ldaps://dir.uni.example:636"})
+	if err != nil || tlsConfig.ServerName != "dir.uni.example" {
+		t.Errorf("Expected server name dir.uni.example, got %+v, %v", tlsConfig, err)
+	}
+}
@@ -0,0 +1,31 @@
+package authn
+
+import (
+	"context"
+	"database/sql"
+	"qr_code/internal/database"
+	"qr_code/internal/password"
+)
+
+// проверка пароля по хешу в таблице user
+type SQLite struct{}
+
+func (SQLite) Authenticate(ctx context.Context, login, pw string) (*Identity, error) {
+	var (
+		identity Identity
+		groupID  sql.NullInt64
+	)
+	// у внешних пользователей (LDAP, OIDC) PassHash пустой и сюда не совпадёт
+	err := database.Get().QueryRowContext(ctx,
+		"SELECT id, Login, FullName, Role, GroupId FROM user WHERE Login = ? AND PassHash = ? LIMIT 1",
+		login, password.Hash(pw),
+	).Scan(&identity.UserID, &identity.Login, &identity.FullName, &identity.Role, &groupID)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	identity.GroupID = groupID.Int64
+	return &identity, nil
+}
@@ -5,9 +5,11 @@ import (
 	"crypto/cipher"
 	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"io"
 )
 
@@ -17,6 +19,21 @@ func MD5(input string) string {
 	return hex.EncodeToString(hash[:])
 }
 
+// sha256 от строки (для хранения одноразовых токенов)
+func SHA256(input string) string {
+	hash := sha256.Sum256([]byte(input))
+	return hex.EncodeToString(hash[:])
+}
+
+// случайный токен из n байт в base64 (url-safe, без паддинга)
+func RandomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
 func EncodeBase64(text string) string {
 	return base64.StdEncoding.EncodeToString([]byte(text))
 }
@@ -70,7 +87,11 @@ func DecryptAES(encrypted string, secretKey []byte) (map[string]interface{}, err
 		return nil, err
 	}
 
+	// строка приходит от клиента (cookie, challenge) и может быть любой длины
 	nonceSize := gcm.NonceSize()
+	if len(data) < nonceSize {
+		return nil, errors.New("ciphertext too short")
+	}
 	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
 
 	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
@@ -1,9 +1,16 @@
 package config
 
 import (
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,16 +18,271 @@ import (
 )
 
 type Config struct {
-	Env         string `yaml:"env" env-default:"local"`
-	StoragePath string `yaml:"storage_path" env-required:"true"`
-	HTTPServer  `yaml:"http_server"`
+	// local | dev | prod
+	Env string `yaml:"env" env:"QR_ENV"`
+	// относительный путь - от папки db в base_dir
+	StoragePath string `yaml:"storage_path" env:"QR_STORAGE_PATH" env-required:"true"`
+	// от неё считаются относительные пути; пусто - папка над папкой с конфигом
+	BaseDir string `yaml:"base_dir" env:"QR_BASE_DIR"`
+	// откуда прочитан конфиг
+	Path        string `yaml:"-"`
+	HTTPServer  `yaml:"http_server" env-prefix:"QR_HTTP_SERVER_"`
+	RateLimit   `yaml:"rate_limit" env-prefix:"QR_RATE_LIMIT_"`
+	Password    `yaml:"password" env-prefix:"QR_PASSWORD_"`
+	Notifier    `yaml:"notifier" env-prefix:"QR_NOTIFIER_"`
+	TOTP        `yaml:"totp" env-prefix:"QR_TOTP_"`
+	OIDC        `yaml:"oidc" env-prefix:"QR_OIDC_"`
+	Auth        `yaml:"auth" env-prefix:"QR_AUTH_"`
+	LDAP        `yaml:"ldap" env-prefix:"QR_LDAP_"`
+	CSRF        `yaml:"csrf" env-prefix:"QR_CSRF_"`
+	CORS        `yaml:"cors" env-prefix:"QR_CORS_"`
+	Metrics     `yaml:"metrics" env-prefix:"QR_METRICS_"`
+	Health      `yaml:"health" env-prefix:"QR_HEALTH_"`
+	I18n        `yaml:"i18n" env-prefix:"QR_I18N_"`
+	Trash       `yaml:"trash" env-prefix:"QR_TRASH_"`
+	AutoArchive `yaml:"auto_archive" env-prefix:"QR_AUTO_ARCHIVE_"`
 }
 
 type HTTPServer struct {
-	HttpAddress  string        `yaml:"httpAddress" env-default:":80"`
-	HttpsAddress string        `yaml:"httpsAddress" env-default:":443"`
-	Timeout      time.Duration `yaml:"timeout" env-default:"4s"`
-	IdleTimeout  time.Duration `yaml:"idle_timeout" env-default:"60s"`
+	HttpAddress  string        `yaml:"httpAddress" env:"HTTP_ADDRESS"`
+	HttpsAddress string        `yaml:"httpsAddress" env:"HTTPS_ADDRESS"`
+	Timeout      time.Duration `yaml:"timeout" env:"TIMEOUT"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout" env:"IDLE_TIMEOUT"`
+	CertFile     string        `yaml:"cert_file" env:"CERT_FILE"`
+	KeyFile      string        `yaml:"key_file" env:"KEY_FILE"`
+	// "1.2" или "1.3"
+	TLSMinVersion string `yaml:"tls_min_version" env:"TLS_MIN_VERSION"`
+	// имена наборов шифров Go для TLS 1.2 (TLS_ECDHE_...); пусто - набор Go по умолчанию
+	TLSCipherSuites []string `yaml:"tls_cipher_suites" env:"TLS_CIPHER_SUITES" env-separator:","`
+	// как часто проверять файлы сертификата на изменение; 0 - только по SIGHUP
+	CertReloadInterval time.Duration `yaml:"cert_reload_interval" env:"CERT_RELOAD_INTERVAL"`
+	// создать самоподписанный сертификат, если файла нет (только для разработки)
+	SelfSignedDevCert bool `yaml:"self_signed_dev_cert" env:"SELF_SIGNED_DEV_CERT"`
+	// сколько ждать завершения текущих запросов при остановке
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+	// HTTP только перенаправляет на HTTPS (кроме проверки здоровья)
+	RedirectHTTP bool `yaml:"redirect_http" env:"REDIRECT_HTTP"`
+	// Strict-Transport-Security на HTTPS; 0 - заголовок не отправляется
+	HSTSMaxAge            time.Duration `yaml:"hsts_max_age" env:"HSTS_MAX_AGE"`
+	HSTSIncludeSubdomains bool          `yaml:"hsts_include_subdomains" env:"HSTS_INCLUDE_SUBDOMAINS"`
+}
+
+// ограничение попыток входа; 0 в *_per_minute выключает лимит
+type RateLimit struct {
+	// по ip лимит выше: студенты одной аудитории выходят через общий NAT
+	AuthPerIPPerMinute    int           `yaml:"auth_per_ip_per_minute" env:"AUTH_PER_IP_PER_MINUTE"`
+	AuthIPBurst           int           `yaml:"auth_ip_burst" env:"AUTH_IP_BURST"`
+	AuthPerLoginPerMinute int           `yaml:"auth_per_login_per_minute" env:"AUTH_PER_LOGIN_PER_MINUTE"`
+	AuthLoginBurst        int           `yaml:"auth_login_burst" env:"AUTH_LOGIN_BURST"`
+	LockoutThreshold      int           `yaml:"lockout_threshold" env:"LOCKOUT_THRESHOLD"`
+	LockoutBase           time.Duration `yaml:"lockout_base" env:"LOCKOUT_BASE"`
+	LockoutMax            time.Duration `yaml:"lockout_max" env:"LOCKOUT_MAX"`
+}
+
+type Password struct {
+	MinLength     int           `yaml:"min_length" env:"MIN_LENGTH"`
+	ResetTokenTTL time.Duration `yaml:"reset_token_ttl" env:"RESET_TOKEN_TTL"`
+	// ссылка из письма сброса, к ней дописывается токен
+	ResetURL string `yaml:"reset_url" env:"RESET_URL"`
+}
+
+// доставка писем: console | file | smtp
+type Notifier struct {
+	Kind         string `yaml:"kind" env:"KIND"`
+	FilePath     string `yaml:"file_path" env:"FILE_PATH"`
+	SMTPAddress  string `yaml:"smtp_address" env:"SMTP_ADDRESS"`
+	SMTPUsername string `yaml:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword string `yaml:"smtp_password" env:"SMTP_PASSWORD"`
+	From         string `yaml:"from" env:"FROM"`
+}
+
+// двухфакторная аутентификация
+type TOTP struct {
+	Issuer string `yaml:"issuer" env:"ISSUER"`
+	// роли, которым без второго фактора вход не выдаётся
+	RequiredRoles []string `yaml:"required_roles" env:"REQUIRED_ROLES" env-separator:","`
+}
+
+// вход через университетский OpenID Connect провайдер
+type OIDC struct {
+	Enabled      bool     `yaml:"enabled" env:"ENABLED"`
+	Issuer       string   `yaml:"issuer" env:"ISSUER"`
+	ClientID     string   `yaml:"client_id" env:"CLIENT_ID"`
+	ClientSecret string   `yaml:"client_secret" env:"CLIENT_SECRET"`
+	RedirectURL  string   `yaml:"redirect_url" env:"REDIRECT_URL"`
+	Scopes       []string `yaml:"scopes" env:"SCOPES" env-separator:","`
+	GroupsClaim  string   `yaml:"groups_claim" env:"GROUPS_CLAIM"`
+	// роли по группам провайдера; остальные - Student
+	TeacherGroups []string `yaml:"teacher_groups" env:"TEACHER_GROUPS" env-separator:","`
+	AdminGroups   []string `yaml:"admin_groups" env:"ADMIN_GROUPS" env-separator:","`
+	// группа провайдера "<prefix><NumGroup>" задаёт учебную группу студента
+	StudentGroupPrefix string `yaml:"student_group_prefix" env:"STUDENT_GROUP_PREFIX"`
+	// создавать пользователя при первом входе
+	JITProvisioning bool `yaml:"jit_provisioning" env:"JIT_PROVISIONING"`
+	// куда вернуть браузер после входа
+	PostLoginRedirect string `yaml:"post_login_redirect" env:"POST_LOGIN_REDIRECT"`
+}
+
+// проверка логина и пароля в /auth
+type Auth struct {
+	// источники по порядку: sqlite, ldap
+	Backends []string `yaml:"backends" env:"BACKENDS" env-separator:","`
+}
+
+// каталог факультета (bind + search)
+type LDAP struct {
+	// ldap://host:389 или ldaps://host:636
+	URL                string        `yaml:"url" env:"URL"`
+	StartTLS           bool          `yaml:"start_tls" env:"START_TLS"`
+	InsecureSkipVerify bool          `yaml:"insecure_skip_verify" env:"INSECURE_SKIP_VERIFY"`
+	Timeout            time.Duration `yaml:"timeout" env:"TIMEOUT"`
+	// служебная учётка для поиска пользователя; пустая - анонимный поиск
+	BindDN       string `yaml:"bind_dn" env:"BIND_DN"`
+	BindPassword string `yaml:"bind_password" env:"BIND_PASSWORD"`
+	BaseDN       string `yaml:"base_dn" env:"BASE_DN"`
+	UserFilter   string `yaml:"user_filter" env:"USER_FILTER"`
+	// атрибуты записи: ФИО, почта, роль, номер группы
+	FullNameAttribute string `yaml:"full_name_attribute" env:"FULL_NAME_ATTRIBUTE"`
+	EmailAttribute    string `yaml:"email_attribute" env:"EMAIL_ATTRIBUTE"`
+	RoleAttribute     string `yaml:"role_attribute" env:"ROLE_ATTRIBUTE"`
+	GroupAttribute    string `yaml:"group_attribute" env:"GROUP_ATTRIBUTE"`
+	// значения атрибута роли; остальные - Student
+	TeacherValues []string `yaml:"teacher_values" env:"TEACHER_VALUES" env-separator:";"`
+	AdminValues   []string `yaml:"admin_values" env:"ADMIN_VALUES" env-separator:";"`
+	// создавать пользователя при первом входе
+	JITProvisioning bool `yaml:"jit_provisioning" env:"JIT_PROVISIONING"`
+}
+
+// защита от подделки запросов с cookie
+type CSRF struct {
+	// источники фронтенда на другом хосте, например "https://attendance.example.org"
+	TrustedOrigins []string `yaml:"trusted_origins" env:"TRUSTED_ORIGINS" env-separator:","`
+}
+
+// запросы фронтенда с другого origin; пустые списки - значения по умолчанию для env
+type CORS struct {
+	// точные ("https://app.example.org") или с поддоменами ("https://*.example.org")
+	AllowedOrigins   []string      `yaml:"allowed_origins" env:"ALLOWED_ORIGINS" env-separator:","`
+	AllowedMethods   []string      `yaml:"allowed_methods" env:"ALLOWED_METHODS" env-separator:","`
+	AllowedHeaders   []string      `yaml:"allowed_headers" env:"ALLOWED_HEADERS" env-separator:","`
+	AllowCredentials bool          `yaml:"allow_credentials" env:"ALLOW_CREDENTIALS"`
+	MaxAge           time.Duration `yaml:"max_age" env:"MAX_AGE"`
+}
+
+// эндпоинт для Prometheus
+type Metrics struct {
+	Enabled bool   `yaml:"enabled" env:"ENABLED"`
+	Path    string `yaml:"path" env:"PATH"`
+	// если задан, запрос должен прийти с "Authorization: Bearer <token>"
+	BearerToken string `yaml:"bearer_token" env:"BEARER_TOKEN"`
+}
+
+// проверка готовности /readyz
+type Health struct {
+	DBTimeout time.Duration `yaml:"db_timeout" env:"DB_TIMEOUT"`
+	// сертификат, которому осталось меньше стольких дней, делает сервис неготовым
+	CertMinValidDays int `yaml:"cert_min_valid_days" env:"CERT_MIN_VALID_DAYS"`
+}
+
+// язык ответов, если его не задал ни пользователь, ни Accept-Language
+type I18n struct {
+	DefaultLanguage string `yaml:"default_language" env:"DEFAULT_LANGUAGE"`
+}
+
+// корзина: удалённые из архива занятия можно восстановить retention_days дней, потом они удаляются совсем
+type Trash struct {
+	RetentionDays int `yaml:"retention_days" env:"RETENTION_DAYS"`
+	// как часто искать занятия с истёкшим сроком хранения
+	PurgeInterval time.Duration `yaml:"purge_interval" env:"PURGE_INTERVAL"`
+}
+
+// автоархивация: текущие занятия с датой старше after_days дней переносятся в архив
+type AutoArchive struct {
+	Enabled   bool          `yaml:"enabled" env:"ENABLED"`
+	AfterDays int           `yaml:"after_days" env:"AFTER_DAYS"`
+	Interval  time.Duration `yaml:"interval" env:"INTERVAL"`
+	// письмо преподавателю со списком перенесённых занятий (если указана почта)
+	NotifyTeachers bool `yaml:"notify_teachers" env:"NOTIFY_TEACHERS"`
+}
+
+// значения по умолчанию для полей, не заданных ни в файле, ни в окружении
+func defaultConfig() Config {
+	return Config{
+		Env: "local",
+		HTTPServer: HTTPServer{
+			HttpAddress:        ":80",
+			HttpsAddress:       ":443",
+			Timeout:            4 * time.Second,
+			IdleTimeout:        60 * time.Second,
+			CertFile:           "cert/server.crt",
+			KeyFile:            "cert/server.key",
+			TLSMinVersion:      "1.2",
+			CertReloadInterval: time.Minute,
+			ShutdownTimeout:    15 * time.Second,
+		},
+		RateLimit: RateLimit{
+			AuthPerIPPerMinute:    120,
+			AuthIPBurst:           30,
+			AuthPerLoginPerMinute: 10,
+			AuthLoginBurst:        5,
+			LockoutThreshold:      5,
+			LockoutBase:           time.Minute,
+			LockoutMax:            time.Hour,
+		},
+		Password: Password{
+			MinLength:     8,
+			ResetTokenTTL: time.Hour,
+			ResetURL:      "http://localhost/reset-password?token=",
+		},
+		Notifier: Notifier{
+			Kind:     "console",
+			FilePath: "mail.log",
+			From:     "noreply@localhost",
+		},
+		TOTP: TOTP{
+			Issuer: "QR Attendance",
+		},
+		OIDC: OIDC{
+			Scopes:             []string{"openid", "email", "profile"},
+			GroupsClaim:        "groups",
+			StudentGroupPrefix: "student:",
+			PostLoginRedirect:  "/",
+		},
+		Auth: Auth{
+			Backends: []string{"sqlite"},
+		},
+		LDAP: LDAP{
+			Timeout:           5 * time.Second,
+			UserFilter:        "(uid=%s)",
+			FullNameAttribute: "cn",
+			EmailAttribute:    "mail",
+			RoleAttribute:     "memberOf",
+			GroupAttribute:    "departmentNumber",
+		},
+		CORS: CORS{
+			AllowCredentials: true,
+			MaxAge:           10 * time.Minute,
+		},
+		Metrics: Metrics{
+			Enabled: true,
+			Path:    "/metrics",
+		},
+		Health: Health{
+			DBTimeout:        2 * time.Second,
+			CertMinValidDays: 7,
+		},
+		I18n: I18n{
+			DefaultLanguage: "en",
+		},
+		Trash: Trash{
+			RetentionDays: 30,
+			PurgeInterval: time.Hour,
+		},
+		AutoArchive: AutoArchive{
+			AfterDays: 30,
+			Interval:  time.Hour,
+		},
+	}
 }
 
 var (
@@ -28,39 +290,206 @@ var (
 	once     sync.Once
 )
 
+// MustLoad читает конфиг один раз за запуск; при ошибке печатает все проблемы и завершает процесс
 func MustLoad() *Config {
 	once.Do(func() {
-		currentDir, err := os.Getwd()
+		configPath, err := fetchConfigPath()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "config error: %s\n", err)
+			os.Exit(1)
+		}
+		cfg, err := Load(configPath)
 		if err != nil {
-			log.Fatalf("path error: %s", err)
+			fmt.Fprintf(os.Stderr, "config error (%s):\n%s\n", configPath, err)
+			os.Exit(1)
 		}
-		configPath := filepath.Join(currentDir, "config", "local.yaml")
-		configPath = filepath.Clean(configPath)
+		instance = cfg
+	})
+
+	return instance
+}
 
-		if configPath == "" {
-			log.Fatal("configPath is empty")
+// Load читает файл, применяет переменные окружения QR_* и проверяет значения
+func Load(configPath string) (*Config, error) {
+	if _, err := os.Stat(configPath); err != nil {
+		return nil, fmt.Errorf("error opening config file: %w", err)
+	}
+
+	// значения по умолчанию до чтения файла: явные 0, false и "" из файла их перекрывают
+	// (env-default у cleanenv подставляется вместо любого нулевого значения)
+	cfg := defaultConfig()
+	cfg.Path = configPath
+	if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	if err := cfg.resolvePaths(configPath); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// путь к конфигу: флаг --config, затем CONFIG_PATH, затем config/<env>.yaml
+// (env из QR_ENV, по умолчанию local) в текущей папке или рядом с бинарником
+func fetchConfigPath() (string, error) {
+	var configPath string
+	flag.StringVar(&configPath, "config", "", "path to config file")
+	flag.Parse()
+	if configPath == "" {
+		configPath = os.Getenv("CONFIG_PATH")
+	}
+	if configPath != "" {
+		return filepath.Abs(configPath)
+	}
+
+	env := os.Getenv("QR_ENV")
+	if env == "" {
+		env = "local"
+	}
+	name := filepath.Join("config", env+".yaml")
+
+	var candidates []string
+	if currentDir, err := os.Getwd(); err == nil {
+		candidates = append(candidates, filepath.Join(currentDir, name))
+	}
+	if executable, err := os.Executable(); err == nil {
+		candidates = append(candidates, filepath.Join(filepath.Dir(executable), name))
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
 		}
+	}
+	return "", fmt.Errorf("config file %s not found (looked in %s); set --config or CONFIG_PATH",
+		name, strings.Join(candidates, ", "))
+}
+
+// относительные пути файлов становятся абсолютными, чтобы не зависеть от рабочей папки
+func (cfg *Config) resolvePaths(configPath string) error {
+	if cfg.BaseDir == "" {
+		cfg.BaseDir = filepath.Dir(filepath.Dir(configPath))
+	}
+	baseDir, err := filepath.Abs(cfg.BaseDir)
+	if err != nil {
+		return fmt.Errorf("base_dir: %w", err)
+	}
+	cfg.BaseDir = baseDir
 
-		if _, err := os.Stat(configPath); err != nil {
-			log.Fatalf("error opening config file: %s", err)
+	resolve := func(path, dir string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
 		}
+		return filepath.Join(dir, path)
+	}
+	cfg.StoragePath = resolve(cfg.StoragePath, filepath.Join(baseDir, "db"))
+	cfg.CertFile = resolve(cfg.CertFile, baseDir)
+	cfg.KeyFile = resolve(cfg.KeyFile, baseDir)
+	cfg.Notifier.FilePath = resolve(cfg.Notifier.FilePath, baseDir)
+	return nil
+}
 
-		var cfg Config
-		err = cleanenv.ReadConfig(configPath, &cfg)
-		if err != nil {
-			log.Fatalf("error reading config file: %s", err)
+// Validate собирает все ошибки конфига сразу, а не по одной за запуск
+func (cfg *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
 		}
+	}
 
-		instance = &cfg
-	})
+	check(slices.Contains([]string{"local", "dev", "prod"}, cfg.Env),
+		"env: must be one of local, dev, prod, got %q", cfg.Env)
+	check(cfg.StoragePath != "", "storage_path: required")
 
-	return instance
+	server := cfg.HTTPServer
+	for name, address := range map[string]string{"httpAddress": server.HttpAddress, "httpsAddress": server.HttpsAddress} {
+		_, _, err := net.SplitHostPort(address)
+		check(err == nil, "http_server.%s: invalid address %q (expected host:port or :port)", name, address)
+	}
+	check(server.Timeout > 0, "http_server.timeout: must be positive")
+	check(server.IdleTimeout >= 0, "http_server.idle_timeout: must not be negative")
+	check(server.ShutdownTimeout > 0, "http_server.shutdown_timeout: must be positive")
+	check(server.CertReloadInterval >= 0, "http_server.cert_reload_interval: must not be negative")
+	check(server.HSTSMaxAge >= 0, "http_server.hsts_max_age: must not be negative")
+	check(slices.Contains([]string{"", "1.2", "1.3"}, server.TLSMinVersion),
+		"http_server.tls_min_version: must be 1.2 or 1.3, got %q", server.TLSMinVersion)
+	check(server.CertFile != "" && server.KeyFile != "", "http_server.cert_file and key_file: required")
+	check(!(server.SelfSignedDevCert && cfg.Env == "prod"), "http_server.self_signed_dev_cert: not allowed in prod")
+
+	limits := cfg.RateLimit
+	check(limits.AuthPerIPPerMinute >= 0 && limits.AuthPerLoginPerMinute >= 0,
+		"rate_limit: per minute limits must not be negative")
+	check(limits.AuthIPBurst >= 0 && limits.AuthLoginBurst >= 0, "rate_limit: bursts must not be negative")
+	check(limits.LockoutThreshold >= 0, "rate_limit.lockout_threshold: must not be negative")
+	check(limits.LockoutBase >= 0 && limits.LockoutMax >= limits.LockoutBase,
+		"rate_limit: lockout_max must not be less than lockout_base")
+
+	check(cfg.Password.MinLength >= 1, "password.min_length: must be at least 1")
+	check(cfg.Password.ResetTokenTTL > 0, "password.reset_token_ttl: must be positive")
+
+	switch cfg.Notifier.Kind {
+	case "console":
+		// письма со ссылками сброса пароля не должны попадать в лог
+		check(cfg.Env != "prod", "notifier.kind: console is not allowed in prod")
+	case "file":
+		check(cfg.Notifier.FilePath != "", "notifier.file_path: required for kind file")
+	case "smtp":
+		check(cfg.Notifier.SMTPAddress != "", "notifier.smtp_address: required for kind smtp")
+	default:
+		check(false, "notifier.kind: must be one of console, file, smtp, got %q", cfg.Notifier.Kind)
+	}
+
+	if cfg.OIDC.Enabled {
+		check(cfg.OIDC.Issuer != "", "oidc.issuer: required when oidc is enabled")
+		check(cfg.OIDC.ClientID != "", "oidc.client_id: required when oidc is enabled")
+		check(cfg.OIDC.RedirectURL != "", "oidc.redirect_url: required when oidc is enabled")
+	}
+
+	check(len(cfg.Auth.Backends) > 0, "auth.backends: at least one backend required")
+	for _, backend := range cfg.Auth.Backends {
+		check(backend == "sqlite" || backend == "ldap", "auth.backends: unknown backend %q (sqlite or ldap)", backend)
+		if backend == "ldap" {
+			check(cfg.LDAP.URL != "", "ldap.url: required when ldap backend is enabled")
+			check(cfg.LDAP.BaseDN != "", "ldap.base_dn: required when ldap backend is enabled")
+			check(strings.Contains(cfg.LDAP.UserFilter, "%s"), "ldap.user_filter: must contain %%s for the login")
+		}
+	}
+
+	for _, origin := range slices.Concat(cfg.CSRF.TrustedOrigins, cfg.CORS.AllowedOrigins) {
+		u, err := url.Parse(origin)
+		check(err == nil && u.Scheme != "" && u.Host != "", "invalid origin %q (expected scheme://host)", origin)
+	}
+	check(cfg.CORS.MaxAge >= 0, "cors.max_age: must not be negative")
+	check(cfg.Health.DBTimeout > 0, "health.db_timeout: must be positive")
+	check(cfg.Health.CertMinValidDays >= 0, "health.cert_min_valid_days: must not be negative")
+	check(!cfg.Metrics.Enabled || strings.HasPrefix(cfg.Metrics.Path, "/"), "metrics.path: must start with /")
+	// в проде метрики без токена доступны любому, кто достучится до сервера
+	check(!(cfg.Env == "prod" && cfg.Metrics.Enabled && cfg.Metrics.BearerToken == ""),
+		"metrics.bearer_token: required in prod when metrics are enabled")
+	check(cfg.I18n.DefaultLanguage == "en" || cfg.I18n.DefaultLanguage == "ru", "i18n.default_language: unknown language %q (en or ru)", cfg.I18n.DefaultLanguage)
+	check(cfg.Trash.RetentionDays >= 0, "trash.retention_days: must not be negative")
+	check(cfg.Trash.PurgeInterval > 0, "trash.purge_interval: must be positive")
+	if cfg.AutoArchive.Enabled {
+		check(cfg.AutoArchive.AfterDays >= 1, "auto_archive.after_days: must be at least 1")
+		check(cfg.AutoArchive.Interval > 0, "auto_archive.interval: must be positive")
+	}
+
+	return errors.Join(errs...)
+}
+
+// загружен ли конфиг (для проверки готовности)
+func Loaded() bool {
+	return instance != nil
 }
 
 // return global config instance
 func Get() *Config {
 	if instance == nil {
-		log.Fatal("config not initialized. call MustLoad()")
+		slog.Error("config not initialized. call MustLoad()")
+		os.Exit(1)
 	}
 	return instance
 }
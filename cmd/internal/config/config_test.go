@@ -0,0 +1,184 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestConfig пишет минимальный конфиг в <tmp>/config/test.yaml
+func writeTestConfig(t *testing.T, content string) string {
+	dir := filepath.Join(t.TempDir(), "config")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "test.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoadShippedConfigs проверяет что файлы config/*.yaml из репозитория проходят проверку
+func TestLoadShippedConfigs(t *testing.T) {
+	for _, env := range []string{"local", "dev", "prod"} {
+		t.Run(env, func(t *testing.T) {
+			cfg, err := Load(filepath.Join("..", "..", "config", env+".yaml"))
+			if err != nil {
+				t.Fatalf("Config %s is invalid: %v", env, err)
+			}
+			if cfg.Env != env {
+				t.Errorf("Expected env %q, got %q", env, cfg.Env)
+			}
+		})
+	}
+}
+
+// TestLoadResolvesPaths проверяет что относительные пути не зависят от рабочей папки
+func TestLoadResolvesPaths(t *testing.T) {
+	path := writeTestConfig(t, "storage_path: \"db.sqlite\"\n")
+	baseDir := filepath.Dir(filepath.Dir(path))
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(baseDir, "db", "db.sqlite"); cfg.StoragePath != want {
+		t.Errorf("Expected storage path %q, got %q", want, cfg.StoragePath)
+	}
+	if want := filepath.Join(baseDir, "cert", "server.crt"); cfg.CertFile != want {
+		t.Errorf("Expected cert file %q, got %q", want, cfg.CertFile)
+	}
+}
+
+// TestLoadEnvOverrides проверяет переопределение полей переменными окружения QR_*
+func TestLoadEnvOverrides(t *testing.T) {
+	path := writeTestConfig(t, `
+storage_path: "db.sqlite"
+http_server:
+  httpAddress: ":80"
+auth:
+  backends: ["sqlite"]
+`)
+	t.Setenv("QR_ENV", "dev")
+	t.Setenv("QR_STORAGE_PATH", "/data/attendance.sqlite")
+	t.Setenv("QR_HTTP_SERVER_HTTP_ADDRESS", ":8080")
+	t.Setenv("QR_HTTP_SERVER_SHUTDOWN_TIMEOUT", "30s")
+	t.Setenv("QR_AUTH_BACKENDS", "ldap,sqlite")
+	t.Setenv("QR_LDAP_URL", "ldaps://ldap.example.org:636")
+	t.Setenv("QR_LDAP_BASE_DN", "ou=people,dc=example,dc=org")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Env != "dev" {
+		t.Errorf("Expected env dev, got %q", cfg.Env)
+	}
+	if cfg.StoragePath != "/data/attendance.sqlite" {
+		t.Errorf("Expected absolute storage path to be kept, got %q", cfg.StoragePath)
+	}
+	if cfg.HttpAddress != ":8080" {
+		t.Errorf("Expected http address :8080, got %q", cfg.HttpAddress)
+	}
+	if cfg.ShutdownTimeout.String() != "30s" {
+		t.Errorf("Expected shutdown timeout 30s, got %v", cfg.ShutdownTimeout)
+	}
+	if strings.Join(cfg.Auth.Backends, ",") != "ldap,sqlite" {
+		t.Errorf("Expected backends ldap,sqlite, got %v", cfg.Auth.Backends)
+	}
+}
+
+// TestLoadZeroValues проверяет, что явные 0 и false из файла не заменяются значениями по умолчанию
+func TestLoadZeroValues(t *testing.T) {
+	path := writeTestConfig(t, `
+storage_path: "db.sqlite"
+rate_limit:
+  auth_per_ip_per_minute: 0
+  auth_per_login_per_minute: 0
+cors:
+  allow_credentials: false
+metrics:
+  enabled: false
+trash:
+  retention_days: 0
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.AuthPerIPPerMinute != 0 || cfg.AuthPerLoginPerMinute != 0 || cfg.AllowCredentials || cfg.Metrics.Enabled || cfg.RetentionDays != 0 {
+		t.Errorf("Explicit zero values replaced by defaults: %+v %+v %+v %+v", cfg.RateLimit, cfg.CORS, cfg.Metrics, cfg.Trash)
+	}
+	// не заданные в файле поля получают значения по умолчанию
+	if cfg.AuthIPBurst != 30 || cfg.Metrics.Path != "/metrics" || cfg.PurgeInterval != time.Hour || len(cfg.Backends) != 1 || cfg.Backends[0] != "sqlite" {
+		t.Errorf("Defaults not applied: %+v %+v %+v %+v", cfg.RateLimit, cfg.Metrics, cfg.Trash, cfg.Auth)
+	}
+
+	t.Setenv("QR_METRICS_ENABLED", "true")
+	if cfg, err = Load(path); err != nil || !cfg.Metrics.Enabled {
+		t.Errorf("Environment should override file value, got %+v, %v", cfg.Metrics, err)
+	}
+}
+
+// TestProdMetricsRequireToken проверяет что в проде метрики не открываются без токена
+func TestProdMetricsRequireToken(t *testing.T) {
+	path := filepath.Join("..", "..", "config", "prod.yaml")
+	t.Setenv("QR_METRICS_ENABLED", "true")
+	_, err := Load(path)
+	if err == nil || !strings.Contains(err.Error(), "metrics.bearer_token") {
+		t.Fatalf("Expected metrics.bearer_token error, got %v", err)
+	}
+
+	t.Setenv("QR_METRICS_BEARER_TOKEN", "secret")
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Metrics.Enabled || cfg.Metrics.BearerToken != "secret" {
+		t.Errorf("Expected metrics enabled with token, got %+v", cfg.Metrics)
+	}
+}
+
+// TestValidateReportsAllErrors проверяет что все ошибки выводятся вместе
+func TestValidateReportsAllErrors(t *testing.T) {
+	path := writeTestConfig(t, `
+env: "production"
+storage_path: "db.sqlite"
+http_server:
+  httpAddress: "80"
+  tls_min_version: "1.0"
+notifier:
+  kind: "smtp"
+auth:
+  backends: ["sqlite", "ldap"]
+ldap:
+  url: ""
+cors:
+  allowed_origins: ["example.org"]
+trash:
+  retention_days: -1
+  purge_interval: -1m
+auto_archive:
+  enabled: true
+  after_days: -1
+`)
+	_, err := Load(path)
+	if err == nil {
+		t.Fatal("Expected validation error")
+	}
+	for _, want := range []string{"env:", "http_server.httpAddress", "tls_min_version", "notifier.smtp_address", "ldap.url", "invalid origin", "trash.retention_days", "trash.purge_interval", "auto_archive.after_days"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got:\n%v", want, err)
+		}
+	}
+}
+
+// TestLoadMissingFile проверяет понятную ошибку для отсутствующего файла
+func TestLoadMissingFile(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Fatal("Expected error for missing config file")
+	}
+}
@@ -13,3 +13,14 @@ func EncryptCookie(data map[string]interface{}) (string, error) {
 func DecryptCookie(cookie string) (map[string]interface{}, error) {
 	return cipher.DecryptAES(cookie, cookieSecretKey)
 }
+
+// ключ для промежуточных токенов входа (второй фактор), отдельно от сессии
+var challengeSecretKey = []byte("u7Wq2ZrT9kLmX4cVbN8pE3sJ6hGf1YdA")
+
+func EncryptChallenge(data map[string]interface{}) (string, error) {
+	return cipher.EncryptAES(data, challengeSecretKey)
+}
+
+func DecryptChallenge(challenge string) (map[string]interface{}, error) {
+	return cipher.DecryptAES(challenge, challengeSecretKey)
+}
@@ -2,11 +2,123 @@ package cors
 
 import (
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func SetCORSHeaders(w *http.ResponseWriter, r *http.Request) {
-	(*w).Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
-	(*w).Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-	(*w).Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-	(*w).Header().Set("Access-Control-Allow-Credentials", "true")
+// политика CORS; origin задаётся точно ("https://app.example.org")
+// или с поддоменами ("https://*.example.org")
+type Policy struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// значения по умолчанию для окружения, если в конфиге ничего не задано
+func DefaultPolicy(env string) Policy {
+	policy := Policy{
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+	// локально фронтенд обычно на dev сервере
+	if env == "local" || env == "dev" {
+		policy.AllowedOrigins = []string{
+			"http://localhost:3000", "http://127.0.0.1:3000",
+			"http://localhost:5173", "http://127.0.0.1:5173",
+		}
+	}
+	return policy
+}
+
+// разрешён ли origin
+func (p Policy) AllowsOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+	origin = strings.ToLower(u.Scheme + "://" + u.Host)
+	for _, allowed := range p.AllowedOrigins {
+		allowed = strings.ToLower(strings.TrimSuffix(allowed, "/"))
+		if allowed == origin {
+			return true
+		}
+		// https://*.example.org - любой поддомен, но не сам example.org
+		scheme, host, ok := strings.Cut(allowed, "://*.")
+		if ok && u.Scheme == scheme && strings.HasSuffix(strings.ToLower(u.Host), "."+host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Policy) allowsMethod(method string) bool {
+	return slices.ContainsFunc(p.AllowedMethods, func(m string) bool { return strings.EqualFold(m, method) })
+}
+
+func (p Policy) allowsHeaders(requested string) bool {
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+		if !slices.ContainsFunc(p.AllowedHeaders, func(h string) bool { return strings.EqualFold(h, header) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware отвечает на preflight и добавляет CORS заголовки разрешённым origin.
+// запросы без Origin (свой сайт, скрипты) проходят без изменений
+func Middleware(policy Policy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+
+		preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+		allowed := policy.AllowsOrigin(origin)
+
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if !allowed ||
+				!policy.allowsMethod(r.Header.Get("Access-Control-Request-Method")) ||
+				!policy.allowsHeaders(r.Header.Get("Access-Control-Request-Headers")) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			setOriginHeaders(w, policy, origin)
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.AllowedMethods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.AllowedHeaders, ", "))
+			if policy.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		// чужой origin: запрос выполняется, но браузер не отдаст ответ странице
+		if allowed {
+			setOriginHeaders(w, policy, origin)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func setOriginHeaders(w http.ResponseWriter, policy Policy, origin string) {
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if policy.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
 }
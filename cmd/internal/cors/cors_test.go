@@ -0,0 +1,111 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testPolicy() Policy {
+	policy := DefaultPolicy("prod")
+	policy.AllowedOrigins = []string{"https://app.example.org", "https://*.uni.example"}
+	return policy
+}
+
+// TestAllowsOrigin проверяет точные origin и поддомены
+func TestAllowsOrigin(t *testing.T) {
+	testCases := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://app.example.org", true},
+		{"https://APP.example.org", true},
+		{"http://app.example.org", false},
+		{"https://app.example.org.evil.com", false},
+		{"https://lk.uni.example", true},
+		{"https://a.b.uni.example", true},
+		{"https://uni.example", false},
+		{"https://eviluni.example", false},
+		{"http://lk.uni.example", false},
+		{"null", false},
+	}
+	policy := testPolicy()
+	for _, tc := range testCases {
+		if got := policy.AllowsOrigin(tc.origin); got != tc.want {
+			t.Errorf("AllowsOrigin(%q) = %v, want %v", tc.origin, got, tc.want)
+		}
+	}
+}
+
+// TestMiddlewarePreflight проверяет ответы на preflight
+func TestMiddlewarePreflight(t *testing.T) {
+	reached := false
+	handler := Middleware(testPolicy(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	testCases := []struct {
+		name    string
+		origin  string
+		method  string
+		headers string
+		want    int
+	}{
+		{"allowed", "https://app.example.org", "POST", "Content-Type", http.StatusNoContent},
+		{"foreign origin", "https://evil.example", "POST", "", http.StatusForbidden},
+		{"method not allowed", "https://app.example.org", "TRACE", "", http.StatusForbidden},
+		{"header not allowed", "https://app.example.org", "POST", "Content-Type, X-Debug", http.StatusForbidden},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("OPTIONS", "/auth", nil)
+			req.Header.Set("Origin", tc.origin)
+			req.Header.Set("Access-Control-Request-Method", tc.method)
+			if tc.headers != "" {
+				req.Header.Set("Access-Control-Request-Headers", tc.headers)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.want {
+				t.Errorf("Expected status %d, got %d", tc.want, w.Code)
+			}
+			allowOrigin := w.Header().Get("Access-Control-Allow-Origin")
+			if tc.want == http.StatusNoContent && (allowOrigin != tc.origin || w.Header().Get("Access-Control-Max-Age") != "600") {
+				t.Errorf("Missing preflight headers: %v", w.Header())
+			}
+			if tc.want == http.StatusForbidden && allowOrigin != "" {
+				t.Errorf("Rejected preflight should not have Allow-Origin, got %q", allowOrigin)
+			}
+		})
+	}
+	if reached {
+		t.Error("Preflight should not reach the handler")
+	}
+}
+
+// TestMiddlewareSimpleRequest проверяет заголовки обычных запросов
+func TestMiddlewareSimpleRequest(t *testing.T) {
+	handler := Middleware(testPolicy(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest("GET", "/teacher/getInfo", nil)
+	req.Header.Set("Origin", "https://lk.uni.example")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://lk.uni.example" || w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("Expected CORS headers for allowed origin, got %v", w.Header())
+	}
+
+	req = httptest.NewRequest("GET", "/teacher/getInfo", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Foreign origin should not get Allow-Origin, got %v", w.Header())
+	}
+	if w.Header().Get("Vary") != "Origin" {
+		t.Errorf("Expected Vary: Origin, got %q", w.Header().Get("Vary"))
+	}
+}
@@ -2,13 +2,10 @@ package database
 
 import (
 	"database/sql"
-	"log"
 	"os"
 	"path/filepath"
 	"qr_code/internal/config"
 	"sync"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
 var (
@@ -19,13 +16,13 @@ var (
 // инициализация бд
 func MustInit() {
 	once.Do(func() {
-		cfg := config.Get()
-		currentDir, err := os.Getwd()
-		if err != nil {
-			log.Fatalf("path error: %s", err)
+		// путь уже абсолютный, см. config.Load
+		dbPath := config.Get().StoragePath
+		if err := os.MkdirAll(filepath.Dir(dbPath), 0o755); err != nil {
+			panic("failed to create database directory: " + err.Error())
 		}
-		dbPath := filepath.Clean(filepath.Join(currentDir, "db", cfg.StoragePath))
-		db, err = sql.Open("sqlite3", dbPath)
+		var err error
+		db, err = sql.Open(instrumentedDriver, dbPath)
 		if err != nil {
 			panic("failed to open database: " + err.Error())
 		}
@@ -33,6 +30,10 @@ func MustInit() {
 		if err := db.Ping(); err != nil {
 			panic("failed to connect to database: " + err.Error())
 		}
+
+		if err := migrate(db); err != nil {
+			panic("failed to migrate database: " + err.Error())
+		}
 	})
 }
 
@@ -43,3 +44,11 @@ func Get() *sql.DB {
 	}
 	return db
 }
+
+// закрытие бд при остановке приложения
+func Close() error {
+	if db == nil {
+		return nil
+	}
+	return db.Close()
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"qr_code/internal/metrics"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqlite3 с замером длительности запросов
+const instrumentedDriver = "sqlite3_instrumented"
+
+var queryDuration = metrics.NewHistogramVec("qr_db_query_duration_seconds",
+	"Duration of SQLite queries and statements.", metrics.DefaultBuckets, "operation")
+
+func init() {
+	sql.Register(instrumentedDriver, timingDriver{&sqlite3.SQLiteDriver{}})
+
+	// состояние пула database.Get()
+	poolStat := func(read func(sql.DBStats) int64) func() float64 {
+		return func() float64 {
+			if db == nil {
+				return 0
+			}
+			return float64(read(db.Stats()))
+		}
+	}
+	metrics.NewGaugeFunc("qr_db_open_connections", "Open connections in the database pool.",
+		poolStat(func(s sql.DBStats) int64 { return int64(s.OpenConnections) }))
+	metrics.NewGaugeFunc("qr_db_in_use_connections", "Database connections currently in use.",
+		poolStat(func(s sql.DBStats) int64 { return int64(s.InUse) }))
+	metrics.NewGaugeFunc("qr_db_wait_count", "Total number of waits for a database connection.",
+		poolStat(func(s sql.DBStats) int64 { return s.WaitCount }))
+}
+
+type timingDriver struct {
+	driver.Driver
+}
+
+func (d timingDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &timingConn{conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+// database/sql идёт через QueryContext/ExecContext, подготовка не нужна
+type timingConn struct {
+	*sqlite3.SQLiteConn
+}
+
+func (c *timingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := c.SQLiteConn.QueryContext(ctx, query, args)
+	queryDuration.Observe(time.Since(start).Seconds(), "query")
+	return rows, err
+}
+
+func (c *timingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	result, err := c.SQLiteConn.ExecContext(ctx, query, args)
+	queryDuration.Observe(time.Since(start).Seconds(), "exec")
+	return result, err
+}
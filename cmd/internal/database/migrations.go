@@ -0,0 +1,196 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// миграции схемы, применяются строго по порядку.
+// номер последней применённой миграции хранится в PRAGMA user_version,
+// поэтому уже существующие миграции менять нельзя - только дописывать новые
+var migrations = []string{
+	// 1: базовая схема (совпадает с db.sqlite, для пустой базы)
+	`
+	CREATE TABLE IF NOT EXISTS "groups" (
+		"id"	INTEGER,
+		"NumGroup"	TEXT,
+		PRIMARY KEY("id")
+	);
+	CREATE TABLE IF NOT EXISTS "user" (
+		"id"	INTEGER,
+		"Login"	TEXT UNIQUE,
+		"PassHash"	TEXT,
+		"FullName"	TEXT,
+		"Role"	TEXT,
+		"GroupId"	INTEGER,
+		PRIMARY KEY("id"),
+		FOREIGN KEY("GroupId") REFERENCES "groups"("id")
+	);
+	CREATE TABLE IF NOT EXISTS "lessons" (
+		"id"	INTEGER,
+		"NameLesson"	TEXT,
+		"Date"	TEXT,
+		"TypeLes"	TEXT,
+		"QrToken"	TEXT UNIQUE,
+		"IsActive"	INTEGER,
+		"TeacherId"	INTEGER,
+		PRIMARY KEY("id")
+	);
+	CREATE TABLE IF NOT EXISTS "attendances" (
+		id INTEGER PRIMARY KEY,
+		LessonId INTEGER,
+		StudentId INTEGER,
+		Status INTEGER,
+		ConfirmedDate DATETIME,
+		GroupId INTEGER,
+		FOREIGN KEY (StudentId) REFERENCES user(id)
+	);`,
+	// 2: окно приёма отметок (сессия занятия).
+	// уже активные занятия считаем открытыми, чтобы не сломать выданные qr
+	`
+	ALTER TABLE lessons ADD COLUMN SessionStart DATETIME;
+	ALTER TABLE lessons ADD COLUMN SessionEnd DATETIME;
+	UPDATE lessons SET SessionStart = datetime('now') WHERE IsActive = TRUE;`,
+	// 3: группы, для которых проводится занятие (список записанных студентов)
+	`
+	CREATE TABLE IF NOT EXISTS lesson_groups (
+		LessonId INTEGER NOT NULL,
+		GroupId INTEGER NOT NULL,
+		PRIMARY KEY (LessonId, GroupId),
+		FOREIGN KEY (LessonId) REFERENCES lessons(id),
+		FOREIGN KEY (GroupId) REFERENCES groups(id)
+	);`,
+	// 4: неудачные попытки входа для прогрессивной блокировки (unix-время)
+	`
+	CREATE TABLE IF NOT EXISTS login_failures (
+		Login TEXT PRIMARY KEY,
+		Failures INTEGER NOT NULL DEFAULT 0,
+		LastFailure INTEGER,
+		LockedUntil INTEGER
+	);`,
+	// 5: почта для восстановления пароля и одноразовые токены сброса (хранится sha256)
+	`
+	ALTER TABLE user ADD COLUMN Email TEXT;
+	CREATE TABLE IF NOT EXISTS password_resets (
+		TokenHash TEXT PRIMARY KEY,
+		UserId INTEGER NOT NULL,
+		CreatedAt INTEGER NOT NULL,
+		ExpiresAt INTEGER NOT NULL,
+		UsedAt INTEGER,
+		FOREIGN KEY (UserId) REFERENCES user(id)
+	);`,
+	// 6: TOTP; LastStep - последний принятый шаг (защита от повтора кода)
+	`
+	CREATE TABLE IF NOT EXISTS user_totp (
+		UserId INTEGER PRIMARY KEY,
+		Secret TEXT NOT NULL,
+		Enabled INTEGER NOT NULL DEFAULT 0,
+		LastStep INTEGER NOT NULL DEFAULT 0,
+		CreatedAt INTEGER NOT NULL,
+		FOREIGN KEY (UserId) REFERENCES user(id)
+	);
+	CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+		UserId INTEGER NOT NULL,
+		CodeHash TEXT NOT NULL,
+		UsedAt INTEGER,
+		PRIMARY KEY (UserId, CodeHash),
+		FOREIGN KEY (UserId) REFERENCES user(id)
+	);`,
+	// 7: личные API токены; хранится только хеш
+	`
+	CREATE TABLE IF NOT EXISTS api_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		UserId INTEGER NOT NULL,
+		Name TEXT NOT NULL,
+		TokenHash TEXT NOT NULL UNIQUE,
+		Scope TEXT NOT NULL,
+		CreatedAt INTEGER NOT NULL,
+		ExpiresAt INTEGER NOT NULL,
+		LastUsedAt INTEGER,
+		RevokedAt INTEGER,
+		FOREIGN KEY (UserId) REFERENCES user(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens(UserId);`,
+	// 8: язык интерфейса; NULL - по Accept-Language
+	`
+	ALTER TABLE user ADD COLUMN Language TEXT;`,
+	// 9: страницы списков занятий учителя по дате
+	`
+	CREATE INDEX IF NOT EXISTS idx_lessons_teacher ON lessons(TeacherId, IsActive, Date, id);`,
+	// 10: история изменений занятий
+	`
+	CREATE TABLE IF NOT EXISTS lesson_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		LessonId INTEGER NOT NULL,
+		ChangedBy INTEGER NOT NULL,
+		ChangedAt INTEGER NOT NULL,
+		OldName TEXT NOT NULL,
+		OldDate TEXT NOT NULL,
+		OldType TEXT NOT NULL,
+		NewName TEXT NOT NULL,
+		NewDate TEXT NOT NULL,
+		NewType TEXT NOT NULL,
+		TokenRevoked INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS idx_lesson_history_lesson ON lesson_history(LessonId);`,
+	// 11: корзина; NULL - не удалено
+	`
+	ALTER TABLE lessons ADD COLUMN DeletedAt DATETIME;
+	CREATE INDEX IF NOT EXISTS idx_lessons_deleted ON lessons(DeletedAt);`,
+	// 12: внешний источник учётной записи (oidc, ldap) и id пользователя у него;
+	// NULL - локальная учётная запись
+	`
+	ALTER TABLE user ADD COLUMN AuthProvider TEXT;
+	ALTER TABLE user ADD COLUMN ExternalSubject TEXT;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_user_external ON user(AuthProvider, ExternalSubject);`,
+	// 13: поколение QR кода занятия; коды поколения меньше QrMinGeneration не принимаются
+	`
+	ALTER TABLE lessons ADD COLUMN QrGeneration INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE lessons ADD COLUMN QrMinGeneration INTEGER NOT NULL DEFAULT 0;`,
+	// 14: одна отметка студента на занятие; из прежних дублей остаётся первая
+	`
+	DELETE FROM attendances WHERE id NOT IN (SELECT MIN(id) FROM attendances GROUP BY LessonId, StudentId);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_attendances_lesson_student ON attendances(LessonId, StudentId);`,
+}
+
+// версия схемы, которую ожидает текущий код
+var SchemaVersion = len(migrations)
+
+// применение недостающих миграций
+func migrate(db *sql.DB) error {
+	current, err := CurrentSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	for i := current; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		// PRAGMA не принимает параметры
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		slog.Info("applied migration", "version", i+1)
+	}
+	return nil
+}
+
+// текущая версия схемы в базе
+func CurrentSchemaVersion(db *sql.DB) (int, error) {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"qr_code/internal/database"
+	"qr_code/internal/logger"
+	"qr_code/internal/utils"
+)
+
+// запрос разблокировки
+type AdminUnlockRequest struct {
+	Login string `json:"login"`
+}
+
+type AdminResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// снятие блокировки входа с логина
+func handler_admin_unlock(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r, "POST")
+		return
+	}
+	// только cookie сессии, API токеном нельзя
+	userData, err := authenticate(r, scopeSession)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	// role check
+	if userData["role"] != "Admin" {
+		writeError(w, r, http.StatusForbidden, codeForbidden, "Access denied")
+		return
+	}
+
+	var unlockRequest AdminUnlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&unlockRequest); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON format")
+		return
+	}
+
+	if !utils.IsSafeString(unlockRequest.Login) {
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Login contains invalid characters. Only letters, numbers, @, ., -, _ are allowed (3-50 characters)")
+		return
+	}
+	login := utils.CleanString(unlockRequest.Login)
+
+	db := database.Get()
+	if err := resetLoginFailures(db, login); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	authLoginLimiter.Reset(login)
+
+	logger.FromContext(r.Context()).Info("login unlocked", "admin", userData["login"], "login", login)
+	response := AdminResponse{
+		Success: true,
+		Message: tr(r, "Login unlocked"),
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"qr_code/internal/config"
+	"qr_code/internal/database"
+	"qr_code/internal/i18n"
+	"qr_code/internal/logger"
+	"qr_code/internal/notify"
+	"strings"
+	"time"
+)
+
+// больше id за один запрос не принимается
+const maxBulkArchive = 500
+
+// перенос в архив: открытый приём отметок закрывается
+const archiveSetExpr = `IsActive = FALSE,
+	SessionEnd = CASE WHEN ` + sessionOpenExpr + ` THEN datetime('now') ELSE SessionEnd END`
+
+// новые даты проверяются при создании и изменении занятия,
+// у старых записей остаётся произвольный текст: сравниваются только даты вида YYYY-MM-DD
+const isoDateExpr = `Date GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]*'`
+
+// автоархивация, см. configureAutoArchive
+var (
+	autoArchiveEnabled   = false
+	autoArchiveAfterDays = 30
+	autoArchiveInterval  = time.Hour
+	autoArchiveNotify    = false
+)
+
+func configureAutoArchive(cfg config.AutoArchive) {
+	autoArchiveEnabled = cfg.Enabled
+	autoArchiveAfterDays = cfg.AfterDays
+	autoArchiveInterval = cfg.Interval
+	autoArchiveNotify = cfg.NotifyTeachers
+}
+
+// письмо преподавателю: число занятий, срок и список занятий
+const (
+	autoArchiveEmailSubject = "Lessons moved to archive"
+	autoArchiveEmailBody    = "%d of your lessons dated more than %d days ago were moved to the archive automatically:\n\n%s\n\n" +
+		"You can return them from the archive at any time."
+)
+
+// запрос массового архивирования: либо ids, либо before
+type BulkArchiveRequest struct {
+	IDs []int `json:"ids"`
+	// занятия с датой раньше before (YYYY-MM-DD)
+	Before string `json:"before"`
+}
+
+type ArchivedLesson struct {
+	ID         int    `json:"id"`
+	NameLesson string `json:"name_lesson"`
+	Date       string `json:"date"`
+	TeacherId  int    `json:"-"`
+}
+
+type BulkArchiveResponse struct {
+	Success  bool             `json:"success"`
+	Message  string           `json:"message"`
+	Count    int              `json:"count"`
+	Archived []ArchivedLesson `json:"archived"`
+	// id из запроса, которые не перенесены: чужие, уже в архиве или не найдены
+	Skipped []int `json:"skipped,omitempty"`
+}
+
+// массовый перенос текущих занятий преподавателя в архив
+func handler_archive_bulk(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r, "POST")
+		return
+	}
+	// cookie сессии или Bearer токен
+	userData, err := authenticate(r, scopeManage)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	// role check
+	if userData["role"] != "Teacher" {
+		writeError(w, r, http.StatusForbidden, codeForbidden, "Access denied")
+		return
+	}
+
+	var bulkRequest BulkArchiveRequest
+	if err := json.NewDecoder(r.Body).Decode(&bulkRequest); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON format")
+		return
+	}
+	if (len(bulkRequest.IDs) == 0) == (bulkRequest.Before == "") {
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Pass either ids or before")
+		return
+	}
+
+	where := "TeacherId = ?"
+	args := []any{userData["user_id"]}
+	if bulkRequest.Before != "" {
+		if _, err := time.Parse(time.DateOnly, bulkRequest.Before); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeValidation, "Dates must be in YYYY-MM-DD format")
+			return
+		}
+		where += " AND " + isoDateExpr + " AND Date < ?"
+		args = append(args, bulkRequest.Before)
+	} else {
+		if len(bulkRequest.IDs) > maxBulkArchive {
+			writeAPIError(w, r, newAPIError(http.StatusBadRequest, codeValidation, trf(r, "At most %d lesson IDs per request", maxBulkArchive)))
+			return
+		}
+		placeholders := make([]string, len(bulkRequest.IDs))
+		for i, id := range bulkRequest.IDs {
+			if id <= 0 {
+				writeError(w, r, http.StatusBadRequest, codeValidation, "Lesson IDs must be positive numbers")
+				return
+			}
+			placeholders[i] = "?"
+			args = append(args, id)
+		}
+		where += " AND id IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+
+	archived, err := archiveLessons(database.Get(), where, args...)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	done := make(map[int]bool, len(archived))
+	for _, lesson := range archived {
+		done[lesson.ID] = true
+	}
+	var skipped []int
+	for _, id := range bulkRequest.IDs {
+		if !done[id] {
+			skipped = append(skipped, id)
+			done[id] = true // повторы не дублируются
+		}
+	}
+
+	logger.FromContext(r.Context()).Info("lessons archived in bulk", "login", userData["login"], "lessons", len(archived), "before", bulkRequest.Before)
+	response := BulkArchiveResponse{
+		Success:  true,
+		Message:  trf(r, "Lessons archived: %d", len(archived)),
+		Count:    len(archived),
+		Archived: archived,
+		Skipped:  skipped,
+	}
+	if response.Archived == nil {
+		response.Archived = []ArchivedLesson{}
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// перенос в архив текущих занятий (IsActive AND DeletedAt IS NULL AND where);
+// возвращает перенесённые занятия в порядке id
+func archiveLessons(db *sql.DB, where string, args ...any) ([]ArchivedLesson, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT id, NameLesson, Date, TeacherId FROM lessons
+		WHERE IsActive = TRUE AND DeletedAt IS NULL AND `+where+`
+		ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	var archived []ArchivedLesson
+	for rows.Next() {
+		var lesson ArchivedLesson
+		if err := rows.Scan(&lesson.ID, &lesson.NameLesson, &lesson.Date, &lesson.TeacherId); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		archived = append(archived, lesson)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, lesson := range archived {
+		if _, err := tx.Exec(`UPDATE lessons SET `+archiveSetExpr+` WHERE id = ?`, lesson.ID); err != nil {
+			return nil, fmt.Errorf("archive lesson %d: %w", lesson.ID, err)
+		}
+	}
+	return archived, tx.Commit()
+}
+
+// что автоархивация перенесла у одного преподавателя
+type autoArchiveReport struct {
+	TeacherID int
+	Login     string
+	Email     string
+	Language  string
+	Lessons   []ArchivedLesson
+}
+
+// приём открыт за последние сутки - преподаватель ещё работает с занятием;
+// старые занятия с бессрочно открытым приёмом (до миграции сессий) так не считаются
+const recentlyOpenedExpr = `(` + sessionOpenExpr + ` AND SessionStart > datetime('now', '-1 day'))`
+
+// перенос в архив занятий с датой старше auto_archive.after_days дней;
+// занятия с недавно открытым приёмом отметок не трогаются
+func autoArchiveStale(db *sql.DB) ([]autoArchiveReport, error) {
+	archived, err := archiveLessons(db,
+		isoDateExpr+` AND Date < date('now', ?) AND NOT `+recentlyOpenedExpr,
+		fmt.Sprintf("-%d days", autoArchiveAfterDays))
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []autoArchiveReport
+	byTeacher := map[int]int{}
+	for _, lesson := range archived {
+		index, ok := byTeacher[lesson.TeacherId]
+		if !ok {
+			index = len(reports)
+			byTeacher[lesson.TeacherId] = index
+			reports = append(reports, autoArchiveReport{TeacherID: lesson.TeacherId})
+		}
+		reports[index].Lessons = append(reports[index].Lessons, lesson)
+	}
+
+	for i := range reports {
+		var login, email, language sql.NullString
+		err := db.QueryRow(`SELECT Login, Email, Language FROM user WHERE id = ?`, reports[i].TeacherID).Scan(&login, &email, &language)
+		if err != nil && err != sql.ErrNoRows {
+			return reports, err
+		}
+		reports[i].Login, reports[i].Email, reports[i].Language = login.String, email.String, language.String
+	}
+	return reports, nil
+}
+
+// письмо преподавателю о перенесённых занятиях на языке из его настроек
+func notifyAutoArchived(report autoArchiveReport) error {
+	lang := i18n.Default()
+	if i18n.Supported(report.Language) {
+		lang = report.Language
+	}
+	lines := make([]string, len(report.Lessons))
+	for i, lesson := range report.Lessons {
+		lines[i] = fmt.Sprintf("- %s, %s (ID %d)", lesson.NameLesson, lesson.Date, lesson.ID)
+	}
+	body := i18n.Tf(lang, autoArchiveEmailBody, len(report.Lessons), autoArchiveAfterDays, strings.Join(lines, "\n"))
+	return notify.Get().Send(report.Email, i18n.T(lang, autoArchiveEmailSubject), body)
+}
+
+// RunAutoArchive переносит устаревшие занятия в архив сразу и затем раз в auto_archive.interval,
+// пока не отменён ctx; при выключенной автоархивации сразу возвращается
+func RunAutoArchive(ctx context.Context) {
+	if !autoArchiveEnabled {
+		return
+	}
+	ticker := time.NewTicker(autoArchiveInterval)
+	defer ticker.Stop()
+	for {
+		reports, err := autoArchiveStale(database.Get())
+		if err != nil {
+			slog.Error("auto archive failed", "err", err)
+		}
+		for _, report := range reports {
+			ids := make([]int, len(report.Lessons))
+			for i, lesson := range report.Lessons {
+				ids[i] = lesson.ID
+			}
+			slog.Info("lessons auto-archived", "teacher_id", report.TeacherID, "login", report.Login,
+				"lessons", len(ids), "ids", ids, "after_days", autoArchiveAfterDays)
+			if autoArchiveNotify && report.Email != "" {
+				if err := notifyAutoArchived(report); err != nil {
+					slog.Error("sending auto archive email failed", "teacher_id", report.TeacherID, "err", err)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -2,22 +2,25 @@ package handlers
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
-	"qr_code/internal/cookie"
-	"qr_code/internal/cors"
 	"qr_code/internal/database"
+	"qr_code/internal/logger"
 	"strconv"
+	"time"
 )
 
 type ArchiveLesson struct {
-	ID         int    `json:"id"`
-	NameLesson string `json:"name_lesson"`
-	Date       string `json:"date"`
-	TypeLes    string `json:"type_les"`
-	QrToken    string `json:"qr_token"`
-	IsActive   bool   `json:"is_active"`
-	TeacherId  int    `json:"teacher_id"`
+	ID           int        `json:"id"`
+	NameLesson   string     `json:"name_lesson"`
+	Date         string     `json:"date"`
+	TypeLes      string     `json:"type_les"`
+	QrToken      string     `json:"qr_token"`
+	IsActive     bool       `json:"is_active"`
+	TeacherId    int        `json:"teacher_id"`
+	SessionStart *time.Time `json:"session_start"`
+	SessionEnd   *time.Time `json:"session_end"`
+	IsOpen       bool       `json:"is_open"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"`
 }
 
 type ArchiveInfoResponse struct {
@@ -25,354 +28,150 @@ type ArchiveInfoResponse struct {
 	Message  string          `json:"message"`
 	FullName string          `json:"fullname"`
 	Lessons  []ArchiveLesson `json:"lessons"`
+	// всего занятий по фильтрам; next_cursor - для следующей страницы, пустой на последней
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 func handler_archive_getlessons(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	cors.SetCORSHeaders(&w, r)
-	// 200
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
 	if r.Method != "GET" {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Only GET method allowed",
-		}
-		json.NewEncoder(w).Encode(response)
-		return
-	}
-	// cookie check
-	sessionCookie, err := r.Cookie("session")
-	if err != nil {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Not authorized",
-		}
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(response)
+		writeMethodNotAllowed(w, r, "GET")
 		return
 	}
-
-	// valid cookie check
-	userData, err := cookie.DecryptCookie(sessionCookie.Value)
+	// cookie сессии или Bearer токен
+	userData, err := authenticate(r, scopeRead)
 	if err != nil {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Invalid session: " + err.Error(),
-		}
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(response)
+		writeAPIError(w, r, err)
 		return
 	}
 
 	// role check
 	if userData["role"] != "Teacher" {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Access denied",
-		}
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusForbidden, codeForbidden, "Access denied")
 		return
 	}
 
-	db := database.Get()
-
-	// get all teacher lessons
-	rows, err := db.Query(`SELECT * FROM lessons WHERE TeacherId = ? AND IsActive = FALSE`, userData["user_id"])
+	query, err := parseLessonListQuery(r)
 	if err != nil {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Database error: " + err.Error(),
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+		writeAPIError(w, r, err)
 		return
 	}
-	defer rows.Close()
-
-	var lessons []ArchiveLesson
 
-	// read all
-	for rows.Next() {
-		var lesson ArchiveLesson
-		err := rows.Scan(&lesson.ID, &lesson.NameLesson, &lesson.Date, &lesson.TypeLes, &lesson.QrToken, &lesson.IsActive, &lesson.TeacherId)
-		if err != nil {
-			log.Printf("Error scanning lesson: %v\n", err)
-			continue
-		}
-		lessons = append(lessons, lesson)
-	}
-
-	// check error iterations
-	if err = rows.Err(); err != nil {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Error reading lessons: " + err.Error(),
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+	page, total, nextCursor, err := queryLessons(database.Get(), userData["user_id"], lessonsArchived, query)
+	if err != nil {
+		writeInternalError(w, r, err)
 		return
 	}
+	var lessons []ArchiveLesson
+	for _, lesson := range page {
+		lessons = append(lessons, ArchiveLesson(lesson))
+	}
+	fullName, _ := userData["full_name"].(string)
 	response := ArchiveInfoResponse{
-		Success:  true,
-		Message:  "Archive lessons retrieved successfully",
-		FullName: userData["full_name"].(string),
-		Lessons:  lessons,
+		Success:    true,
+		Message:    tr(r, "Archive lessons retrieved successfully"),
+		FullName:   fullName,
+		Lessons:    lessons,
+		Total:      total,
+		NextCursor: nextCursor,
 	}
 	json.NewEncoder(w).Encode(response)
 }
 
 func handler_archive_deleteLesson(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	cors.SetCORSHeaders(&w, r)
-	// 200
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
+	// удаление только POST/DELETE: GET выполняется ссылкой или <img> с любого сайта
+	if r.Method != "POST" && r.Method != "DELETE" {
+		writeMethodNotAllowed(w, r, "POST", "DELETE")
 		return
 	}
-	if r.Method != "POST" && r.Method != "GET" {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Only POST method allowed",
-		}
-		json.NewEncoder(w).Encode(response)
-		return
-	}
-	// cookie check
-	sessionCookie, err := r.Cookie("session")
+	// cookie сессии или Bearer токен
+	userData, err := authenticate(r, scopeManage)
 	if err != nil {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Not authorized",
-		}
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(response)
-		return
-	}
-
-	// valid cookie check
-	userData, err := cookie.DecryptCookie(sessionCookie.Value)
-	if err != nil {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Invalid session: " + err.Error(),
-		}
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(response)
+		writeAPIError(w, r, err)
 		return
 	}
 
 	// role check
 	if userData["role"] != "Teacher" {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Access denied",
-		}
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusForbidden, codeForbidden, "Access denied")
 		return
 	}
 
-	lessonIdParam := r.URL.Query().Get("lessonId")
+	lessonIdParam := lessonIDParam(r)
 	if lessonIdParam == "" {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Lesson ID is required",
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Lesson ID is required")
 		return
 	}
 	// конвертация в int
 	lessonId, err := strconv.Atoi(lessonIdParam)
 	if err != nil {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Invalid Lesson ID format",
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Invalid Lesson ID format")
 		return
 	}
 	if lessonId <= 0 {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Lesson ID must be positive number",
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Lesson ID must be positive number")
 		return
 	}
 
 	db := database.Get()
 
-	// check isactive and owner
-	var canDelete int
-	err = db.QueryRow(`
-		SELECT COUNT(*) FROM lessons WHERE ID = ? AND TeacherId = ? AND IsActive = FALSE`,
-		lessonId, userData["user_id"]).Scan(&canDelete)
-
-	if err != nil {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Database error",
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
-		return
-	}
-
-	if canDelete == 0 {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Lesson not found, you are not the owner, or lesson is still active",
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
-		return
-	}
-
-	// delete
-	tx, err := db.Begin()
-	if err != nil {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Failed to start transaction",
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
-		return
-	}
-
-	// delete attendances
-	_, err = tx.Exec(`DELETE FROM attendances WHERE LessonId = ?`, lessonId)
-	if err != nil {
-		tx.Rollback()
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Failed to delete attendance records",
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
-		return
-	}
-
-	// delete lesson
-	_, err = tx.Exec(`DELETE FROM lessons WHERE ID = ? AND TeacherId = ?`,
+	// в корзину, отметки сохраняются до окончательного удаления (см. trash_handler.go)
+	result, err := db.Exec(`
+		UPDATE lessons SET DeletedAt = datetime('now')
+		WHERE ID = ? AND TeacherId = ? AND IsActive = FALSE AND DeletedAt IS NULL`,
 		lessonId, userData["user_id"])
-
 	if err != nil {
-		tx.Rollback()
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Failed to delete lesson",
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+		writeInternalError(w, r, err)
 		return
 	}
 
-	// commit transaction
-	err = tx.Commit()
-	if err != nil {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Failed to commit transaction",
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		writeError(w, r, http.StatusNotFound, codeLessonNotFound, "Lesson not found, you are not the owner, or lesson is still active")
 		return
 	}
 
+	logger.FromContext(r.Context()).Info("lesson moved to trash", "login", userData["login"], "lesson_id", lessonId)
 	response := ArchiveInfoResponse{
 		Success: true,
-		Message: "Lesson deleted successfully",
+		Message: tr(r, "Lesson moved to trash"),
 	}
 	json.NewEncoder(w).Encode(response)
 }
 
 func handler_archive_add(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	cors.SetCORSHeaders(&w, r)
-	// 200
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-	if r.Method != "POST" && r.Method != "GET" {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Only POST method allowed",
-		}
-		json.NewEncoder(w).Encode(response)
-		return
-	}
-	// cookie check
-	sessionCookie, err := r.Cookie("session")
-	if err != nil {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Not authorized",
-		}
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(response)
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r, "POST")
 		return
 	}
-
-	// valid cookie check
-	userData, err := cookie.DecryptCookie(sessionCookie.Value)
+	// cookie сессии или Bearer токен
+	userData, err := authenticate(r, scopeManage)
 	if err != nil {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Invalid session: " + err.Error(),
-		}
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(response)
+		writeAPIError(w, r, err)
 		return
 	}
 
 	// role check
 	if userData["role"] != "Teacher" {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Access denied",
-		}
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusForbidden, codeForbidden, "Access denied")
 		return
 	}
 
-	lessonIdParam := r.URL.Query().Get("lessonId")
+	lessonIdParam := lessonIDParam(r)
 	if lessonIdParam == "" {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Lesson ID is required",
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Lesson ID is required")
 		return
 	}
 	// конвертация в int
 	lessonId, err := strconv.Atoi(lessonIdParam)
 	if err != nil {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Invalid Lesson ID format",
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Invalid Lesson ID format")
 		return
 	}
 	if lessonId <= 0 {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Lesson ID must be positive number",
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Lesson ID must be positive number")
 		return
 	}
 
@@ -383,42 +182,75 @@ func handler_archive_add(w http.ResponseWriter, r *http.Request) {
 		lessonId, userData["user_id"]).Scan(&canArchive)
 
 	if err != nil {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Database error",
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+		writeInternalError(w, r, err)
 		return
 	}
 
 	if canArchive == 0 {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Lesson not found, you are not the owner, or already archived",
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusNotFound, codeLessonNotFound, "Lesson not found, you are not the owner, or already archived")
 		return
 	}
 
-	// add to archive
-	_, err = db.Exec(`UPDATE lessons SET IsActive = FALSE WHERE ID = ? AND TeacherId = ?`,
+	// add to archive, открытый приём отметок закрывается
+	_, err = db.Exec(`UPDATE lessons SET `+archiveSetExpr+` WHERE ID = ? AND TeacherId = ?`,
 		lessonId, userData["user_id"])
 
 	if err != nil {
-		response := ArchiveInfoResponse{
-			Success: false,
-			Message: "Failed to archive lesson",
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+		writeInternalError(w, r, err)
+		return
+	}
+
+	response := ArchiveInfoResponse{
+		Success: true,
+		Message: tr(r, "Lesson archived successfully"),
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// возврат занятия из архива в текущие; приём отметок остаётся закрытым
+func handler_archive_restore(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r, "POST")
+		return
+	}
+	// cookie сессии или Bearer токен
+	userData, err := authenticate(r, scopeManage)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	// role check
+	if userData["role"] != "Teacher" {
+		writeError(w, r, http.StatusForbidden, codeForbidden, "Access denied")
+		return
+	}
+
+	lessonId, err := strconv.Atoi(lessonIDParam(r))
+	if err != nil || lessonId <= 0 {
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Invalid Lesson ID format")
+		return
+	}
+
+	result, err := database.Get().Exec(`
+		UPDATE lessons SET IsActive = TRUE
+		WHERE ID = ? AND TeacherId = ? AND IsActive = FALSE AND DeletedAt IS NULL`,
+		lessonId, userData["user_id"])
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		writeError(w, r, http.StatusNotFound, codeLessonNotFound, "Lesson not found, you are not the owner, or lesson is not archived")
 		return
 	}
 
+	logger.FromContext(r.Context()).Info("lesson restored from archive", "login", userData["login"], "lesson_id", lessonId)
 	response := ArchiveInfoResponse{
 		Success: true,
-		Message: "Lesson archived successfully",
+		Message: tr(r, "Lesson restored from archive"),
 	}
 	json.NewEncoder(w).Encode(response)
 }
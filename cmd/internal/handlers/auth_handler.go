@@ -1,15 +1,21 @@
 package handlers
 
 import (
-	"database/sql"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
-	"qr_code/internal/cipher"
+	"os"
+	"qr_code/internal/authn"
+	"qr_code/internal/config"
 	"qr_code/internal/cookie"
-	"qr_code/internal/cors"
 	"qr_code/internal/database"
+	"qr_code/internal/i18n"
+	"qr_code/internal/logger"
+	"qr_code/internal/password"
 	"qr_code/internal/utils"
+	"unicode/utf8"
 )
 
 // запрос
@@ -21,27 +27,71 @@ type AuthRequest struct {
 // ответ
 type AuthResponse struct {
 	Success  bool   `json:"success"`
+	Code     string `json:"code,omitempty"`
 	Message  string `json:"message"`
 	FullName string `json:"fullname"`
 	Role     string `json:"role"`
 	GroupId  int    `json:"groupid"`
+	// второй фактор: challenge передаётся в /auth/totp или /totp/enroll
+	TotpRequired           bool   `json:"totpRequired,omitempty"`
+	TotpEnrollmentRequired bool   `json:"totpEnrollmentRequired,omitempty"`
+	Challenge              string `json:"challenge,omitempty"`
+	// выдаются один раз при обязательной регистрации TOTP во время входа
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+}
+
+// источник проверки паролей; по умолчанию только база
+var authenticator authn.Authenticator = authn.SQLite{}
+
+func configureAuth(cfg config.Auth, ldapCfg config.LDAP) {
+	var chain authn.Chain
+	for _, backend := range cfg.Backends {
+		switch backend {
+		case "sqlite":
+			chain = append(chain, authn.SQLite{})
+		case "ldap":
+			chain = append(chain, authn.NewLDAP(authn.LDAPSettings{
+				URL:                ldapCfg.URL,
+				StartTLS:           ldapCfg.StartTLS,
+				InsecureSkipVerify: ldapCfg.InsecureSkipVerify,
+				Timeout:            ldapCfg.Timeout,
+				BindDN:             ldapCfg.BindDN,
+				BindPassword:       ldapCfg.BindPassword,
+				BaseDN:             ldapCfg.BaseDN,
+				UserFilter:         ldapCfg.UserFilter,
+				FullNameAttribute:  ldapCfg.FullNameAttribute,
+				EmailAttribute:     ldapCfg.EmailAttribute,
+				RoleAttribute:      ldapCfg.RoleAttribute,
+				GroupAttribute:     ldapCfg.GroupAttribute,
+				TeacherValues:      ldapCfg.TeacherValues,
+				AdminValues:        ldapCfg.AdminValues,
+				JITProvisioning:    ldapCfg.JITProvisioning,
+			}))
+		default:
+			slog.Error("unknown auth backend", "backend", backend)
+			os.Exit(1)
+		}
+	}
+	if len(chain) == 0 {
+		slog.Error("no auth backends configured")
+		os.Exit(1)
+	}
+	authenticator = chain
 }
 
 func handler_auth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	cors.SetCORSHeaders(&w, r)
-	// 200
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r, "POST")
 		return
 	}
 
-	if r.Method != "POST" {
-		response := AuthResponse{
-			Success: false,
-			Message: "Only POST method allowed",
-		}
-		json.NewEncoder(w).Encode(response)
+	// лимит по ip
+	if ok, wait := authIPLimiter.Allow(clientIP(r)); !ok {
+		loginAttempts.Inc("rate_limited")
+		setRetryAfter(w, wait)
+		writeError(w, r, http.StatusTooManyRequests, codeRateLimited, "Too many requests, try again later")
 		return
 	}
 
@@ -49,86 +99,183 @@ func handler_auth(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	// проверка на невалидно переданный json (не получается распарсить)
 	if err := decoder.Decode(&authRequest); err != nil {
-		response := AuthResponse{
-			Success: false,
-			Message: "Invalid JSON format",
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON format")
 		return
 	}
 
 	// проверка полей на пустоту
 	if authRequest.Login == "" || authRequest.Password == "" {
-		response := AuthResponse{
-			Success: false,
-			Message: "Login and password are required",
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Login and password are required")
 		return
 	}
 
-	// проверка полей на валидность по символам
-	if !utils.IsSafeString(authRequest.Login) || !utils.IsSafeString(authRequest.Password) {
-		response := AuthResponse{
-			Success: false,
-			Message: "Login or password contains invalid characters. Only letters, numbers, @, ., -, _ are allowed (3-50 characters)",
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+	// проверка полей на валидность по символам.
+	// пароль не фильтруется: он только хешируется и уходит в запрос параметром;
+	// длина в символах, как в политике паролей
+	if !utils.IsSafeString(authRequest.Login) || utf8.RuneCountInString(authRequest.Password) > password.DefaultPolicy.MaxLength {
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Login contains invalid characters. Only letters, numbers, @, ., -, _ are allowed (3-50 characters)")
 		return
 	}
 
 	// логика авторизации
 	db := database.Get()
-	// очистка паролей от спецсимволов
-	cleanLogin, cleanPassword := utils.CleanString(authRequest.Login), utils.CleanString(authRequest.Password)
-
-	var (
-		id       int
-		Login    string
-		PassHash string
-		FullName string
-		Role     string
-		GroupId  sql.NullInt64
-	)
-	// запрос к бд
-	err := db.QueryRow(
-		"SELECT * FROM user WHERE Login = ? AND PassHash = ? LIMIT 1",
-		cleanLogin, cipher.MD5(cleanPassword),
-	).Scan(&id, &Login, &PassHash, &FullName, &Role, &GroupId)
-
-	// проверка на ошибку после запроса
+	// очистка логина от спецсимволов
+	cleanLogin := utils.CleanString(authRequest.Login)
+
+	// лимит по логину
+	if ok, wait := authLoginLimiter.Allow(cleanLogin); !ok {
+		loginAttempts.Inc("rate_limited")
+		setRetryAfter(w, wait)
+		writeError(w, r, http.StatusTooManyRequests, codeRateLimited, "Too many requests, try again later")
+		return
+	}
+
+	// блокировка после серии неудачных попыток
+	lockedFor, err := loginLockedFor(db, cleanLogin)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("lockout check: %w", err))
+		return
+	}
+	if lockedFor > 0 {
+		loginAttempts.Inc("locked")
+		setRetryAfter(w, lockedFor)
+		writeError(w, r, http.StatusTooManyRequests, codeAccountLocked, "Too many failed login attempts, account is temporarily locked")
+		return
+	}
+
+	// проверка пароля по источникам из конфига (база, LDAP)
+	identity, err := authenticator.Authenticate(r.Context(), cleanLogin, authRequest.Password)
+	if errors.Is(err, authn.ErrInvalidCredentials) {
+		loginAttempts.Inc("failure")
+		if err := registerLoginFailure(db, cleanLogin); err != nil {
+			logger.FromContext(r.Context()).Error("lockout update failed", "err", err)
+		}
+		writeError(w, r, http.StatusUnauthorized, codeInvalidCredentials, "Invalid login or password")
+		return
+	}
+	if errors.Is(err, authn.ErrNoAccount) {
+		loginAttempts.Inc("no_account")
+		logger.FromContext(r.Context()).Warn("auth: no local account", "login", cleanLogin)
+		writeError(w, r, http.StatusForbidden, codeNoAccount, "No account is registered for this user")
+		return
+	}
 	if err != nil {
-		// log.Printf("db error: %s", err)
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(AuthResponse{
-			Success: false,
-			Message: "Invalid login or password",
-		})
+		loginAttempts.Inc("error")
+		logger.FromContext(r.Context()).Error("auth backend failed", "err", err)
+		// не засчитывается: пароль никто не отверг, а при недоступном каталоге
+		// блокировались бы все, кто повторяет вход; перебор сдерживает лимит по логину
+		writeError(w, r, http.StatusServiceUnavailable, codeAuthUnavailable, "Authentication service is unavailable")
 		return
 	}
+	id, Login, FullName, Role, GroupId := identity.UserID, identity.Login, identity.FullName, identity.Role, identity.GroupID
 
 	// если сюда прошло - запрос корректно прошел
+
+	if secondFactorPending(w, r, id, Login, FullName, Role, GroupId) {
+		return
+	}
+
+	completeLogin(w, r, id, Login, FullName, Role, GroupId)
+}
+
+// второй фактор: включён у пользователя или обязателен для роли.
+// true - ответ с challenge уже записан, сессия не выдаётся
+func secondFactorPending(w http.ResponseWriter, r *http.Request, id int64, Login, FullName, Role string, GroupId int64) bool {
+	code, challenge, err := secondFactorChallenge(id, Login, FullName, Role, GroupId)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return true
+	}
+	if code == "" {
+		return false
+	}
+
+	message := "Two-factor authentication code required"
+	if code == codeTOTPEnrollmentRequired {
+		message = "Two-factor authentication enrollment required"
+	}
+	logger.FromContext(r.Context()).Info("first factor accepted, second factor pending", "user_id", id, "login", Login, "role", Role)
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(AuthResponse{
+		Success:                false,
+		Code:                   code,
+		Message:                tr(r, message),
+		Role:                   Role,
+		TotpRequired:           code == codeTOTPRequired,
+		TotpEnrollmentRequired: code == codeTOTPEnrollmentRequired,
+		Challenge:              challenge,
+	})
+	return true
+}
+
+// challenge второго фактора: код ответа и challenge, пустой код - второй фактор не нужен
+func secondFactorChallenge(id int64, Login, FullName, Role string, GroupId int64) (string, string, error) {
+	totpEnabled, err := userTOTPEnabled(database.Get(), id)
+	if err != nil {
+		return "", "", fmt.Errorf("totp check: %w", err)
+	}
+	if !totpEnabled && !totpRequiredFor(Role) {
+		return "", "", nil
+	}
+
+	purpose, code := challengeTOTP, codeTOTPRequired
+	if !totpEnabled {
+		purpose, code = challengeEnroll, codeTOTPEnrollmentRequired
+	}
+	challenge, err := newLoginChallenge(purpose, id, Login, FullName, Role, GroupId)
+	if err != nil {
+		return "", "", fmt.Errorf("creating login challenge: %w", err)
+	}
+	return code, challenge, nil
+}
+
+// выдача сессии после всех проверок
+func completeLogin(w http.ResponseWriter, r *http.Request, id int64, Login, FullName, Role string, GroupId int64) {
+	if err := issueSession(w, r, id, Login, FullName, Role, GroupId); err != nil {
+		writeInternalError(w, r, fmt.Errorf("creating session: %w", err))
+		return
+	}
+	json.NewEncoder(w).Encode(authSuccessResponse(r, FullName, Role, GroupId))
+}
+
+// установка cookie сессии
+func issueSession(w http.ResponseWriter, r *http.Request, id int64, Login, FullName, Role string, GroupId int64) error {
+	db := database.Get()
+	if err := resetLoginFailures(db, Login); err != nil {
+		logger.FromContext(r.Context()).Error("lockout reset failed", "err", err)
+	}
+
 	authData := map[string]interface{}{
 		"user_id":   id,
 		"login":     Login,
 		"role":      Role,
 		"full_name": FullName,
-		"group_id":  GroupId.Int64,
+		"group_id":  GroupId,
+	}
+	// язык из настроек: ответ на вход уже на нём
+	language, err := userLanguage(db, id)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("reading language failed", "err", err)
+	}
+	if language != "" {
+		authData["language"] = language
+		i18n.SetUserLanguage(r.Context(), language)
+	}
+
+	if err := setSessionCookie(w, r, authData); err != nil {
+		return err
 	}
+	logger.SetUserID(r.Context(), id)
+	loginAttempts.Inc("success")
+	logger.FromContext(r.Context()).Info("login succeeded", "user_id", id, "login", Login, "role", Role, "group_id", GroupId)
+	return nil
+}
 
+// шифрованная cookie сессии с данными пользователя
+func setSessionCookie(w http.ResponseWriter, r *http.Request, authData map[string]interface{}) error {
 	encryptedCookie, err := cookie.EncryptCookie(authData)
 	if err != nil {
-		log.Printf("Failed to encrypt cookie: %v", err)
-		response := AuthResponse{
-			Success: false,
-			Message: "Failed to create session",
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
-		return
+		return err
 	}
 
 	http.SetCookie(w, &http.Cookie{
@@ -136,32 +283,32 @@ func handler_auth(w http.ResponseWriter, r *http.Request) {
 		Value:    encryptedCookie,
 		Path:     "/",
 		HttpOnly: true,
-		Secure:   false, // ?
+		// Secure только по TLS: по HTTP такую cookie браузер не сохранит
+		Secure: secureRequest(r),
+		// Lax: cookie не уходит с запросами со сторонних сайтов, кроме переходов по ссылке
+		SameSite: http.SameSiteLaxMode,
 		MaxAge:   86400,
 	})
-	log.Printf("Auth cookie set for user: %s [%s]", Login, encryptedCookie)
+	return nil
+}
 
-	if Role == "Student" {
-		log.Printf("correct auth: %d, %s, %s, %s, %s, %d", id, Login, PassHash, FullName, Role, GroupId.Int64)
-		json.NewEncoder(w).Encode(AuthResponse{
-			Success:  true,
-			Message:  "Authentication successful",
-			FullName: FullName,
-			Role:     Role,
-			GroupId:  int(GroupId.Int64),
-		})
-
-	} else {
-		log.Printf("correct auth: %d, %s, %s, %s, %s, %d", id, Login, PassHash, FullName, Role, GroupId.Int64)
-		json.NewEncoder(w).Encode(AuthResponse{
-			Success:  true,
-			Message:  "Authentication successful",
-			FullName: FullName,
-			Role:     Role,
-		})
+// запрос пришёл по TLS: только тогда cookie помечаются Secure
+func secureRequest(r *http.Request) bool {
+	return r.TLS != nil
+}
 
+func authSuccessResponse(r *http.Request, FullName, Role string, GroupId int64) AuthResponse {
+	response := AuthResponse{
+		Success:  true,
+		Message:  tr(r, "Authentication successful"),
+		FullName: FullName,
+		Role:     Role,
 	}
-
+	// группа есть только у студентов
+	if Role == "Student" {
+		response.GroupId = int(GroupId)
+	}
+	return response
 }
 
 func LogoutHandler(w http.ResponseWriter, r *http.Request) {
@@ -170,7 +317,7 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 		Value:    "",
 		Path:     "/",
 		HttpOnly: true,
-		Secure:   true,
+		Secure:   secureRequest(r),
 		MaxAge:   -1,
 	})
 
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"qr_code/internal/cipher"
+	"qr_code/internal/cookie"
+	"qr_code/internal/database"
+	"qr_code/internal/i18n"
+	"qr_code/internal/logger"
+	"strings"
+	"time"
+)
+
+// права API токена; каждый следующий включает предыдущие
+const (
+	scopeRead   = "read"
+	scopeExport = "export"
+	scopeManage = "manage"
+	// только cookie сессии: токеном не выполнить
+	scopeSession = ""
+)
+
+var scopeRank = map[string]int{
+	scopeRead:   1,
+	scopeExport: 2,
+	scopeManage: 3,
+}
+
+// префикс, чтобы токен узнавался в логах и сканерах секретов
+const apiTokenPrefix = "qrt_"
+
+// данные пользователя из cookie сессии или Bearer токена.
+// формат как у cookie.DecryptCookie: числа - float64.
+// ошибка - *apiError со статусом и кодом для ответа (или внутренняя ошибка базы)
+func authenticate(r *http.Request, scope string) (map[string]interface{}, error) {
+	userData, err := authenticateRequest(r, scope)
+	if err == nil {
+		// user_id в строке доступа
+		logger.SetUserID(r.Context(), userData["user_id"])
+		// язык из настроек пользователя важнее Accept-Language
+		if language, ok := userData["language"].(string); ok {
+			i18n.SetUserLanguage(r.Context(), language)
+		}
+	}
+	return userData, err
+}
+
+func authenticateRequest(r *http.Request, scope string) (map[string]interface{}, error) {
+	if token, ok := bearerToken(r); ok {
+		if scope == scopeSession {
+			return nil, newAPIError(http.StatusForbidden, codeSessionRequired, "This action requires a browser session")
+		}
+		return authenticateToken(database.Get(), token, scope)
+	}
+
+	sessionCookie, err := r.Cookie("session")
+	if err != nil {
+		return nil, newAPIError(http.StatusUnauthorized, codeUnauthorized, "Not authorized")
+	}
+	userData, err := cookie.DecryptCookie(sessionCookie.Value)
+	if err != nil {
+		return nil, newAPIError(http.StatusUnauthorized, codeSessionInvalid, "Invalid session")
+	}
+	// cookie браузер прикладывает сам, в том числе к запросам с чужих сайтов
+	if !sameOriginRequest(r) {
+		return nil, newAPIError(http.StatusForbidden, codeCSRFRejected, "Cross-site request rejected")
+	}
+	return userData, nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+func authenticateToken(db *sql.DB, token, scope string) (map[string]interface{}, error) {
+	invalid := newAPIError(http.StatusUnauthorized, codeTokenExpired, "Invalid or expired API token")
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, invalid
+	}
+
+	var (
+		tokenID    int64
+		tokenScope string
+		userID     int64
+		login      string
+		fullName   string
+		role       string
+		groupID    sql.NullInt64
+		language   sql.NullString
+	)
+	now := time.Now().Unix()
+	// роль и группа берутся из user: изменения применяются к уже выданным токенам
+	err := db.QueryRow(`
+		SELECT api_tokens.id, api_tokens.Scope, user.id, user.Login, user.FullName, user.Role, user.GroupId, user.Language
+		FROM api_tokens JOIN user ON user.id = api_tokens.UserId
+		WHERE api_tokens.TokenHash = ? AND api_tokens.RevokedAt IS NULL AND api_tokens.ExpiresAt > ?`,
+		cipher.SHA256(token), now,
+	).Scan(&tokenID, &tokenScope, &userID, &login, &fullName, &role, &groupID, &language)
+	if err == sql.ErrNoRows {
+		return nil, invalid
+	}
+	if err != nil {
+		return nil, fmt.Errorf("api token lookup: %w", err)
+	}
+
+	if scopeRank[tokenScope] < scopeRank[scope] {
+		return nil, newAPIError(http.StatusForbidden, codeTokenScope, "API token scope does not allow this action")
+	}
+
+	if _, err := db.Exec(`UPDATE api_tokens SET LastUsedAt = ? WHERE id = ?`, now, tokenID); err != nil {
+		slog.Error("api token last use update failed", "token_id", tokenID, "err", err)
+	}
+
+	return map[string]interface{}{
+		"user_id":   float64(userID),
+		"login":     login,
+		"role":      role,
+		"full_name": fullName,
+		"group_id":  float64(groupID.Int64),
+		"token_id":  float64(tokenID),
+		"language":  language.String,
+	}, nil
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"qr_code/internal/config"
+	"qr_code/internal/cors"
+	"slices"
+	"strings"
+)
+
+// источники (scheme://host[:port]), с которых фронтенд шлёт запросы с cookie, кроме своего хоста
+var trustedOrigins []string
+
+func configureCSRF(cfg config.CSRF) {
+	trustedOrigins = trustedOrigins[:0]
+	for _, origin := range cfg.TrustedOrigins {
+		trustedOrigins = append(trustedOrigins, normalizeOrigin(origin))
+	}
+}
+
+// политика CORS сервера; пустая - только свой origin
+var corsPolicy cors.Policy
+
+func configureCORS(env string, cfg config.CORS) {
+	corsPolicy = cors.DefaultPolicy(env)
+	if len(cfg.AllowedOrigins) > 0 {
+		corsPolicy.AllowedOrigins = cfg.AllowedOrigins
+	}
+	if len(cfg.AllowedMethods) > 0 {
+		corsPolicy.AllowedMethods = cfg.AllowedMethods
+	}
+	if len(cfg.AllowedHeaders) > 0 {
+		corsPolicy.AllowedHeaders = cfg.AllowedHeaders
+	}
+	corsPolicy.AllowCredentials = cfg.AllowCredentials
+	corsPolicy.MaxAge = cfg.MaxAge
+}
+
+func normalizeOrigin(origin string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(origin), "/"))
+}
+
+// проверка что запрос с cookie пришёл со своей страницы.
+// безопасные методы не проверяются: они не должны менять состояние
+func sameOriginRequest(r *http.Request) bool {
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	}
+	return trustedRequestOrigin(r)
+}
+
+// проверка для старого GET /lessons/mark, который меняет состояние.
+// Sec-Fetch-Site: none - адрес открыт самим пользователем (скан QR кода),
+// same-site и cross-site - переход или ресурс с другой страницы, источник должен быть доверенным
+func sameOriginNavigation(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return true
+	case "same-site", "cross-site":
+		if r.Header.Get("Origin") == "" && r.Header.Get("Referer") == "" {
+			return false
+		}
+	}
+	return trustedRequestOrigin(r)
+}
+
+// источник запроса по Origin или Referer: свой хост или csrf.trusted_origins
+func trustedRequestOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// без Origin - по Referer
+		referer := r.Header.Get("Referer")
+		if referer == "" {
+			// не браузер: скрипт сам решает, какие cookie отправить
+			return true
+		}
+		u, err := url.Parse(referer)
+		if err != nil || u.Host == "" {
+			return false
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+
+	// "null" - sandbox iframe, data: и file: страницы
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	// CORS разрешает чтение ответов, но не запросы с cookie: доверенные источники - только csrf.trusted_origins
+	return slices.Contains(trustedOrigins, normalizeOrigin(origin))
+}
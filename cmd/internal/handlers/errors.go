@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"qr_code/internal/logger"
+	"strings"
+)
+
+// стабильные коды ошибок: клиенты сравнивают их, а не текст сообщения
+const (
+	codeMethodNotAllowed = "METHOD_NOT_ALLOWED"
+	codeNotFound         = "NOT_FOUND"
+	codeInvalidJSON      = "INVALID_JSON"
+	codeValidation       = "VALIDATION_FAILED"
+	codeInternal         = "INTERNAL_ERROR"
+
+	codeUnauthorized    = "UNAUTHORIZED"
+	codeSessionInvalid  = "SESSION_INVALID"
+	codeSessionRequired = "SESSION_REQUIRED"
+	codeCSRFRejected    = "CROSS_SITE_REQUEST_REJECTED"
+	codeForbidden       = "FORBIDDEN"
+	codeTokenExpired    = "TOKEN_EXPIRED"
+	codeTokenScope      = "TOKEN_SCOPE_INSUFFICIENT"
+	codeTokenNotFound   = "TOKEN_NOT_FOUND"
+
+	codeInvalidCredentials     = "INVALID_CREDENTIALS"
+	codeOldPasswordIncorrect   = "OLD_PASSWORD_INCORRECT"
+	codePasswordPolicy         = "PASSWORD_POLICY_VIOLATION"
+	codeResetTokenInvalid      = "RESET_TOKEN_INVALID"
+	codeAccountLocked          = "ACCOUNT_LOCKED"
+	codeRateLimited            = "RATE_LIMITED"
+	codeNoAccount              = "NO_ACCOUNT"
+	codeAuthUnavailable        = "AUTH_SERVICE_UNAVAILABLE"
+	codeChallengeInvalid       = "CHALLENGE_INVALID"
+	codeTOTPInvalid            = "TOTP_INVALID"
+	codeTOTPRequired           = "TOTP_REQUIRED"
+	codeTOTPEnrollmentRequired = "TOTP_ENROLLMENT_REQUIRED"
+	codeTOTPAlreadyEnabled     = "TOTP_ALREADY_ENABLED"
+
+	codeOIDCDisabled       = "OIDC_DISABLED"
+	codeOIDCStateInvalid   = "OIDC_STATE_INVALID"
+	codeIdPUnavailable     = "IDP_UNAVAILABLE"
+	codeIdPLoginFailed     = "IDP_LOGIN_FAILED"
+	codeIdPLoginRejected   = "IDP_LOGIN_REJECTED"
+	codeIdPEmailUnverified = "IDP_EMAIL_UNVERIFIED"
+
+	codeLessonNotFound          = "LESSON_NOT_FOUND"
+	codeLessonArchived          = "LESSON_ARCHIVED"
+	codeLessonActive            = "LESSON_STILL_ACTIVE"
+	codeQRTokenInvalid          = "QR_TOKEN_INVALID"
+	codeSessionClosed           = "ATTENDANCE_SESSION_CLOSED"
+	codeAttendanceAlreadyMarked = "ATTENDANCE_ALREADY_MARKED"
+)
+
+// единый формат ошибки; success и message оставлены для старых клиентов
+type ErrorResponse struct {
+	Success   bool   `json:"success"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// ошибка, которую можно показать клиенту как есть
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func newAPIError(status int, code, message string) *apiError {
+	return &apiError{Status: status, Code: code, Message: message}
+}
+
+// message переводится на язык запроса; уже переведённый текст (trf) не меняется
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Success:   false,
+		Code:      code,
+		Message:   tr(r, message),
+		RequestID: logger.RequestID(r.Context()),
+	})
+}
+
+// *apiError отдаётся клиенту, остальные ошибки считаются внутренними
+func writeAPIError(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		writeError(w, r, apiErr.Status, apiErr.Code, apiErr.Message)
+		return
+	}
+	writeInternalError(w, r, err)
+}
+
+// подробности (текст ошибки SQL и т.п.) только в лог, клиенту - request_id для поиска
+func writeInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	logger.FromContext(r.Context()).Error("internal error", "method", r.Method, "path", r.URL.Path, "err", err)
+	writeError(w, r, http.StatusInternalServerError, codeInternal, "Internal server error")
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, trf(r, "Allowed methods: %s", strings.Join(allowed, ", ")))
+}
+
+// 404 и 405 самого ServeMux в том же JSON формате
+func jsonMuxErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		// ServeMux сам выставит Allow для 405; text/plain тело заменяется
+		recorder := &muxErrorRecorder{ResponseWriter: w}
+		mux.ServeHTTP(recorder, r)
+		switch recorder.status {
+		case http.StatusMethodNotAllowed:
+			writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		case http.StatusNotFound:
+			writeError(w, r, http.StatusNotFound, codeNotFound, "Not found")
+		}
+	})
+}
+
+// перехватывает только 404 и 405; перенаправления ServeMux проходят как есть
+type muxErrorRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (m *muxErrorRecorder) WriteHeader(status int) {
+	if status == http.StatusNotFound || status == http.StatusMethodNotAllowed {
+		m.status = status
+		return
+	}
+	m.ResponseWriter.WriteHeader(status)
+}
+
+func (m *muxErrorRecorder) Write(b []byte) (int, error) {
+	if m.status != 0 {
+		return len(b), nil
+	}
+	return m.ResponseWriter.Write(b)
+}
@@ -1,13 +1,45 @@
 package handlers
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"qr_code/internal/apidoc"
+	"qr_code/internal/authn"
+	"qr_code/internal/cipher"
+	"qr_code/internal/config"
+	"qr_code/internal/cookie"
+	"qr_code/internal/cors"
 	"qr_code/internal/database"
+	"qr_code/internal/i18n"
+	"qr_code/internal/logger"
+	"qr_code/internal/notify"
+	"qr_code/internal/oidc"
+	"qr_code/internal/password"
+	"qr_code/internal/qrtoken"
+	"qr_code/internal/ratelimit"
+	"qr_code/internal/server"
+	"qr_code/internal/totp"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -84,14 +116,14 @@ func initTestDB(t *testing.T) *sql.DB {
 // setupTest подготавливает тестовое окружение
 func setupTest(t *testing.T) (*sql.DB, func()) {
 	db := initTestDB(t)
-	
+
 	// Сохраняем оригинальную базу
 	originalDB := database.Get()
-	
+
 	// Подменяем глобальную переменную в пакете database
 	// ВАЖНО: это работает только если переменная db в database.go экспортирована
 	// или если мы используем рефлексию. Давайте лучше создадим тесты без базы.
-	
+
 	return db, func() {
 		db.Close()
 		_ = originalDB // Используем чтобы избежать ошибки компиляции
@@ -107,22 +139,22 @@ func TestAuthHandler(t *testing.T) {
 		"login":    "",
 		"password": "",
 	}
-	
+
 	body, _ := json.Marshal(authData)
 	req := httptest.NewRequest("POST", "/auth", bytes.NewReader(body))
 	req.Header.Set("Content-Type", "application/json")
-	
+
 	w := httptest.NewRecorder()
 	handler_auth(w, req)
-	
+
 	if w.Code != http.StatusBadRequest {
 		t.Errorf("Expected status 400 for empty fields, got %d", w.Code)
 	}
-	
+
 	// Проверяем структуру ответа
 	var response map[string]interface{}
 	json.Unmarshal(w.Body.Bytes(), &response)
-	
+
 	if response["success"] != false {
 		t.Errorf("Expected success false for empty fields")
 	}
@@ -132,10 +164,10 @@ func TestAuthHandler(t *testing.T) {
 func TestAuthHandlerInvalidJSON(t *testing.T) {
 	req := httptest.NewRequest("POST", "/auth", bytes.NewReader([]byte("{invalid json")))
 	req.Header.Set("Content-Type", "application/json")
-	
+
 	w := httptest.NewRecorder()
 	handler_auth(w, req)
-	
+
 	if w.Code != http.StatusBadRequest {
 		t.Errorf("Expected status 400 for invalid JSON, got %d", w.Code)
 	}
@@ -144,27 +176,29 @@ func TestAuthHandlerInvalidJSON(t *testing.T) {
 // TestAuthHandlerInvalidMethod тестирует неправильный метод
 func TestAuthHandlerInvalidMethod(t *testing.T) {
 	req := httptest.NewRequest("GET", "/auth", nil)
-	
+
 	w := httptest.NewRecorder()
 	handler_auth(w, req)
-	
-	// Ваш обработчик проверяет метод и возвращает JSON с ошибкой
-	if w.Code != http.StatusOK { // OPTIONS handler
-		t.Logf("Response body: %s", w.Body.String())
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for GET, got %d", w.Code)
+	}
+	if got := w.Header().Get("Allow"); got != "POST" {
+		t.Errorf("Expected Allow: POST, got %q", got)
 	}
 }
 
 // TestLogoutHandler тестирует выход из системы
 func TestLogoutHandler(t *testing.T) {
 	req := httptest.NewRequest("GET", "/logout", nil)
-	
+
 	w := httptest.NewRecorder()
 	LogoutHandler(w, req)
-	
+
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected status 200, got %d", w.Code)
 	}
-	
+
 	// Проверяем что в ответе есть текст
 	body := w.Body.String()
 	if body != "Cookie deleted" {
@@ -175,10 +209,10 @@ func TestLogoutHandler(t *testing.T) {
 // TestStudentGetInfoHandlerUnauthorized тестирует доступ без авторизации
 func TestStudentGetInfoHandlerUnauthorized(t *testing.T) {
 	req := httptest.NewRequest("GET", "/student/getInfo", nil)
-	
+
 	w := httptest.NewRecorder()
 	handler_student_getinfo(w, req)
-	
+
 	if w.Code != http.StatusUnauthorized {
 		t.Errorf("Expected status 401 for unauthorized, got %d", w.Code)
 	}
@@ -187,10 +221,10 @@ func TestStudentGetInfoHandlerUnauthorized(t *testing.T) {
 // TestTeacherGetInfoHandlerUnauthorized тестирует доступ без авторизации
 func TestTeacherGetInfoHandlerUnauthorized(t *testing.T) {
 	req := httptest.NewRequest("GET", "/teacher/getInfo", nil)
-	
+
 	w := httptest.NewRecorder()
 	handler_teacher_getinfo(w, req)
-	
+
 	// Этот тест будет падать, потому что handler обращается к базе данных
 	// Временно пропустим его
 	t.Skip("Skipping test because it requires database initialization")
@@ -204,13 +238,13 @@ func TestLessonCreateHandlerUnauthorized(t *testing.T) {
 		"type": "Лекция",
 	}
 	body, _ := json.Marshal(lessonData)
-	
+
 	req := httptest.NewRequest("POST", "/lessons/create", bytes.NewReader(body))
 	req.Header.Set("Content-Type", "application/json")
-	
+
 	w := httptest.NewRecorder()
 	handler_lessons_create(w, req)
-	
+
 	if w.Code != http.StatusUnauthorized {
 		t.Errorf("Expected status 401 for unauthorized, got %d", w.Code)
 	}
@@ -229,37 +263,45 @@ func TestOptionsHandler(t *testing.T) {
 		{"student/getInfo", "/student/getInfo", handler_student_getinfo},
 		{"archive/getLessons", "/archive/getLessons", handler_archive_getlessons},
 	}
-	
+
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			req := httptest.NewRequest("OPTIONS", tc.path, nil)
 			w := httptest.NewRecorder()
-			
+
 			tc.handler(w, req)
-			
-			if w.Code != http.StatusOK {
-				t.Errorf("Expected status 200 for OPTIONS on %s, got %d", tc.path, w.Code)
+
+			// preflight отвечает CORS middleware, до обработчика OPTIONS не доходит
+			if w.Code != http.StatusMethodNotAllowed {
+				t.Errorf("Expected status 405 for OPTIONS on %s, got %d", tc.path, w.Code)
+			}
+			if w.Header().Get("Allow") == "" {
+				t.Errorf("Expected Allow header for OPTIONS on %s", tc.path)
+			}
+			var response ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Code != codeMethodNotAllowed {
+				t.Errorf("Expected code %s for OPTIONS on %s, got %q (%v)", codeMethodNotAllowed, tc.path, response.Code, err)
 			}
 		})
 	}
-	
+
 	// Тесты с базой данных пропускаем
 	t.Run("teacher/getInfo", func(t *testing.T) {
 		t.Skip("Skipping because it requires database")
 	})
-	
+
 	t.Run("teacher/getLesson", func(t *testing.T) {
 		t.Skip("Skipping because it requires database")
 	})
-	
+
 	t.Run("teacher/export", func(t *testing.T) {
 		t.Skip("Skipping because it requires database")
 	})
-	
+
 	t.Run("archive/deleteLesson", func(t *testing.T) {
 		t.Skip("Skipping because it requires database")
 	})
-	
+
 	t.Run("archive/add", func(t *testing.T) {
 		t.Skip("Skipping because it requires database")
 	})
@@ -275,19 +317,19 @@ func TestCookieHandlers(t *testing.T) {
 func TestDatabaseInitialization(t *testing.T) {
 	// Создаем временный файл базы данных
 	tempFile := t.TempDir() + "/test.db"
-	
+
 	// Инициализируем базу
 	db, err := sql.Open("sqlite3", tempFile)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer db.Close()
-	
+
 	// Проверяем соединение
 	if err := db.Ping(); err != nil {
 		t.Fatal(err)
 	}
-	
+
 	t.Logf("Test database created at: %s", tempFile)
 }
 
@@ -312,38 +354,38 @@ func TestSimpleHandlers(t *testing.T) {
 			method:   "OPTIONS",
 			path:     "/auth",
 			handler:  handler_auth,
-			wantCode: http.StatusOK,
+			wantCode: http.StatusMethodNotAllowed,
 		},
 		{
 			name:     "Student info OPTIONS",
 			method:   "OPTIONS",
 			path:     "/student/getInfo",
 			handler:  handler_student_getinfo,
-			wantCode: http.StatusOK,
+			wantCode: http.StatusMethodNotAllowed,
 		},
 		{
 			name:     "Lessons create OPTIONS",
 			method:   "OPTIONS",
 			path:     "/lessons/create",
 			handler:  handler_lessons_create,
-			wantCode: http.StatusOK,
+			wantCode: http.StatusMethodNotAllowed,
 		},
 		{
 			name:     "Lessons mark OPTIONS",
 			method:   "OPTIONS",
 			path:     "/lessons/mark",
 			handler:  handler_lessons_mark,
-			wantCode: http.StatusOK,
+			wantCode: http.StatusMethodNotAllowed,
 		},
 	}
-	
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			req := httptest.NewRequest(tt.method, tt.path, nil)
 			w := httptest.NewRecorder()
-			
+
 			tt.handler(w, req)
-			
+
 			if w.Code != tt.wantCode {
 				t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.wantCode, w.Code)
 			}
@@ -356,21 +398,21 @@ func TestJSONResponses(t *testing.T) {
 	// Тестируем ответ при неавторизованном доступе к student/getInfo
 	req := httptest.NewRequest("GET", "/student/getInfo", nil)
 	w := httptest.NewRecorder()
-	
+
 	handler_student_getinfo(w, req)
-	
+
 	// Проверяем Content-Type
 	contentType := w.Header().Get("Content-Type")
 	if contentType != "application/json" {
 		t.Errorf("Expected Content-Type application/json, got %s", contentType)
 	}
-	
+
 	// Проверяем что ответ валидный JSON
 	var response map[string]interface{}
 	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
 		t.Errorf("Response is not valid JSON: %v", err)
 	}
-	
+
 	// Проверяем наличие обязательных полей
 	if _, ok := response["success"]; !ok {
 		t.Error("JSON response should have 'success' field")
@@ -384,10 +426,14 @@ func TestJSONResponses(t *testing.T) {
 func TestCORSHeaders(t *testing.T) {
 	req := httptest.NewRequest("OPTIONS", "/auth", nil)
 	req.Header.Set("Origin", "http://example.com")
-	
+	req.Header.Set("Access-Control-Request-Method", "POST")
+
+	// preflight отвечает middleware, origin должен быть в списке
+	policy := cors.DefaultPolicy("prod")
+	policy.AllowedOrigins = []string{"http://example.com"}
 	w := httptest.NewRecorder()
-	handler_auth(w, req)
-	
+	cors.Middleware(policy, http.HandlerFunc(handler_auth)).ServeHTTP(w, req)
+
 	// Проверяем CORS заголовки
 	headers := []string{
 		"Access-Control-Allow-Origin",
@@ -395,10 +441,2057 @@ func TestCORSHeaders(t *testing.T) {
 		"Access-Control-Allow-Headers",
 		"Access-Control-Allow-Credentials",
 	}
-	
+
 	for _, header := range headers {
 		if w.Header().Get(header) == "" {
 			t.Errorf("CORS header %s should be set", header)
 		}
 	}
-}
\ No newline at end of file
+}
+
+// TestTokensCreateRequiresSession проверяет что API токеном нельзя выпустить новый токен
+func TestTokensCreateRequiresSession(t *testing.T) {
+	req := httptest.NewRequest("POST", "/tokens/create", bytes.NewReader([]byte(`{"name":"x","scope":"manage"}`)))
+	req.Header.Set("Authorization", "Bearer qrt_anything")
+	w := httptest.NewRecorder()
+	handler_tokens_create(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for bearer token, got %d", w.Code)
+	}
+}
+
+// TestSameOriginRequest проверяет проверку Origin/Referer для запросов с cookie
+func TestSameOriginRequest(t *testing.T) {
+	trustedOrigins = []string{"https://front.example.org"}
+	savedCORS := corsPolicy
+	corsPolicy.AllowedOrigins = []string{"https://cors.example.org"}
+	defer func() { trustedOrigins, corsPolicy = nil, savedCORS }()
+
+	testCases := []struct {
+		name    string
+		method  string
+		origin  string
+		referer string
+		want    bool
+	}{
+		{"safe method", "GET", "https://evil.example", "", true},
+		{"same host", "POST", "http://app.local", "", true},
+		{"trusted origin", "POST", "https://front.example.org", "", true},
+		{"foreign origin", "POST", "https://evil.example", "", false},
+		{"null origin", "POST", "null", "", false},
+		{"foreign referer", "POST", "", "https://evil.example/page", false},
+		{"same referer", "POST", "", "http://app.local/archive", true},
+		{"no browser headers", "POST", "", "", true},
+		{"foreign origin delete", "DELETE", "https://evil.example", "", false},
+		// разрешённый для CORS источник не становится доверенным для запросов с cookie
+		{"cors origin", "POST", "https://cors.example.org", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "http://app.local/archive/deleteLesson", nil)
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+			if tc.referer != "" {
+				req.Header.Set("Referer", tc.referer)
+			}
+			if got := sameOriginRequest(req); got != tc.want {
+				t.Errorf("Expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+// TestArchiveDeleteCSRF проверяет что удаление из архива нельзя вызвать с чужого сайта
+func TestArchiveDeleteCSRF(t *testing.T) {
+	session, err := cookie.EncryptCookie(map[string]interface{}{
+		"user_id": 1,
+		"login":   "teacher1",
+		"role":    "Teacher",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// GET больше не удаляет (<img src=...>)
+	req := httptest.NewRequest("GET", "http://app.local/archive/deleteLesson?lessonId=1", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: session})
+	w := httptest.NewRecorder()
+	handler_archive_deleteLesson(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for GET, got %d", w.Code)
+	}
+
+	// POST с чужого сайта
+	req = httptest.NewRequest("POST", "http://app.local/archive/deleteLesson?lessonId=1", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	req.AddCookie(&http.Cookie{Name: "session", Value: session})
+	w = httptest.NewRecorder()
+	handler_archive_deleteLesson(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for cross-site POST, got %d", w.Code)
+	}
+
+	// POST со своей страницы проходит проверку (дальше - валидация параметров)
+	req = httptest.NewRequest("POST", "http://app.local/archive/deleteLesson", nil)
+	req.Header.Set("Origin", "http://app.local")
+	req.AddCookie(&http.Cookie{Name: "session", Value: session})
+	w = httptest.NewRecorder()
+	handler_archive_deleteLesson(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for same-origin POST without lessonId, got %d", w.Code)
+	}
+}
+
+// TestMetricsHandlerToken проверяет ответ /metrics без токена: JSON ошибка и WWW-Authenticate
+func TestMetricsHandlerToken(t *testing.T) {
+	useTestDatabase(t)
+	handler := metricsHandler(config.Metrics{Enabled: true, Path: "/metrics", BearerToken: "metrics-secret"})
+
+	for _, authorization := range []string{"", "Bearer wrong"} {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		var response ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if w.Code != http.StatusUnauthorized || response.Code != codeUnauthorized {
+			t.Errorf("%q: expected 401 %s, got %d: %s", authorization, codeUnauthorized, w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("WWW-Authenticate"); !strings.HasPrefix(got, "Bearer") {
+			t.Errorf("%q: expected Bearer challenge, got %q", authorization, got)
+		}
+		if err := apidoc.ValidateResponse("GET", "/metrics", w.Code, w.Header().Get("Content-Type"), w.Body.Bytes()); err != nil {
+			t.Error(err)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer metrics-secret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "qr_login_attempts_total") {
+		t.Errorf("Expected metrics with token, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestLegacyMarkCSRF проверяет что старый GET /lessons/mark нельзя вызвать с чужой страницы
+func TestLegacyMarkCSRF(t *testing.T) {
+	session, err := cookie.EncryptCookie(map[string]interface{}{
+		"user_id": 2,
+		"login":   "student",
+		"role":    "Student",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		headers  map[string]string
+		wantCode int
+	}{
+		// <img src=...> или ссылка на чужом сайте
+		{"cross-site with referer", map[string]string{"Sec-Fetch-Site": "cross-site", "Referer": "https://evil.example/page"}, http.StatusForbidden},
+		{"cross-site no-referrer", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"foreign referer", map[string]string{"Referer": "https://evil.example/page"}, http.StatusForbidden},
+		// скан QR кода открывает адрес напрямую; дальше - проверка параметров
+		{"opened by user", map[string]string{"Sec-Fetch-Site": "none"}, http.StatusBadRequest},
+		{"same origin", map[string]string{"Sec-Fetch-Site": "same-origin", "Referer": "http://app.local/scan"}, http.StatusBadRequest},
+		{"not a browser", nil, http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://app.local/lessons/mark", nil)
+			for name, value := range tc.headers {
+				req.Header.Set(name, value)
+			}
+			req.AddCookie(&http.Cookie{Name: "session", Value: session})
+			w := httptest.NewRecorder()
+			handler_lessons_mark(w, req)
+			if w.Code != tc.wantCode {
+				t.Errorf("Expected status %d, got %d: %s", tc.wantCode, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+// TestRouterMethods проверяет маршрутизацию по методу и 405 с Allow
+func TestRouterMethods(t *testing.T) {
+	router := newRouter()
+
+	testCases := []struct {
+		name      string
+		method    string
+		path      string
+		wantCode  int
+		wantAllow string
+	}{
+		{"v1 lesson without session", "GET", "/api/v1/lessons/5", http.StatusUnauthorized, ""},
+		{"v1 lesson wrong method", "PUT", "/api/v1/lessons/5", http.StatusMethodNotAllowed, "GET, HEAD, PATCH"},
+		{"v1 archive delete wrong method", "GET", "/api/v1/archive/5", http.StatusMethodNotAllowed, "DELETE"},
+		{"legacy auth wrong method", "GET", "/auth", http.StatusMethodNotAllowed, "POST"},
+		{"legacy archive add GET", "GET", "/archive/add?lessonId=1", http.StatusMethodNotAllowed, "POST"},
+		{"v1 attendance GET", "GET", "/api/v1/attendance?token=x", http.StatusMethodNotAllowed, "POST"},
+		{"legacy student info", "GET", "/student/getInfo", http.StatusUnauthorized, ""},
+		{"unknown path", "GET", "/api/v1/nothing", http.StatusNotFound, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tc.wantCode {
+				t.Errorf("%s %s: expected status %d, got %d", tc.method, tc.path, tc.wantCode, w.Code)
+			}
+			if tc.wantAllow != "" && w.Header().Get("Allow") != tc.wantAllow {
+				t.Errorf("Expected Allow %q, got %q", tc.wantAllow, w.Header().Get("Allow"))
+			}
+		})
+	}
+}
+
+// TestLessonIDParam проверяет id занятия из пути и из query
+func TestLessonIDParam(t *testing.T) {
+	var got string
+	mux := http.NewServeMux()
+	capture := func(w http.ResponseWriter, r *http.Request) { got = lessonIDParam(r) }
+	mux.HandleFunc("GET /api/v1/lessons/{id}", capture)
+	mux.HandleFunc("GET /teacher/getLesson", capture)
+
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/lessons/42", nil))
+	if got != "42" {
+		t.Errorf("Expected id 42 from path, got %q", got)
+	}
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/teacher/getLesson?lessonId=7", nil))
+	if got != "7" {
+		t.Errorf("Expected id 7 from query, got %q", got)
+	}
+}
+
+// TestHealthz проверяет что проверка жизни не зависит от базы
+func TestHealthz(t *testing.T) {
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("GET", server.HealthPath, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var response HealthResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil || !response.Success {
+		t.Errorf("Expected success JSON, got %q", w.Body.String())
+	}
+}
+
+// TestCheckCertificate проверяет порог срока действия сертификата в /readyz
+func TestCheckCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.crt")
+	if err := server.GenerateSelfSigned(certFile, filepath.Join(dir, "server.key"), []string{"localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	defer func(file string, days int) { readyCertFile, readyCertMinDays = file, days }(readyCertFile, readyCertMinDays)
+	readyCertFile = certFile
+
+	testCases := []struct {
+		name    string
+		minDays int
+		now     time.Time
+		want    string
+	}{
+		{"valid", 7, time.Now(), checkOK},
+		// самоподписанный выпускается на год
+		{"expires soon", 7, time.Now().AddDate(0, 0, 360), checkFail},
+		{"expired", 0, time.Now().AddDate(2, 0, 0), checkFail},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			readyCertMinDays = tc.minDays
+			if got := checkCertificate(tc.now); got.Status != tc.want {
+				t.Errorf("Expected %s, got %+v", tc.want, got)
+			}
+		})
+	}
+
+	// путь к файлу и текст ошибки наружу не отдаются
+	readyCertFile = filepath.Join(dir, "missing.crt")
+	if got := checkCertificate(time.Now()); got.Status != checkFail || got.Detail != checkFailedDetail {
+		t.Errorf("Expected fail with generic detail for missing certificate, got %+v", got)
+	}
+}
+
+// TestErrorEnvelope проверяет формат ошибки и что внутренние детали не уходят клиенту
+func TestErrorEnvelope(t *testing.T) {
+	handler := logger.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/internal" {
+			writeAPIError(w, r, errors.New("no such table: attendances"))
+			return
+		}
+		writeAPIError(w, r, newAPIError(http.StatusConflict, codeAttendanceAlreadyMarked, "Attendance already marked"))
+	}))
+
+	testCases := []struct {
+		path       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"/conflict", http.StatusConflict, codeAttendanceAlreadyMarked},
+		{"/internal", http.StatusInternalServerError, codeInternal},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tc.path, nil)
+			req.Header.Set(logger.RequestIDHeader, "req-1")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.wantStatus {
+				t.Errorf("Expected status %d, got %d", tc.wantStatus, w.Code)
+			}
+			var response ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Response is not JSON: %q", w.Body.String())
+			}
+			if response.Success || response.Code != tc.wantCode || response.RequestID != "req-1" {
+				t.Errorf("Unexpected error envelope: %+v", response)
+			}
+			if bytes.Contains(w.Body.Bytes(), []byte("no such table")) {
+				t.Errorf("Internal error leaked to client: %s", w.Body.String())
+			}
+		})
+	}
+}
+
+// TestRouterJSONErrors проверяет что 404 и 405 самого роутера тоже в JSON
+func TestRouterJSONErrors(t *testing.T) {
+	handler := jsonMuxErrors(newRouter())
+
+	testCases := []struct {
+		method     string
+		path       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"GET", "/api/v1/nope", http.StatusNotFound, codeNotFound},
+		{"DELETE", server.HealthPath, http.StatusMethodNotAllowed, codeMethodNotAllowed},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+
+			if w.Code != tc.wantStatus {
+				t.Errorf("Expected status %d, got %d", tc.wantStatus, w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Expected JSON content type, got %q", ct)
+			}
+			var response ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Code != tc.wantCode {
+				t.Errorf("Expected code %s, got %q", tc.wantCode, w.Body.String())
+			}
+		})
+	}
+}
+
+// TestMessagesTranslated проверяет что у каждого сообщения хендлеров есть русский перевод
+func TestMessagesTranslated(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi fs.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// строковые константы и переменные: сообщения часто передаются через них
+	values := map[string][]string{}
+	var literal func(expr ast.Expr) (string, bool)
+	literal = func(expr ast.Expr) (string, bool) {
+		switch e := expr.(type) {
+		case *ast.BasicLit:
+			if e.Kind == token.STRING {
+				value, err := strconv.Unquote(e.Value)
+				return value, err == nil
+			}
+		case *ast.BinaryExpr:
+			left, ok1 := literal(e.X)
+			right, ok2 := literal(e.Y)
+			return left + right, ok1 && ok2 && e.Op == token.ADD
+		}
+		return "", false
+	}
+	for _, pkg := range pkgs {
+		ast.Inspect(pkg, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.ValueSpec:
+				for i, name := range n.Names {
+					if i < len(n.Values) {
+						if value, ok := literal(n.Values[i]); ok {
+							values[name.Name] = append(values[name.Name], value)
+						}
+					}
+				}
+			case *ast.AssignStmt:
+				for i, lhs := range n.Lhs {
+					if ident, ok := lhs.(*ast.Ident); ok && i < len(n.Rhs) {
+						if value, ok := literal(n.Rhs[i]); ok {
+							values[ident.Name] = append(values[ident.Name], value)
+						}
+					}
+				}
+			}
+			return true
+		})
+	}
+
+	// аргумент с сообщением у функций перевода и ответа с ошибкой
+	messageArg := map[string]int{"writeError": 4, "newAPIError": 2, "tr": 1, "trf": 1, "T": 1, "Tf": 1}
+	checked := 0
+	for _, pkg := range pkgs {
+		ast.Inspect(pkg, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			var name string
+			switch fun := call.Fun.(type) {
+			case *ast.Ident:
+				name = fun.Name
+			case *ast.SelectorExpr:
+				name = fun.Sel.Name
+			}
+			index, ok := messageArg[name]
+			if !ok || index >= len(call.Args) {
+				return true
+			}
+
+			var messages []string
+			if value, ok := literal(call.Args[index]); ok {
+				messages = []string{value}
+			} else if ident, ok := call.Args[index].(*ast.Ident); ok {
+				messages = values[ident.Name]
+			}
+			for _, message := range messages {
+				checked++
+				if !i18n.Has(i18n.Russian, message) {
+					t.Errorf("%s: no Russian translation for %q", fset.Position(call.Pos()), message)
+				}
+			}
+			return true
+		})
+	}
+	if checked < 100 {
+		t.Errorf("Expected to find handler messages, checked only %d", checked)
+	}
+
+	// нарушения политики паролей переводятся по тексту ошибки
+	for _, err := range []error{password.ErrNoLetter, password.ErrNoDigit, password.ErrHasLogin, password.ErrWhitespaces} {
+		if !i18n.Has(i18n.Russian, err.Error()) {
+			t.Errorf("No Russian translation for password error %q", err)
+		}
+	}
+}
+
+// TestLocalizedErrors проверяет язык сообщения по Accept-Language при неизменном коде
+func TestLocalizedErrors(t *testing.T) {
+	handler := i18n.Middleware(http.HandlerFunc(handler_auth))
+	testCases := []struct {
+		acceptLanguage string
+		wantMessage    string
+	}{
+		{"ru-RU,ru;q=0.9", "Неверный формат JSON"},
+		{"en-US", "Invalid JSON format"},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("POST", "/auth", bytes.NewReader([]byte("{invalid json")))
+		req.Header.Set("Accept-Language", tc.acceptLanguage)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		var response ErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Code != codeInvalidJSON || response.Message != tc.wantMessage {
+			t.Errorf("%s: expected %s %q, got %s %q", tc.acceptLanguage, codeInvalidJSON, tc.wantMessage, response.Code, response.Message)
+		}
+	}
+}
+
+// TestRoutesDocumented сверяет маршруты router.go с описанием API в обе стороны
+func TestRoutesDocumented(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "router.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// константы путей из server подставляются по значению
+	constants := map[string]string{"HealthPath": server.HealthPath, "ReadyPath": server.ReadyPath}
+	var routes []apidoc.Operation
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		if sel, ok := call.Fun.(*ast.SelectorExpr); !ok || sel.Sel.Name != "HandleFunc" {
+			return true
+		}
+		var pattern string
+		ast.Inspect(call.Args[0], func(n ast.Node) bool {
+			switch e := n.(type) {
+			case *ast.BasicLit:
+				value, _ := strconv.Unquote(e.Value)
+				pattern += value
+			case *ast.SelectorExpr:
+				pattern += constants[e.Sel.Name]
+				return false
+			}
+			return true
+		})
+		method, path, found := strings.Cut(pattern, " ")
+		if !found {
+			method, path = "", pattern
+		}
+		routes = append(routes, apidoc.Operation{Method: method, Path: path})
+		return true
+	})
+	if len(routes) < 50 {
+		t.Fatalf("Expected to find router patterns, got %d", len(routes))
+	}
+
+	documented := map[apidoc.Operation]bool{}
+	for _, op := range apidoc.Operations() {
+		documented[op] = false
+	}
+	// /metrics регистрируется в NewHTTPHandler по конфигу
+	documented[apidoc.Operation{Method: "GET", Path: "/metrics"}] = true
+	for _, route := range routes {
+		matched := false
+		for op := range documented {
+			// маршрут без метода (/logout) покрывает все описанные методы пути
+			if op.Path == route.Path && (route.Method == "" || op.Method == route.Method) {
+				documented[op] = true
+				matched = true
+			}
+		}
+		if !matched {
+			t.Errorf("Route %s %s is not documented in openapi.yaml", route.Method, route.Path)
+		}
+	}
+	for op, used := range documented {
+		if !used {
+			t.Errorf("Documented operation %s %s is not registered in router.go", op.Method, op.Path)
+		}
+	}
+}
+
+// TestErrorCodesDocumented сверяет коды ошибок errors.go с enum схемы Error
+func TestErrorCodesDocumented(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "errors.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var codes []string
+	ast.Inspect(file, func(n ast.Node) bool {
+		spec, ok := n.(*ast.ValueSpec)
+		if !ok {
+			return true
+		}
+		for i, name := range spec.Names {
+			if strings.HasPrefix(name.Name, "code") && i < len(spec.Values) {
+				if lit, ok := spec.Values[i].(*ast.BasicLit); ok {
+					value, _ := strconv.Unquote(lit.Value)
+					codes = append(codes, value)
+				}
+			}
+		}
+		return true
+	})
+	documented := apidoc.SchemaEnum("Error", "code")
+	slices.Sort(codes)
+	slices.Sort(documented)
+	if len(codes) == 0 || !slices.Equal(codes, documented) {
+		t.Errorf("Error codes differ:\nerrors.go: %v\nopenapi.yaml: %v", codes, documented)
+	}
+}
+
+var (
+	testDatabaseOnce sync.Once
+	testDatabaseDir  string
+)
+
+// временная база удаляется после всех тестов пакета
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if testDatabaseDir != "" {
+		database.Close()
+		os.RemoveAll(testDatabaseDir)
+	}
+	os.Exit(code)
+}
+
+// useTestDatabase поднимает конфиг и базу во временной папке один раз на пакет тестов;
+// пользователи teacher и student с паролем "password"; письма дописываются в <tmp>/mail.log
+func useTestDatabase(t *testing.T) {
+	t.Helper()
+	testDatabaseOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "qr_code_test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testDatabaseDir = dir
+		configPath := filepath.Join(dir, "test.yaml")
+		// относительные пути считаются от base_dir (папка над конфигом), поэтому путь базы абсолютный
+		configYAML := "env: \"local\"\nstorage_path: \"" + filepath.Join(dir, "db.sqlite") + "\"\nauth:\n  backends: [\"sqlite\"]\n" +
+			"notifier:\n  kind: \"file\"\n  file_path: \"" + filepath.Join(dir, "mail.log") + "\"\n"
+		if err := os.WriteFile(configPath, []byte(configYAML), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("CONFIG_PATH", configPath)
+		config.MustLoad()
+		database.MustInit()
+		notify.MustInit()
+
+		_, err = database.Get().Exec(`
+			INSERT OR IGNORE INTO user (Login, PassHash, FullName, Role, GroupId)
+			VALUES
+			('teacher', '5f4dcc3b5aa765d61d8327deb882cf99', 'Иванов Иван', 'Teacher', NULL),
+			('student', '5f4dcc3b5aa765d61d8327deb882cf99', 'Петров Петр', 'Student', 101)`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// testClient - запросы к полному обработчику с cookie сессии
+type testClient struct {
+	t       *testing.T
+	handler http.Handler
+	cookies []*http.Cookie
+}
+
+func (c *testClient) do(method, target, body string) *httptest.ResponseRecorder {
+	c.t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if method != "GET" {
+		req.Header.Set("Origin", "http://"+req.Host)
+	}
+	for _, cookie := range c.cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	c.handler.ServeHTTP(w, req)
+	if cookies := w.Result().Cookies(); len(cookies) > 0 {
+		c.cookies = cookies
+	}
+	return w
+}
+
+// relaxAuthLimits снимает лимиты входа на время теста: они общие для всех тестов пакета
+func relaxAuthLimits(t *testing.T) {
+	savedIP, savedLogin := authIPLimiter, authLoginLimiter
+	authIPLimiter = ratelimit.New(ratelimit.NewMemoryStore(), "auth:ip:", 1000, 1000)
+	authLoginLimiter = ratelimit.New(ratelimit.NewMemoryStore(), "auth:login:", 1000, 1000)
+	t.Cleanup(func() { authIPLimiter, authLoginLimiter = savedIP, savedLogin })
+}
+
+// TestContractResponses проверяет ответы хендлеров по схемам openapi.yaml
+func TestContractResponses(t *testing.T) {
+	useTestDatabase(t)
+	handler := NewHTTPHandler()
+	teacher := &testClient{t: t, handler: handler}
+	student := &testClient{t: t, handler: handler}
+	anonymous := &testClient{t: t, handler: handler}
+
+	check := func(client *testClient, method, target, body string, wantStatus int) *httptest.ResponseRecorder {
+		t.Helper()
+		w := client.do(method, target, body)
+		if w.Code != wantStatus {
+			t.Errorf("%s %s: expected status %d, got %d: %s", method, target, wantStatus, w.Code, w.Body.String())
+		}
+		path, _, _ := strings.Cut(target, "?")
+		if err := apidoc.ValidateResponse(method, path, w.Code, w.Header().Get("Content-Type"), w.Body.Bytes()); err != nil {
+			t.Error(err)
+		}
+		return w
+	}
+
+	check(teacher, "POST", "/api/v1/auth/login", `{"login":"teacher","password":"password"}`, http.StatusOK)
+	check(student, "POST", "/api/v1/auth/login", `{"login":"student","password":"password"}`, http.StatusOK)
+	check(anonymous, "POST", "/api/v1/auth/login", `{"login":"teacher","password":"wrong"}`, http.StatusUnauthorized)
+
+	w := check(teacher, "POST", "/api/v1/lessons", `{"name":"Math","date":"2024-01-01","type":"Lecture","groups":[101]}`, http.StatusOK)
+	check(teacher, "POST", "/api/v1/lessons", `{"name":"Math","date":"01.01.2024","type":"Lecture"}`, http.StatusBadRequest)
+	var created LessonCreateResponse
+	json.Unmarshal(w.Body.Bytes(), &created)
+	var lessonID int64
+	if err := database.Get().QueryRow(`SELECT id FROM lessons WHERE QrToken = ?`, created.QrToken).Scan(&lessonID); err != nil {
+		t.Fatal(err)
+	}
+	lesson := "/api/v1/lessons/" + strconv.FormatInt(lessonID, 10)
+
+	check(teacher, "GET", "/api/v1/lessons", "", http.StatusOK)
+	check(teacher, "GET", "/teacher/getInfo", "", http.StatusOK)
+	check(teacher, "GET", "/api/v1/lessons?limit=1&sort=name&name=Ma&from=2024-01-01&type=Lecture&group=101", "", http.StatusOK)
+	check(teacher, "GET", "/api/v1/lessons?sort=size", "", http.StatusBadRequest)
+	check(teacher, "GET", lesson, "", http.StatusOK)
+	w = check(teacher, "PATCH", lesson, `{"type":"Seminar"}`, http.StatusOK)
+	json.Unmarshal(w.Body.Bytes(), &created)
+	check(teacher, "GET", lesson+"/history", "", http.StatusOK)
+	check(teacher, "POST", lesson+"/close", "", http.StatusOK)
+	check(teacher, "POST", lesson+"/open", "", http.StatusOK)
+	check(student, "POST", "/api/v1/attendance?token="+url.QueryEscape(created.QrToken), "", http.StatusOK)
+	check(student, "POST", "/api/v1/attendance?token="+url.QueryEscape(created.QrToken), "", http.StatusConflict)
+	check(student, "GET", "/api/v1/student", "", http.StatusOK)
+	check(teacher, "GET", lesson+"/export", "", http.StatusOK)
+	check(teacher, "POST", lesson+"/archive", "", http.StatusOK)
+	check(teacher, "POST", lesson+"/unarchive", "", http.StatusOK)
+	check(teacher, "POST", lesson+"/archive", "", http.StatusOK)
+	check(teacher, "GET", "/api/v1/archive", "", http.StatusOK)
+	check(teacher, "DELETE", "/api/v1/archive/"+strconv.FormatInt(lessonID, 10), "", http.StatusOK)
+	check(teacher, "GET", lesson, "", http.StatusNotFound)
+	check(teacher, "GET", "/api/v1/trash", "", http.StatusOK)
+	check(teacher, "POST", "/api/v1/trash/"+strconv.FormatInt(lessonID, 10)+"/restore", "", http.StatusOK)
+	check(teacher, "DELETE", "/api/v1/archive/"+strconv.FormatInt(lessonID, 10), "", http.StatusOK)
+	check(teacher, "DELETE", "/api/v1/trash/"+strconv.FormatInt(lessonID, 10), "", http.StatusOK)
+	check(teacher, "DELETE", "/api/v1/trash/"+strconv.FormatInt(lessonID, 10), "", http.StatusNotFound)
+	check(teacher, "POST", "/api/v1/archive/bulk", `{"ids":[`+strconv.FormatInt(lessonID, 10)+`]}`, http.StatusOK)
+	check(teacher, "POST", "/api/v1/archive/bulk", `{"before":"1900-01-01"}`, http.StatusOK)
+	check(teacher, "POST", "/api/v1/archive/bulk", `{}`, http.StatusBadRequest)
+
+	check(teacher, "POST", "/api/v1/tokens", `{"name":"ci","scope":"read"}`, http.StatusOK)
+	w = check(teacher, "GET", "/api/v1/tokens", "", http.StatusOK)
+	var tokens APITokenResponse
+	json.Unmarshal(w.Body.Bytes(), &tokens)
+	if len(tokens.Tokens) == 0 {
+		t.Fatal("Expected created token in list")
+	}
+	check(teacher, "DELETE", "/api/v1/tokens/"+strconv.FormatInt(tokens.Tokens[0].ID, 10), "", http.StatusOK)
+
+	check(teacher, "GET", "/api/v1/settings/language", "", http.StatusOK)
+	check(teacher, "PUT", "/api/v1/settings/language", `{"language":"ru"}`, http.StatusOK)
+	check(teacher, "PUT", "/api/v1/settings/language", `{"language":"xx"}`, http.StatusBadRequest)
+
+	check(anonymous, "GET", "/api/v1/lessons", "", http.StatusUnauthorized)
+	check(student, "GET", "/api/v1/lessons", "", http.StatusForbidden)
+	check(anonymous, "GET", "/healthz", "", http.StatusOK)
+	check(anonymous, "GET", "/api/v1/openapi.json", "", http.StatusOK)
+	check(anonymous, "GET", "/api/docs", "", http.StatusOK)
+	check(teacher, "POST", "/api/v1/auth/logout", "", http.StatusOK)
+}
+
+// TestLessonListPages проверяет фильтры, сортировку и страницы списков занятий
+func TestLessonListPages(t *testing.T) {
+	useTestDatabase(t)
+	db := database.Get()
+	result, err := db.Exec(`INSERT INTO user (Login, PassHash, FullName, Role) VALUES ('pager', '5f4dcc3b5aa765d61d8327deb882cf99', 'Сидоров Сидор', 'Teacher')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	teacherID, _ := result.LastInsertId()
+	lessons := []struct {
+		name, date, kind string
+		active           bool
+		group            int
+	}{
+		{"Math", "2024-09-01", "Lecture", true, 101},
+		{"Physics", "2024-09-02", "Lab", true, 102},
+		{"Math_2", "2024-09-03", "Practice", true, 101},
+		{"Mathematics", "2024-09-03", "Lecture", true, 0},
+		{"History", "2024-10-01", "Lecture", true, 0},
+		{"Old math", "2023-01-01", "Lecture", false, 0},
+	}
+	for _, lesson := range lessons {
+		result, err := db.Exec(`INSERT INTO lessons (NameLesson, Date, TypeLes, QrToken, IsActive, TeacherId) VALUES (?, ?, ?, ?, ?, ?)`,
+			lesson.name, lesson.date, lesson.kind, "pager-"+lesson.name, lesson.active, teacherID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lesson.group > 0 {
+			id, _ := result.LastInsertId()
+			db.Exec(`INSERT INTO lesson_groups (LessonId, GroupId) VALUES (?, ?)`, id, lesson.group)
+		}
+	}
+
+	client := &testClient{t: t, handler: NewHTTPHandler()}
+	if w := client.do("POST", "/api/v1/auth/login", `{"login":"pager","password":"password"}`); w.Code != http.StatusOK {
+		t.Fatalf("Login failed: %s", w.Body.String())
+	}
+	list := func(target string) TeacherInfoResponse {
+		t.Helper()
+		w := client.do("GET", target, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d: %s", target, w.Code, w.Body.String())
+		}
+		var response TeacherInfoResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response
+	}
+	names := func(response TeacherInfoResponse) string {
+		var names []string
+		for _, lesson := range response.Lessons {
+			names = append(names, lesson.NameLesson)
+		}
+		return strings.Join(names, ",")
+	}
+
+	testCases := []struct {
+		query     string
+		want      string
+		wantTotal int
+	}{
+		{"", "History,Mathematics,Math_2,Physics,Math", 5},
+		{"?sort=date", "Math,Physics,Math_2,Mathematics,History", 5},
+		{"?sort=name", "History,Math,Math_2,Mathematics,Physics", 5},
+		{"?sort=-created", "History,Mathematics,Math_2,Physics,Math", 5},
+		{"?from=2024-09-02&to=2024-09-03&sort=date", "Physics,Math_2,Mathematics", 3},
+		{"?name=math&sort=date", "Math,Math_2,Mathematics", 3},
+		{"?name=_&sort=date", "Math_2", 1},
+		{"?type=Lecture&sort=date", "Math,Mathematics,History", 3},
+		{"?group=101&sort=date", "Math,Math_2", 2},
+		{"?sort=date&limit=2&offset=2", "Math_2,Mathematics", 5},
+	}
+	for _, tc := range testCases {
+		response := list("/api/v1/lessons" + tc.query)
+		if names(response) != tc.want || response.Total != tc.wantTotal {
+			t.Errorf("%s: expected %s (total %d), got %s (total %d)", tc.query, tc.want, tc.wantTotal, names(response), response.Total)
+		}
+	}
+
+	// курсор проходит все страницы без пропусков и повторов при одинаковых датах
+	for _, sort := range []string{"date", "-date", "name", "-created"} {
+		var got []string
+		target := "/api/v1/lessons?limit=2&sort=" + sort
+		for pages := 0; pages < 5; pages++ {
+			response := list(target)
+			got = append(got, names(response))
+			if response.NextCursor == "" {
+				break
+			}
+			target = "/api/v1/lessons?limit=2&sort=" + sort + "&cursor=" + response.NextCursor
+		}
+		full := names(list("/api/v1/lessons?sort=" + sort))
+		if strings.Join(got, ",") != full || len(got) != 3 {
+			t.Errorf("sort=%s: pages %v do not match full list %s", sort, got, full)
+		}
+	}
+
+	var archive ArchiveInfoResponse
+	w := client.do("GET", "/api/v1/archive?name=math", "")
+	json.Unmarshal(w.Body.Bytes(), &archive)
+	if archive.Total != 1 || len(archive.Lessons) != 1 || archive.Lessons[0].NameLesson != "Old math" {
+		t.Errorf("Unexpected archive page: %s", w.Body.String())
+	}
+
+	for _, query := range []string{"?from=2024-13-01", "?from=2024-10-01&to=2024-09-01", "?sort=teacher", "?limit=0", "?limit=1000",
+		"?offset=-1", "?group=abc", "?cursor=bad", "?cursor=" + (lessonCursor{Sort: "name", ID: 1}).encode(),
+		"?offset=2&cursor=" + (lessonCursor{Sort: "-date", ID: 1}).encode()} {
+		w := client.do("GET", "/api/v1/lessons"+query, "")
+		var response ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if w.Code != http.StatusBadRequest || response.Code != codeValidation {
+			t.Errorf("%s: expected 400 %s, got %d %s", query, codeValidation, w.Code, response.Code)
+		}
+	}
+}
+
+// TestLessonUpdate проверяет изменение занятия, новый QR код, отзыв старого и историю
+func TestLessonUpdate(t *testing.T) {
+	useTestDatabase(t)
+	handler := NewHTTPHandler()
+	teacher := &testClient{t: t, handler: handler}
+	student := &testClient{t: t, handler: handler}
+	teacher.do("POST", "/api/v1/auth/login", `{"login":"teacher","password":"password"}`)
+	student.do("POST", "/api/v1/auth/login", `{"login":"student","password":"password"}`)
+
+	var created LessonCreateResponse
+	json.Unmarshal(teacher.do("POST", "/api/v1/lessons", `{"name":"Math","date":"2024-02-01","type":"Lecture"}`).Body.Bytes(), &created)
+	var lessonID int64
+	if err := database.Get().QueryRow(`SELECT id FROM lessons WHERE QrToken = ?`, created.QrToken).Scan(&lessonID); err != nil {
+		t.Fatal(err)
+	}
+	lesson := "/api/v1/lessons/" + strconv.FormatInt(lessonID, 10)
+	firstToken := created.QrToken
+
+	update := func(body string, wantStatus int) LessonUpdateResponse {
+		t.Helper()
+		w := teacher.do("PATCH", lesson, body)
+		if w.Code != wantStatus {
+			t.Fatalf("PATCH %s: expected status %d, got %d: %s", body, wantStatus, w.Code, w.Body.String())
+		}
+		var response LessonUpdateResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response
+	}
+	second := update(`{"date":"2024-02-02"}`, http.StatusOK)
+	if second.QrToken == "" || second.QrToken == firstToken || second.OldTokenRevoked {
+		t.Errorf("Expected new token without revocation, got %+v", second)
+	}
+	third := update(`{"name":"Algebra","invalidateOldToken":true}`, http.StatusOK)
+	if !third.OldTokenRevoked {
+		t.Errorf("Expected old token revoked, got %+v", third)
+	}
+
+	// новый код несёт новые данные; после отзыва не принимается ни один из прежних кодов
+	token, err := qrtoken.Parse(third.QrToken)
+	if err != nil || token.Name != "Algebra" || token.Date != "2024-02-02" || token.Type != "Lecture" || token.ID != lessonID || token.Generation != 2 {
+		t.Errorf("Unexpected token contents %+v, %v", token, err)
+	}
+	for name, old := range map[string]string{"first": firstToken, "second": second.QrToken} {
+		w := student.do("POST", "/api/v1/attendance?token="+url.QueryEscape(old), "")
+		var errorResponse ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &errorResponse)
+		if w.Code != http.StatusForbidden || errorResponse.Code != codeQRTokenInvalid {
+			t.Errorf("Revoked %s token: expected 403 %s, got %d %s", name, codeQRTokenInvalid, w.Code, w.Body.String())
+		}
+	}
+	if w := student.do("POST", "/api/v1/attendance?token="+url.QueryEscape(third.QrToken), ""); w.Code != http.StatusOK {
+		t.Errorf("Current token: expected 200, got %d %s", w.Code, w.Body.String())
+	}
+
+	var lessonResponse TeacherGetLessonResponse
+	json.Unmarshal(teacher.do("GET", lesson, "").Body.Bytes(), &lessonResponse)
+	if lessonResponse.NameLesson != "Algebra" || lessonResponse.Date != "2024-02-02" || lessonResponse.QrToken != third.QrToken {
+		t.Errorf("Lesson not updated: %+v", lessonResponse)
+	}
+
+	// текущий QR код видит только владелец: студент получает 403, другой преподаватель - 404
+	database.Get().Exec(`INSERT OR IGNORE INTO user (Login, PassHash, FullName, Role) VALUES ('otherteacher', '5f4dcc3b5aa765d61d8327deb882cf99', 'Другой Учитель', 'Teacher')`)
+	other := &testClient{t: t, handler: handler}
+	other.do("POST", "/api/v1/auth/login", `{"login":"otherteacher","password":"password"}`)
+	if w := student.do("GET", lesson, ""); w.Code != http.StatusForbidden || strings.Contains(w.Body.String(), third.QrToken) {
+		t.Errorf("Student: expected 403 without token, got %d %s", w.Code, w.Body.String())
+	}
+	if w := other.do("GET", lesson, ""); w.Code != http.StatusNotFound || strings.Contains(w.Body.String(), third.QrToken) {
+		t.Errorf("Other teacher: expected 404 without token, got %d %s", w.Code, w.Body.String())
+	}
+
+	var history LessonHistoryResponse
+	json.Unmarshal(teacher.do("GET", lesson+"/history", "").Body.Bytes(), &history)
+	if len(history.History) != 2 {
+		t.Fatalf("Expected 2 history entries, got %+v", history)
+	}
+	if latest := history.History[0]; latest.OldName != "Math" || latest.NewName != "Algebra" || !latest.TokenRevoked || latest.ChangedByName != "Иванов Иван" {
+		t.Errorf("Unexpected latest history entry %+v", latest)
+	}
+	if first := history.History[1]; first.OldDate != "2024-02-01" || first.NewDate != "2024-02-02" || first.TokenRevoked {
+		t.Errorf("Unexpected first history entry %+v", first)
+	}
+
+	update(`{}`, http.StatusBadRequest)
+	update(`{"type":""}`, http.StatusBadRequest)
+	update(`{"date":"<script>"}`, http.StatusBadRequest)
+	update(`{"date":"01.02.2024"}`, http.StatusBadRequest)
+	update(`{"date":"2024-02-30"}`, http.StatusBadRequest)
+	if w := student.do("PATCH", lesson, `{"name":"Hacked"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Student: expected 403, got %d", w.Code)
+	}
+	if w := teacher.do("PATCH", "/api/v1/lessons/999999", `{"name":"Other"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Unknown lesson: expected 404, got %d", w.Code)
+	}
+	teacher.do("POST", lesson+"/archive", "")
+	update(`{"name":"Archived"}`, http.StatusConflict)
+
+	// при окончательном удалении из корзины история удаляется вместе с занятием
+	teacher.do("DELETE", "/api/v1/archive/"+strconv.FormatInt(lessonID, 10), "")
+	teacher.do("DELETE", "/api/v1/trash/"+strconv.FormatInt(lessonID, 10), "")
+	var left int
+	database.Get().QueryRow(`SELECT COUNT(*) FROM lesson_history WHERE LessonId = ?`, lessonID).Scan(&left)
+	if left != 0 {
+		t.Errorf("Expected history deleted with lesson, %d rows left", left)
+	}
+}
+
+// TestArchiveRestoreAndTrash проверяет возврат из архива, корзину и удаление по сроку хранения
+func TestArchiveRestoreAndTrash(t *testing.T) {
+	useTestDatabase(t)
+	db := database.Get()
+	handler := NewHTTPHandler()
+	teacher := &testClient{t: t, handler: handler}
+	teacher.do("POST", "/api/v1/auth/login", `{"login":"teacher","password":"password"}`)
+
+	createLesson := func(name string) string {
+		t.Helper()
+		var created LessonCreateResponse
+		json.Unmarshal(teacher.do("POST", "/api/v1/lessons", `{"name":"`+name+`","date":"2024-03-01","type":"Lecture","groups":[101]}`).Body.Bytes(), &created)
+		var id string
+		if err := db.QueryRow(`SELECT id FROM lessons WHERE QrToken = ?`, created.QrToken).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	expect := func(method, target string, wantStatus int) {
+		t.Helper()
+		if w := teacher.do(method, target, ""); w.Code != wantStatus {
+			t.Errorf("%s %s: expected status %d, got %d: %s", method, target, wantStatus, w.Code, w.Body.String())
+		}
+	}
+	listed := func(target, id string) bool {
+		t.Helper()
+		var response TrashResponse
+		json.Unmarshal(teacher.do("GET", target+"?limit=200", "").Body.Bytes(), &response)
+		for _, lesson := range response.Lessons {
+			if strconv.Itoa(lesson.ID) == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	id := createLesson("Chemistry")
+	expect("POST", "/api/v1/lessons/"+id+"/unarchive", http.StatusNotFound)
+	expect("POST", "/api/v1/lessons/"+id+"/archive", http.StatusOK)
+	expect("POST", "/api/v1/lessons/"+id+"/unarchive", http.StatusOK)
+	if !listed("/api/v1/lessons", id) || listed("/api/v1/archive", id) {
+		t.Error("Unarchived lesson should be listed with active lessons only")
+	}
+
+	// удаление из архива - в корзину; отметки остаются до окончательного удаления
+	db.Exec(`INSERT INTO attendances (LessonId, StudentId, Status, ConfirmedDate, GroupId) VALUES (?, 2, 1, datetime('now'), 101)`, id)
+	expect("DELETE", "/api/v1/archive/"+id, http.StatusNotFound)
+	expect("POST", "/api/v1/lessons/"+id+"/archive", http.StatusOK)
+	expect("DELETE", "/api/v1/archive/"+id, http.StatusOK)
+	if listed("/api/v1/archive", id) || !listed("/api/v1/trash", id) {
+		t.Error("Deleted lesson should be listed in trash only")
+	}
+	expect("GET", "/api/v1/lessons/"+id, http.StatusNotFound)
+	expect("GET", "/api/v1/lessons/"+id+"/export", http.StatusNotFound)
+	expect("POST", "/api/v1/lessons/"+id+"/unarchive", http.StatusNotFound)
+
+	var trash TrashResponse
+	json.Unmarshal(teacher.do("GET", "/api/v1/trash", "").Body.Bytes(), &trash)
+	if trash.RetentionDays != trashRetentionDays || trash.Total == 0 || trash.Lessons[0].DeletedAt == nil {
+		t.Errorf("Unexpected trash response %+v", trash)
+	}
+
+	expect("POST", "/api/v1/trash/"+id+"/restore", http.StatusOK)
+	expect("POST", "/api/v1/trash/"+id+"/restore", http.StatusNotFound)
+	if !listed("/api/v1/archive", id) {
+		t.Error("Restored lesson should be back in archive")
+	}
+	var attendances int
+	db.QueryRow(`SELECT COUNT(*) FROM attendances WHERE LessonId = ?`, id).Scan(&attendances)
+	if attendances != 1 {
+		t.Errorf("Attendances should survive trash, got %d", attendances)
+	}
+
+	// по сроку удаляется только занятие, пролежавшее в корзине дольше retention_days
+	expect("DELETE", "/api/v1/archive/"+id, http.StatusOK)
+	fresh := createLesson("Biology")
+	expect("POST", "/api/v1/lessons/"+fresh+"/archive", http.StatusOK)
+	expect("DELETE", "/api/v1/archive/"+fresh, http.StatusOK)
+	db.Exec(`UPDATE lessons SET DeletedAt = datetime('now', ?) WHERE id = ?`, fmt.Sprintf("-%d days", trashRetentionDays+1), id)
+
+	purged, err := purgeExpiredTrash(db)
+	if err != nil || purged != 1 {
+		t.Fatalf("Expected 1 purged lesson, got %d, %v", purged, err)
+	}
+	var left int
+	db.QueryRow(`SELECT (SELECT COUNT(*) FROM lessons WHERE id = ?) + (SELECT COUNT(*) FROM attendances WHERE LessonId = ?) + (SELECT COUNT(*) FROM lesson_groups WHERE LessonId = ?)`,
+		id, id, id).Scan(&left)
+	if left != 0 {
+		t.Errorf("Expected lesson, attendances and groups purged, %d rows left", left)
+	}
+	if !listed("/api/v1/trash", fresh) {
+		t.Error("Recently deleted lesson should stay in trash")
+	}
+}
+
+// TestBulkAndAutoArchive проверяет массовый перенос в архив и автоархивацию устаревших занятий
+func TestBulkAndAutoArchive(t *testing.T) {
+	useTestDatabase(t)
+	db := database.Get()
+
+	addTeacher := func(login string) int64 {
+		t.Helper()
+		result, err := db.Exec(`INSERT INTO user (Login, PassHash, FullName, Role, Email, Language) VALUES (?, '5f4dcc3b5aa765d61d8327deb882cf99', 'Архивов Архип', 'Teacher', ?, 'ru')`,
+			login, login+"@example.org")
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := result.LastInsertId()
+		return id
+	}
+	addLesson := func(teacherID int64, name, date string, active bool, sessionStart any) string {
+		t.Helper()
+		result, err := db.Exec(`INSERT INTO lessons (NameLesson, Date, TypeLes, QrToken, IsActive, TeacherId, SessionStart) VALUES (?, ?, 'Lecture', ?, ?, ?, ?)`,
+			name, date, "archiver-"+name, active, teacherID, sessionStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := result.LastInsertId()
+		return strconv.FormatInt(id, 10)
+	}
+	isActive := func(id string) bool {
+		t.Helper()
+		var active bool
+		db.QueryRow(`SELECT IsActive FROM lessons WHERE id = ?`, id).Scan(&active)
+		return active
+	}
+
+	archiver := addTeacher("archiver")
+	other := addTeacher("archiver2")
+	client := &testClient{t: t, handler: NewHTTPHandler()}
+	client.do("POST", "/api/v1/auth/login", `{"login":"archiver","password":"password"}`)
+	bulk := func(body string, wantStatus int) BulkArchiveResponse {
+		t.Helper()
+		w := client.do("POST", "/api/v1/archive/bulk", body)
+		if w.Code != wantStatus {
+			t.Fatalf("%s: expected status %d, got %d: %s", body, wantStatus, w.Code, w.Body.String())
+		}
+		var response BulkArchiveResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response
+	}
+
+	tooMany := strings.Repeat("1,", maxBulkArchive) + "1"
+	for _, body := range []string{`{}`, `{"ids":[1],"before":"2000-01-01"}`, `{"ids":[0]}`, `{"before":"01.02.2000"}`, `{"ids":[` + tooMany + `]}`} {
+		bulk(body, http.StatusBadRequest)
+	}
+
+	// по id: чужие, архивные и несуществующие занятия пропускаются
+	first := addLesson(archiver, "First", "2000-01-01", true, nil)
+	foreign := addLesson(other, "Foreign", "2000-01-01", true, nil)
+	archived := addLesson(archiver, "Archived", "2000-01-01", false, nil)
+	response := bulk(`{"ids":[`+first+`,`+foreign+`,`+archived+`,999999,`+first+`]}`, http.StatusOK)
+	if response.Count != 1 || len(response.Archived) != 1 || strconv.Itoa(response.Archived[0].ID) != first {
+		t.Errorf("Expected only lesson %s archived, got %+v", first, response)
+	}
+	if fmt.Sprint(response.Skipped) != "["+foreign+" "+archived+" 999999]" {
+		t.Errorf("Unexpected skipped ids %v", response.Skipped)
+	}
+	if isActive(first) || !isActive(foreign) {
+		t.Error("Bulk archive by ids changed wrong lessons")
+	}
+
+	// по дате: только даты YYYY-MM-DD раньше before, открытый приём закрывается
+	second := addLesson(archiver, "Second", "2000-02-01", true, nil)
+	open := addLesson(archiver, "Open", "2000-02-02", true, time.Now().Add(-time.Hour).UTC().Format(time.DateTime))
+	later := addLesson(archiver, "Later", "2000-03-01", true, nil)
+	text := addLesson(archiver, "Text", "1 февраля", true, nil)
+	response = bulk(`{"before":"2000-02-15"}`, http.StatusOK)
+	if response.Count != 2 || len(response.Skipped) != 0 || isActive(second) || isActive(open) || !isActive(later) || !isActive(text) {
+		t.Errorf("Unexpected bulk archive by date %+v", response)
+	}
+	var stillOpen bool
+	db.QueryRow(`SELECT `+sessionOpenExpr+` FROM lessons WHERE id = ?`, open).Scan(&stillOpen)
+	if stillOpen {
+		t.Error("Bulk archive should close open session")
+	}
+
+	// автоархивация: устаревшие занятия без открытого приёма, отчёт по преподавателям
+	stale := addLesson(archiver, "Stale", "2000-04-01", true, nil)
+	openStale := addLesson(archiver, "Open stale", "2000-04-02", true, time.Now().Add(-time.Hour).UTC().Format(time.DateTime))
+	forgotten := addLesson(archiver, "Forgotten", "2000-04-03", true, time.Now().AddDate(0, 0, -40).UTC().Format(time.DateTime))
+	recent := addLesson(archiver, "Recent", time.Now().Format(time.DateOnly), true, nil)
+	reports, err := autoArchiveStale(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byTeacher := map[int]autoArchiveReport{}
+	for _, report := range reports {
+		byTeacher[report.TeacherID] = report
+	}
+	mine, theirs := byTeacher[int(archiver)], byTeacher[int(other)]
+	if len(mine.Lessons) != 3 || strconv.Itoa(mine.Lessons[0].ID) != later || strconv.Itoa(mine.Lessons[1].ID) != stale || strconv.Itoa(mine.Lessons[2].ID) != forgotten ||
+		mine.Login != "archiver" || mine.Email != "archiver@example.org" || mine.Language != "ru" {
+		t.Errorf("Unexpected report %+v", mine)
+	}
+	if len(theirs.Lessons) != 1 || strconv.Itoa(theirs.Lessons[0].ID) != foreign {
+		t.Errorf("Unexpected report for other teacher %+v", theirs)
+	}
+	if !isActive(openStale) || !isActive(recent) || !isActive(text) {
+		t.Error("Auto archive should skip recently opened sessions, recent and non-ISO dates")
+	}
+	reports, _ = autoArchiveStale(db)
+	for _, report := range reports {
+		if report.TeacherID == int(archiver) {
+			t.Errorf("Second auto archive run should find nothing for the teacher, got %+v", report)
+		}
+	}
+}
+
+// TestUpsertExternalUser проверяет, что внешний вход не меняет роль и группу чужих записей
+func TestUpsertExternalUser(t *testing.T) {
+	useTestDatabase(t)
+	db := database.Get()
+
+	var groupID int64
+	if err := db.QueryRow(`INSERT INTO groups (NumGroup) VALUES ('EXT-1') RETURNING id`).Scan(&groupID); err != nil {
+		t.Fatal(err)
+	}
+	_, err := db.Exec(`INSERT INTO user (Login, PassHash, FullName, Role, Email) VALUES ('localteacher', '5f4dcc3b5aa765d61d8327deb882cf99', 'Локальный Учитель', 'Teacher', 'local@example.org')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := func(login string) (role string, group sql.NullInt64, provider sql.NullString) {
+		t.Helper()
+		if err := db.QueryRow(`SELECT Role, GroupId, AuthProvider FROM user WHERE Login = ?`, login).Scan(&role, &group, &provider); err != nil {
+			t.Fatal(err)
+		}
+		return role, group, provider
+	}
+
+	// локальный преподаватель с той же почтой входит со своей ролью и не привязывается
+	local := authn.ExternalUser{Provider: "oidc", Subject: "sub-local", Login: "local@example.org", Email: "local@example.org", EmailVerified: true, Role: "Student", GroupName: "EXT-1"}
+	identity, err := authn.UpsertExternalUser(db, local, true, true)
+	if err != nil || identity.Login != "localteacher" || identity.Role != "Teacher" || identity.GroupID != 0 {
+		t.Fatalf("Unexpected identity %+v, %v", identity, err)
+	}
+	if role, group, provider := stored("localteacher"); role != "Teacher" || group.Valid || provider.Valid {
+		t.Errorf("Local teacher changed: %s, %v, %v", role, group, provider)
+	}
+	if _, err := authn.UpsertExternalUser(db, local, true, false); err != authn.ErrNoAccount {
+		t.Errorf("Expected ErrNoAccount without linking local accounts, got %v", err)
+	}
+	// неподтверждённая почта не привязывает
+	unverified := local
+	unverified.EmailVerified = false
+	if _, err := authn.UpsertExternalUser(db, unverified, true, true); err != authn.ErrNoAccount {
+		t.Errorf("Expected ErrNoAccount for unverified email, got %v", err)
+	}
+
+	// при привязке совпадение только по почте: логин, равный адресу, не привязывается и не занимается
+	db.Exec(`INSERT INTO user (Login, PassHash, FullName, Role, Email) VALUES ('login@example.org', '5f4dcc3b5aa765d61d8327deb882cf99', 'Логин Адресом', 'Teacher', 'other@example.org')`)
+	byLogin := authn.ExternalUser{Provider: "oidc", Subject: "sub-login", Login: "login@example.org", Email: "login@example.org", EmailVerified: true, Role: "Student"}
+	if _, err := authn.UpsertExternalUser(db, byLogin, true, true); err != authn.ErrNoAccount {
+		t.Errorf("Expected ErrNoAccount for login-only match, got %v", err)
+	}
+
+	// несколько записей с одной почтой - неоднозначность, вход отклоняется
+	db.Exec(`INSERT INTO user (Login, PassHash, FullName, Role, Email) VALUES ('dup1', '5f4dcc3b5aa765d61d8327deb882cf99', 'Первый', 'Teacher', 'dup@example.org')`)
+	db.Exec(`INSERT INTO user (Login, PassHash, FullName, Role, Email) VALUES ('dup2', '5f4dcc3b5aa765d61d8327deb882cf99', 'Второй', 'Student', 'DUP@example.org')`)
+	dup := authn.ExternalUser{Provider: "oidc", Subject: "sub-dup", Login: "dup@example.org", Email: "dup@example.org", EmailVerified: true, Role: "Student"}
+	if _, err := authn.UpsertExternalUser(db, dup, true, true); err != authn.ErrNoAccount {
+		t.Errorf("Expected ErrNoAccount for ambiguous email, got %v", err)
+	}
+
+	// группа студента остаётся, если провайдер её не прислал или её нет в базе
+	student := authn.ExternalUser{Provider: "oidc", Subject: "sub-student", Login: "ext@example.org", Email: "ext@example.org", EmailVerified: true, FullName: "Внешний Студент", Role: "Student", GroupName: "EXT-1"}
+	identity, err = authn.UpsertExternalUser(db, student, true, true)
+	if err != nil || identity.GroupID != groupID {
+		t.Fatalf("Expected provisioned student in group %d, got %+v, %v", groupID, identity, err)
+	}
+	for _, groupName := range []string{"", "MISSING"} {
+		student.GroupName = groupName
+		identity, err = authn.UpsertExternalUser(db, student, false, true)
+		if err != nil || identity.GroupID != groupID {
+			t.Errorf("Group %q: expected group %d kept, got %+v, %v", groupName, groupID, identity, err)
+		}
+	}
+	// роль своих записей провайдер меняет
+	student.Role = "Teacher"
+	if identity, err = authn.UpsertExternalUser(db, student, false, true); err != nil || identity.Role != "Teacher" {
+		t.Errorf("Expected role from provider for provisioned account, got %+v, %v", identity, err)
+	}
+
+	// запись без пароля, созданная провайдером до появления AuthProvider, привязывается
+	db.Exec(`INSERT INTO user (Login, PassHash, FullName, Role) VALUES ('legacy@example.org', '', 'Старый Внешний', 'Student')`)
+	legacy := authn.ExternalUser{Provider: "oidc", Subject: "sub-legacy", Login: "legacy@example.org", Email: "legacy@example.org", Role: "Student"}
+	if _, err := authn.UpsertExternalUser(db, legacy, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, provider := stored("legacy@example.org"); provider.String != "oidc" {
+		t.Errorf("Legacy external account should be linked, got provider %v", provider)
+	}
+}
+
+// fakeOIDC - провайдер без сети: state из ссылки входа, claims задаются тестом
+type fakeOIDC struct {
+	claims oidc.Claims
+}
+
+func (f *fakeOIDC) AuthCodeURL(_ context.Context, state, _, _ string) (string, error) {
+	return "https://idp.test/authorize?state=" + url.QueryEscape(state), nil
+}
+
+func (f *fakeOIDC) Exchange(context.Context, string, string, string) (*oidc.Claims, error) {
+	claims := f.claims
+	return &claims, nil
+}
+
+// TestOIDCCallbackSecondFactor проверяет, что вход через провайдера требует TOTP как вход по паролю
+func TestOIDCCallbackSecondFactor(t *testing.T) {
+	useTestDatabase(t)
+	handler := NewHTTPHandler()
+	provider := &fakeOIDC{}
+	savedConfig := oidcConfig
+	oidcProvider = provider
+	oidcConfig.JITProvisioning = true
+	oidcConfig.TeacherGroups = []string{"staff"}
+	oidcConfig.PostLoginRedirect = "/"
+	totpRequiredRoles = []string{"Teacher"}
+	defer func() {
+		oidcProvider, oidcConfig, totpRequiredRoles = nil, savedConfig, nil
+	}()
+
+	login := func(email string, groups []string) *httptest.ResponseRecorder {
+		t.Helper()
+		provider.claims = oidc.Claims{Subject: "sub-" + email, Email: email, EmailVerified: true, Name: "SSO " + email, Groups: groups}
+		client := &testClient{t: t, handler: handler}
+		w := client.do("GET", "/api/v1/auth/oidc/login", "")
+		location, err := url.Parse(w.Header().Get("Location"))
+		if w.Code != http.StatusFound || err != nil {
+			t.Fatalf("Login redirect failed: %d %s", w.Code, w.Body.String())
+		}
+		return client.do("GET", "/api/v1/auth/oidc/callback?code=c&state="+url.QueryEscape(location.Query().Get("state")), "")
+	}
+	hasSession := func(w *httptest.ResponseRecorder) bool {
+		for _, c := range w.Result().Cookies() {
+			if c.Name == "session" && c.Value != "" {
+				return true
+			}
+		}
+		return false
+	}
+	// callback открывает браузер: challenge уходит фронтенду редиректом, во фрагменте адреса
+	expectChallenge := func(w *httptest.ResponseRecorder, wantCode, purpose string) {
+		t.Helper()
+		location, err := url.Parse(w.Header().Get("Location"))
+		if w.Code != http.StatusFound || err != nil || hasSession(w) {
+			t.Fatalf("Expected redirect without session, got %d: %s", w.Code, w.Body.String())
+		}
+		fragment, _ := url.ParseQuery(location.Fragment)
+		if location.Path != "/" || location.RawQuery != "" || fragment.Get("code") != wantCode {
+			t.Errorf("Expected %s challenge in fragment, got %s", wantCode, location)
+		}
+		if _, err := parseLoginChallenge(fragment.Get("challenge"), purpose); err != nil {
+			t.Errorf("Expected valid %s challenge, got %v", purpose, err)
+		}
+		if err := apidoc.ValidateResponse("GET", "/api/v1/auth/oidc/callback", w.Code, w.Header().Get("Content-Type"), w.Body.Bytes()); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if w := login("sso-student@example.org", nil); w.Code != http.StatusFound || !hasSession(w) {
+		t.Errorf("Expected session for student without TOTP, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// роль из totp.required_roles без подключённого TOTP - обязательная регистрация
+	expectChallenge(login("sso-teacher@example.org", []string{"staff"}), codeTOTPEnrollmentRequired, challengeEnroll)
+
+	// подключённый TOTP спрашивается при любой роли
+	var studentID int64
+	database.Get().QueryRow(`SELECT id FROM user WHERE Login = 'sso-student@example.org'`).Scan(&studentID)
+	database.Get().Exec(`INSERT INTO user_totp (UserId, Secret, Enabled, CreatedAt) VALUES (?, 'JBSWY3DPEHPK3PXP', 1, 0)`, studentID)
+	expectChallenge(login("sso-student@example.org", nil), codeTOTPRequired, challengeTOTP)
+}
+
+// TestTOTPLoginGate проверяет второй шаг входа: регистрацию, код из приложения и коды восстановления
+func TestTOTPLoginGate(t *testing.T) {
+	useTestDatabase(t)
+	handler := NewHTTPHandler()
+	relaxAuthLimits(t)
+	totpRequiredRoles = []string{"Teacher"}
+	defer func() { totpRequiredRoles = nil }()
+
+	db := database.Get()
+	_, err := db.Exec(`
+		INSERT OR IGNORE INTO user (Login, PassHash, FullName, Role, GroupId)
+		VALUES ('totpteacher', '5f4dcc3b5aa765d61d8327deb882cf99', 'Сидоров Сидор', 'Teacher', NULL)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &testClient{t: t, handler: handler}
+	decode := func(w *httptest.ResponseRecorder, v any) {
+		t.Helper()
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("Invalid JSON %q: %v", w.Body.String(), err)
+		}
+	}
+
+	// роль из totp.required_roles без TOTP - сессии нет, только challenge регистрации
+	w := client.do("POST", "/api/v1/auth/login", `{"login":"totpteacher","password":"password"}`)
+	var auth AuthResponse
+	decode(w, &auth)
+	if w.Code != http.StatusUnauthorized || auth.Code != codeTOTPEnrollmentRequired || auth.Challenge == "" {
+		t.Fatalf("Expected enrollment challenge, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := client.do("GET", "/api/v1/lessons", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected no session before second factor, got %d", w.Code)
+	}
+
+	// challenge регистрации не подходит для второго шага входа
+	if w := client.do("POST", "/api/v1/auth/totp", `{"challenge":"`+auth.Challenge+`","code":"000000"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for enrollment challenge on /auth/totp, got %d", w.Code)
+	}
+
+	w = client.do("POST", "/api/v1/totp/enroll", `{"challenge":"`+auth.Challenge+`"}`)
+	var enroll TOTPResponse
+	decode(w, &enroll)
+	if w.Code != http.StatusOK || enroll.Secret == "" || !strings.HasPrefix(enroll.QRCode, "data:image/png;base64,") {
+		t.Fatalf("Expected secret and QR image, got %d: %.200s", w.Code, w.Body.String())
+	}
+	if err := apidoc.ValidateResponse("POST", "/api/v1/totp/enroll", w.Code, w.Header().Get("Content-Type"), w.Body.Bytes()); err != nil {
+		t.Error(err)
+	}
+
+	code, _ := totp.CodeAt(enroll.Secret, totp.Step(time.Now()))
+	w = client.do("POST", "/api/v1/totp/confirm", `{"challenge":"`+auth.Challenge+`","code":"`+code+`"}`)
+	var confirmed AuthResponse
+	decode(w, &confirmed)
+	if w.Code != http.StatusOK || len(confirmed.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("Expected session and recovery codes after confirm, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := client.do("GET", "/api/v1/lessons", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected session after confirm, got %d", w.Code)
+	}
+
+	// следующий вход спрашивает код из приложения
+	client = &testClient{t: t, handler: handler}
+	login := func() string {
+		t.Helper()
+		w := client.do("POST", "/api/v1/auth/login", `{"login":"totpteacher","password":"password"}`)
+		var auth AuthResponse
+		decode(w, &auth)
+		if w.Code != http.StatusUnauthorized || auth.Code != codeTOTPRequired || auth.Challenge == "" {
+			t.Fatalf("Expected TOTP challenge, got %d: %s", w.Code, w.Body.String())
+		}
+		return auth.Challenge
+	}
+	challenge := login()
+
+	// код уже использован при подтверждении
+	w = client.do("POST", "/api/v1/auth/totp", `{"challenge":"`+challenge+`","code":"`+code+`"}`)
+	var failed ErrorResponse
+	decode(w, &failed)
+	if w.Code != http.StatusUnauthorized || failed.Code != codeTOTPInvalid {
+		t.Errorf("Expected replayed code to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// код восстановления действует один раз, регистр и дефисы не важны
+	recovery := strings.ToUpper(strings.ReplaceAll(confirmed.RecoveryCodes[0], "-", ""))
+	w = client.do("POST", "/api/v1/auth/totp", `{"challenge":"`+challenge+`","recoveryCode":"`+recovery+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected login with recovery code, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := apidoc.ValidateResponse("POST", "/api/v1/auth/totp", w.Code, w.Header().Get("Content-Type"), w.Body.Bytes()); err != nil {
+		t.Error(err)
+	}
+	if w := client.do("GET", "/api/v1/lessons", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected session after recovery code, got %d", w.Code)
+	}
+
+	client = &testClient{t: t, handler: handler}
+	challenge = login()
+	if w := client.do("POST", "/api/v1/auth/totp", `{"challenge":"`+challenge+`","recoveryCode":"`+recovery+`"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected used recovery code to be rejected, got %d", w.Code)
+	}
+	w = client.do("POST", "/api/v1/auth/totp", `{"challenge":"`+challenge+`","recoveryCode":"`+confirmed.RecoveryCodes[1]+`"}`)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected another recovery code to work, got %d: %s", w.Code, w.Body.String())
+	}
+	db.Exec(`DELETE FROM login_failures WHERE Login = 'totpteacher'`)
+}
+
+// TestLessonLiveFeed проверяет SSE ленту: снимок при подключении и событие отметки студента
+func TestLessonLiveFeed(t *testing.T) {
+	useTestDatabase(t)
+	handler := NewHTTPHandler()
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	teacher := &testClient{t: t, handler: handler}
+	student := &testClient{t: t, handler: handler}
+	teacher.do("POST", "/api/v1/auth/login", `{"login":"teacher","password":"password"}`)
+	student.do("POST", "/api/v1/auth/login", `{"login":"student","password":"password"}`)
+
+	var created LessonCreateResponse
+	json.Unmarshal(teacher.do("POST", "/api/v1/lessons", `{"name":"Live","date":"2024-03-01","type":"Lecture"}`).Body.Bytes(), &created)
+	var lessonID int64
+	if err := database.Get().QueryRow(`SELECT id FROM lessons WHERE QrToken = ?`, created.QrToken).Scan(&lessonID); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/api/v1/lessons/"+strconv.FormatInt(lessonID, 10)+"/live", nil)
+	for _, cookie := range teacher.cookies {
+		req.AddCookie(cookie)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	// следующее событие потока: имя и данные, keep-alive комментарии пропускаются
+	readEvent := func() (string, []byte) {
+		t.Helper()
+		var name string
+		var data []byte
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Reading event stream: %v", err)
+			}
+			line = strings.TrimRight(line, "\n")
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = []byte(strings.TrimPrefix(line, "data: "))
+			case line == "" && name != "":
+				return name, data
+			}
+		}
+	}
+
+	name, data := readEvent()
+	var snapshot LiveSnapshotEvent
+	if err := json.Unmarshal(data, &snapshot); name != "snapshot" || err != nil || snapshot.LessonID != lessonID || !snapshot.IsOpen || snapshot.Marked != 0 {
+		t.Fatalf("Expected open snapshot without marks, got %s %s", name, data)
+	}
+
+	if w := student.do("POST", "/api/v1/attendance?token="+url.QueryEscape(created.QrToken), ""); w.Code != http.StatusOK {
+		t.Fatalf("Mark failed: %d %s", w.Code, w.Body.String())
+	}
+	name, data = readEvent()
+	var mark LiveMarkEvent
+	if err := json.Unmarshal(data, &mark); name != "mark" || err != nil || mark.LessonID != lessonID || mark.FullName != "Петров Петр" || mark.Marked != 1 {
+		t.Errorf("Expected mark event for the student, got %s %s", name, data)
+	}
+}
+
+// TestSessionWithoutProfileFields проверяет, что cookie без имени и группы не роняет обработчики
+func TestSessionWithoutProfileFields(t *testing.T) {
+	useTestDatabase(t)
+	handler := NewHTTPHandler()
+
+	session := func(data map[string]interface{}) *http.Cookie {
+		t.Helper()
+		value, err := cookie.EncryptCookie(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &http.Cookie{Name: "session", Value: value}
+	}
+	teacher := session(map[string]interface{}{"user_id": 1, "login": "teacher", "role": "Teacher"})
+	student := session(map[string]interface{}{"user_id": 2, "login": "student", "role": "Student"})
+
+	var created LessonCreateResponse
+	client := &testClient{t: t, handler: handler, cookies: []*http.Cookie{teacher}}
+	if w := client.do("POST", "/api/v1/lessons", `{"name":"Math","date":"2024-01-01","type":"Lecture"}`); w.Code != http.StatusOK {
+		t.Fatalf("Create: expected 200, got %d: %s", w.Code, w.Body.String())
+	} else {
+		json.Unmarshal(w.Body.Bytes(), &created)
+	}
+	var lessonID int64
+	database.Get().QueryRow(`SELECT id FROM lessons WHERE QrToken = ?`, created.QrToken).Scan(&lessonID)
+
+	for _, c := range []struct {
+		session *http.Cookie
+		target  string
+	}{
+		{teacher, "/api/v1/lessons"},
+		{teacher, "/api/v1/archive"},
+		{teacher, "/api/v1/trash"},
+		{teacher, "/api/v1/lessons/" + strconv.FormatInt(lessonID, 10) + "/export"},
+		{student, "/api/v1/student"},
+	} {
+		client := &testClient{t: t, handler: handler, cookies: []*http.Cookie{c.session}}
+		if w := client.do("GET", c.target, ""); w.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d: %s", c.target, w.Code, w.Body.String())
+		}
+	}
+}
+
+// TestLessonSession проверяет открытие и закрытие приёма отметок, автозакрытие и отказ в отметке
+func TestLessonSession(t *testing.T) {
+	useTestDatabase(t)
+	handler := NewHTTPHandler()
+	relaxAuthLimits(t)
+	teacher := &testClient{t: t, handler: handler}
+	student := &testClient{t: t, handler: handler}
+	teacher.do("POST", "/api/v1/auth/login", `{"login":"teacher","password":"password"}`)
+	student.do("POST", "/api/v1/auth/login", `{"login":"student","password":"password"}`)
+	db := database.Get()
+
+	create := func(body string) (string, string) {
+		t.Helper()
+		var created LessonCreateResponse
+		json.Unmarshal(teacher.do("POST", "/api/v1/lessons", body).Body.Bytes(), &created)
+		var lessonID int64
+		if err := db.QueryRow(`SELECT id FROM lessons WHERE QrToken = ?`, created.QrToken).Scan(&lessonID); err != nil {
+			t.Fatal(err)
+		}
+		return "/api/v1/lessons/" + strconv.FormatInt(lessonID, 10), created.QrToken
+	}
+	session := func(client *testClient, target string, wantStatus int) LessonSessionResponse {
+		t.Helper()
+		w := client.do("POST", target, "")
+		if w.Code != wantStatus {
+			t.Fatalf("POST %s: expected status %d, got %d: %s", target, wantStatus, w.Code, w.Body.String())
+		}
+		if err := apidoc.ValidateResponse("POST", "/api/v1/lessons/{id}/open", w.Code, w.Header().Get("Content-Type"), w.Body.Bytes()); err != nil {
+			t.Error(err)
+		}
+		var response LessonSessionResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response
+	}
+	mark := func(qrToken string, wantStatus int, wantCode string) {
+		t.Helper()
+		w := student.do("POST", "/api/v1/attendance?token="+url.QueryEscape(qrToken), "")
+		var response ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if w.Code != wantStatus || response.Code != wantCode {
+			t.Errorf("Mark: expected %d %q, got %d: %s", wantStatus, wantCode, w.Code, w.Body.String())
+		}
+	}
+
+	// закрытое занятие не принимает отметки, повторное открытие начинает новое окно без срока
+	lesson, qrToken := create(`{"name":"Session","date":"2024-04-01","type":"Lecture"}`)
+	if closed := session(teacher, lesson+"/close", http.StatusOK); closed.IsOpen || closed.SessionEnd == nil {
+		t.Errorf("Expected closed session with end time, got %+v", closed)
+	}
+	session(teacher, lesson+"/close", http.StatusNotFound)
+	mark(qrToken, http.StatusForbidden, codeSessionClosed)
+	if opened := session(teacher, lesson+"/open", http.StatusOK); !opened.IsOpen || opened.SessionStart == nil || opened.SessionEnd != nil {
+		t.Errorf("Expected open session without end, got %+v", opened)
+	}
+	mark(qrToken, http.StatusOK, "")
+
+	// открывает и закрывает только преподаватель
+	session(student, lesson+"/close", http.StatusForbidden)
+	session(teacher, lesson+"/open?autoCloseMinutes=-1", http.StatusBadRequest)
+	session(teacher, lesson+"/open?autoCloseMinutes="+strconv.Itoa(maxAutoCloseMinutes+1), http.StatusBadRequest)
+
+	// автозакрытие: срок задан при открытии, после него отметки не принимаются
+	lesson, qrToken = create(`{"name":"Auto close","date":"2024-04-02","type":"Lecture"}`)
+	opened := session(teacher, lesson+"/open?autoCloseMinutes=5", http.StatusOK)
+	if !opened.IsOpen || opened.SessionEnd == nil || opened.SessionEnd.Sub(*opened.SessionStart) != 5*time.Minute {
+		t.Errorf("Expected session closing in 5 minutes, got %+v", opened)
+	}
+	id := strings.TrimPrefix(lesson, "/api/v1/lessons/")
+	db.Exec(`UPDATE lessons SET SessionStart = datetime('now', '-10 minutes'), SessionEnd = datetime('now', '-5 minutes') WHERE id = ?`, id)
+	mark(qrToken, http.StatusForbidden, codeSessionClosed)
+	session(teacher, lesson+"/close", http.StatusNotFound)
+
+	// архивное занятие не открывается и не принимает отметки
+	lesson, qrToken = create(`{"name":"Archived","date":"2024-04-03","type":"Lecture"}`)
+	if w := teacher.do("POST", lesson+"/archive", ""); w.Code != http.StatusOK {
+		t.Fatalf("Archive failed: %d %s", w.Code, w.Body.String())
+	}
+	mark(qrToken, http.StatusConflict, codeLessonArchived)
+	session(teacher, lesson+"/open", http.StatusNotFound)
+}
+
+// TestLoginRateLimitAndLockout проверяет 429 с Retry-After, блокировку после неудачных входов и /admin/unlock
+func TestLoginRateLimitAndLockout(t *testing.T) {
+	useTestDatabase(t)
+	handler := NewHTTPHandler()
+	relaxAuthLimits(t)
+	savedThreshold, savedBase := lockoutThreshold, lockoutBase
+	defer func() { lockoutThreshold, lockoutBase = savedThreshold, savedBase }()
+
+	db := database.Get()
+	_, err := db.Exec(`
+		INSERT OR IGNORE INTO user (Login, PassHash, FullName, Role, GroupId)
+		VALUES
+		('lockme', '5f4dcc3b5aa765d61d8327deb882cf99', 'Кузнецов Олег', 'Student', 101),
+		('admin', '5f4dcc3b5aa765d61d8327deb882cf99', 'Администратор', 'Admin', NULL)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &testClient{t: t, handler: handler}
+	login := func(login, password string, wantStatus int, wantCode string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := client.do("POST", "/api/v1/auth/login", `{"login":"`+login+`","password":"`+password+`"}`)
+		var response ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if w.Code != wantStatus || (wantCode != "" && response.Code != wantCode) {
+			t.Fatalf("Login %s: expected %d %s, got %d: %s", login, wantStatus, wantCode, w.Code, w.Body.String())
+		}
+		return w
+	}
+	retryAfter := func(w *httptest.ResponseRecorder) int {
+		t.Helper()
+		seconds, err := strconv.Atoi(w.Header().Get("Retry-After"))
+		if err != nil || seconds < 1 {
+			t.Errorf("Expected Retry-After in seconds, got %q", w.Header().Get("Retry-After"))
+		}
+		return seconds
+	}
+
+	// 60 в минуту подряд не больше 2: третий запрос по логину получает 429
+	authLoginLimiter = ratelimit.New(ratelimit.NewMemoryStore(), "auth:login:", 60, 2)
+	lockoutThreshold = 0
+	login("ratelimited", "wrong", http.StatusUnauthorized, "")
+	login("ratelimited", "wrong", http.StatusUnauthorized, "")
+	w := login("ratelimited", "wrong", http.StatusTooManyRequests, codeRateLimited)
+	if seconds := retryAfter(w); seconds > 1 {
+		t.Errorf("Expected Retry-After 1s for 60 per minute, got %d", seconds)
+	}
+	// другой логин не затронут
+	login("lockme", "password", http.StatusOK, "")
+
+	// после 3 неудач логин блокируется на lockoutBase, даже верный пароль не проходит
+	authLoginLimiter = ratelimit.New(ratelimit.NewMemoryStore(), "auth:login:", 1000, 1000)
+	lockoutThreshold, lockoutBase = 3, time.Minute
+	for i := 0; i < 3; i++ {
+		login("lockme", "wrong", http.StatusUnauthorized, "")
+	}
+	w = login("lockme", "password", http.StatusTooManyRequests, codeAccountLocked)
+	if seconds := retryAfter(w); seconds < 55 || seconds > 60 {
+		t.Errorf("Expected Retry-After about a minute, got %d", seconds)
+	}
+	if err := apidoc.ValidateResponse("POST", "/api/v1/auth/login", w.Code, w.Header().Get("Content-Type"), w.Body.Bytes()); err != nil {
+		t.Error(err)
+	}
+
+	// снять блокировку может только администратор
+	student := &testClient{t: t, handler: handler}
+	student.do("POST", "/api/v1/auth/login", `{"login":"student","password":"password"}`)
+	if w := student.do("POST", "/api/v1/admin/unlock", `{"login":"lockme"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for student unlock, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := (&testClient{t: t, handler: handler}).do("POST", "/api/v1/admin/unlock", `{"login":"lockme"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for anonymous unlock, got %d", w.Code)
+	}
+	login("lockme", "password", http.StatusTooManyRequests, codeAccountLocked)
+
+	admin := &testClient{t: t, handler: handler}
+	admin.do("POST", "/api/v1/auth/login", `{"login":"admin","password":"password"}`)
+	w = admin.do("POST", "/api/v1/admin/unlock", `{"login":"lockme"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected admin unlock, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := apidoc.ValidateResponse("POST", "/api/v1/admin/unlock", w.Code, w.Header().Get("Content-Type"), w.Body.Bytes()); err != nil {
+		t.Error(err)
+	}
+	login("lockme", "password", http.StatusOK, "")
+
+	// недоступный каталог - не неудачная попытка: сбой не блокирует тех, кто повторяет вход
+	savedAuthenticator := authenticator
+	defer func() { authenticator = savedAuthenticator }()
+	authenticator = authn.Chain{authn.SQLite{}, unavailableAuthenticator{}}
+	for i := 0; i < lockoutThreshold+2; i++ {
+		login("lockme", "wrong", http.StatusServiceUnavailable, codeAuthUnavailable)
+	}
+	login("lockme", "password", http.StatusOK, "")
+}
+
+// unavailableAuthenticator - источник, до которого нельзя достучаться (каталог недоступен)
+type unavailableAuthenticator struct{}
+
+func (unavailableAuthenticator) Authenticate(context.Context, string, string) (*authn.Identity, error) {
+	return nil, errors.New("ldap: connection refused")
+}
+
+// TestLockoutDuration проверяет удвоение срока блокировки и потолок без переполнения
+func TestLockoutDuration(t *testing.T) {
+	savedThreshold, savedBase, savedMax := lockoutThreshold, lockoutBase, lockoutMax
+	defer func() { lockoutThreshold, lockoutBase, lockoutMax = savedThreshold, savedBase, savedMax }()
+	lockoutThreshold, lockoutBase, lockoutMax = 5, time.Minute, time.Hour
+
+	for failures, want := range map[int]time.Duration{5: time.Minute, 6: 2 * time.Minute, 10: 32 * time.Minute, 11: time.Hour} {
+		if got := lockoutDuration(failures); got != want {
+			t.Errorf("%d failures: expected %v, got %v", failures, want, got)
+		}
+	}
+	// сдвиг на десятки шагов переполнил бы Duration и дал короткую блокировку
+	for failures := 11; failures < 200; failures++ {
+		if got := lockoutDuration(failures); got != time.Hour {
+			t.Fatalf("%d failures: expected %v, got %v", failures, time.Hour, got)
+		}
+	}
+}
+
+// TestPasswordChangeAndReset проверяет смену пароля, сброс по ссылке из письма и длину пароля при входе
+func TestPasswordChangeAndReset(t *testing.T) {
+	useTestDatabase(t)
+	handler := NewHTTPHandler()
+	db := database.Get()
+	_, err := db.Exec(`
+		INSERT OR IGNORE INTO user (Login, PassHash, FullName, Role, GroupId, Email)
+		VALUES ('resetme', '5f4dcc3b5aa765d61d8327deb882cf99', 'Смирнова Анна', 'Student', 101, 'resetme@example.org')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relaxAuthLimits(t)
+
+	client := &testClient{t: t, handler: handler}
+	expect := func(w *httptest.ResponseRecorder, wantStatus int, wantCode string) {
+		t.Helper()
+		var response ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if w.Code != wantStatus || response.Code != wantCode {
+			t.Errorf("Expected %d %q, got %d: %s", wantStatus, wantCode, w.Code, w.Body.String())
+		}
+	}
+	login := func(password string, wantStatus int) {
+		t.Helper()
+		w := (&testClient{t: t, handler: handler}).do("POST", "/api/v1/auth/login", `{"login":"resetme","password":"`+password+`"}`)
+		if w.Code != wantStatus {
+			t.Errorf("Login with %.20q: expected %d, got %d: %s", password, wantStatus, w.Code, w.Body.String())
+		}
+	}
+
+	// смена пароля: нужен верный старый пароль и новый по политике
+	client.do("POST", "/api/v1/auth/login", `{"login":"resetme","password":"password"}`)
+	expect(client.do("POST", "/api/v1/password/change", `{"oldPassword":"wrong","newPassword":"Secret123"}`), http.StatusForbidden, codeOldPasswordIncorrect)
+	expect(client.do("POST", "/api/v1/password/change", `{"oldPassword":"password","newPassword":"short1"}`), http.StatusBadRequest, codePasswordPolicy)
+	expect(client.do("POST", "/api/v1/password/change", `{"oldPassword":"password","newPassword":"resetme123"}`), http.StatusBadRequest, codePasswordPolicy)
+	w := client.do("POST", "/api/v1/password/change", `{"oldPassword":"password","newPassword":"Secret123"}`)
+	expect(w, http.StatusOK, "")
+	if err := apidoc.ValidateResponse("POST", "/api/v1/password/change", w.Code, w.Header().Get("Content-Type"), w.Body.Bytes()); err != nil {
+		t.Error(err)
+	}
+	login("password", http.StatusUnauthorized)
+	login("Secret123", http.StatusOK)
+
+	// длина пароля при входе считается в символах: 128 кириллических букв (256 байт) проверяются как обычный пароль
+	login(strings.Repeat("я", password.DefaultPolicy.MaxLength), http.StatusUnauthorized)
+	login(strings.Repeat("я", password.DefaultPolicy.MaxLength+1), http.StatusBadRequest)
+	resetLoginFailures(db, "resetme")
+
+	// ссылка сброса приходит письмом, ответ не зависит от существования логина
+	mailPath := filepath.Join(testDatabaseDir, "mail.log")
+	resetToken := func() string {
+		t.Helper()
+		pattern := regexp.MustCompile(`token=([A-Za-z0-9_-]+)`)
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			mail, _ := os.ReadFile(mailPath)
+			if matches := pattern.FindAllSubmatch(mail, -1); len(matches) > 0 && bytes.Contains(mail, []byte("resetme@example.org")) {
+				return string(matches[len(matches)-1][1])
+			}
+		}
+		t.Fatal("Reset email was not sent")
+		return ""
+	}
+	anonymous := &testClient{t: t, handler: handler}
+	expect(anonymous.do("POST", "/api/v1/password/reset/request", `{"login":"nobody"}`), http.StatusOK, "")
+	expect(anonymous.do("POST", "/api/v1/password/reset/request", `{"login":"resetme"}`), http.StatusOK, "")
+	token := resetToken()
+
+	expect(anonymous.do("POST", "/api/v1/password/reset/confirm", `{"token":"`+token+`","newPassword":"12345678"}`), http.StatusBadRequest, codePasswordPolicy)
+	expect(anonymous.do("POST", "/api/v1/password/reset/confirm", `{"token":"`+token+`","newPassword":"Another456"}`), http.StatusOK, "")
+	login("Another456", http.StatusOK)
+
+	// ссылка одноразовая
+	expect(anonymous.do("POST", "/api/v1/password/reset/confirm", `{"token":"`+token+`","newPassword":"Third789x"}`), http.StatusBadRequest, codeResetTokenInvalid)
+
+	// просроченная ссылка не принимается
+	var userID int64
+	db.QueryRow(`SELECT id FROM user WHERE Login = 'resetme'`).Scan(&userID)
+	expired := "expired-reset-token"
+	db.Exec(`INSERT INTO password_resets (TokenHash, UserId, CreatedAt, ExpiresAt) VALUES (?, ?, ?, ?)`,
+		cipher.SHA256(expired), userID, time.Now().Add(-2*time.Hour).Unix(), time.Now().Add(-time.Hour).Unix())
+	expect(anonymous.do("POST", "/api/v1/password/reset/confirm", `{"token":"`+expired+`","newPassword":"Third789x"}`), http.StatusBadRequest, codeResetTokenInvalid)
+	login("Another456", http.StatusOK)
+}
+
+// TestAPITokens проверяет выпуск, область действия, срок, список и отзыв API токенов
+func TestAPITokens(t *testing.T) {
+	useTestDatabase(t)
+	handler := NewHTTPHandler()
+	relaxAuthLimits(t)
+	teacher := &testClient{t: t, handler: handler}
+	teacher.do("POST", "/api/v1/auth/login", `{"login":"teacher","password":"password"}`)
+	db := database.Get()
+
+	create := func(body string) string {
+		t.Helper()
+		w := teacher.do("POST", "/api/v1/tokens", body)
+		var response APITokenResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if w.Code != http.StatusOK || !strings.HasPrefix(response.Token, apiTokenPrefix) {
+			t.Fatalf("Token create %s: got %d: %s", body, w.Code, w.Body.String())
+		}
+		return response.Token
+	}
+	// запрос только с Bearer токеном, без cookie
+	bearer := func(method, target, token, body string, wantStatus int, wantCode string) {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var response ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if w.Code != wantStatus || (wantCode != "" && response.Code != wantCode) {
+			t.Errorf("%s %s: expected %d %s, got %d: %s", method, target, wantStatus, wantCode, w.Code, w.Body.String())
+		}
+	}
+
+	for _, body := range []string{`{"name":"","scope":"read"}`, `{"name":"ci","scope":"admin"}`, `{"name":"ci","scope":"read","expiresInDays":400}`} {
+		if w := teacher.do("POST", "/api/v1/tokens", body); w.Code != http.StatusBadRequest {
+			t.Errorf("Token create %s: expected 400, got %d", body, w.Code)
+		}
+	}
+	readToken := create(`{"name":"dashboard","scope":"read"}`)
+	exportToken := create(`{"name":"reports","scope":"export","expiresInDays":7}`)
+	manageToken := create(`{"name":"sync","scope":"manage"}`)
+
+	var created LessonCreateResponse
+	json.Unmarshal(teacher.do("POST", "/api/v1/lessons", `{"name":"Tokens","date":"2024-05-01","type":"Lecture"}`).Body.Bytes(), &created)
+	var lessonID int64
+	if err := db.QueryRow(`SELECT id FROM lessons WHERE QrToken = ?`, created.QrToken).Scan(&lessonID); err != nil {
+		t.Fatal(err)
+	}
+	export := "/api/v1/lessons/" + strconv.FormatInt(lessonID, 10) + "/export"
+	lesson := `{"name":"By token","date":"2024-05-02","type":"Lecture"}`
+
+	// read: только чтение
+	bearer("GET", "/api/v1/lessons", readToken, "", http.StatusOK, "")
+	bearer("POST", "/api/v1/lessons", readToken, lesson, http.StatusForbidden, codeTokenScope)
+	bearer("GET", export, readToken, "", http.StatusForbidden, codeTokenScope)
+	// export: чтение и выгрузка, но не изменения
+	bearer("GET", export, exportToken, "", http.StatusOK, "")
+	bearer("POST", "/api/v1/lessons", exportToken, lesson, http.StatusForbidden, codeTokenScope)
+	// manage: всё
+	bearer("POST", "/api/v1/lessons", manageToken, lesson, http.StatusOK, "")
+	bearer("GET", export, manageToken, "", http.StatusOK, "")
+	// управление токенами только из браузерной сессии
+	bearer("GET", "/api/v1/tokens", manageToken, "", http.StatusForbidden, codeSessionRequired)
+	bearer("GET", "/api/v1/lessons", "qrt_unknown", "", http.StatusUnauthorized, codeTokenExpired)
+
+	// список без самих токенов, с временем последнего использования
+	w := teacher.do("GET", "/api/v1/tokens", "")
+	var list APITokenResponse
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if err := apidoc.ValidateResponse("GET", "/api/v1/tokens", w.Code, w.Header().Get("Content-Type"), w.Body.Bytes()); err != nil {
+		t.Error(err)
+	}
+	ids := map[string]int64{}
+	for _, token := range list.Tokens {
+		ids[token.Name] = token.ID
+		if token.LastUsedAt == nil {
+			t.Errorf("Expected last use time for token %q", token.Name)
+		}
+		if token.Name == "reports" && token.ExpiresAt.Sub(token.CreatedAt) != 7*24*time.Hour {
+			t.Errorf("Expected 7 days lifetime, got %v", token.ExpiresAt.Sub(token.CreatedAt))
+		}
+	}
+	if ids["dashboard"] == 0 || ids["reports"] == 0 || ids["sync"] == 0 || strings.Contains(w.Body.String(), apiTokenPrefix) {
+		t.Fatalf("Expected three tokens without secrets, got %s", w.Body.String())
+	}
+
+	// отзыв: токен сразу перестаёт работать, повторный отзыв и чужой токен - 404
+	revoke := "/api/v1/tokens/" + strconv.FormatInt(ids["dashboard"], 10)
+	if w := teacher.do("DELETE", revoke, ""); w.Code != http.StatusOK {
+		t.Fatalf("Revoke failed: %d %s", w.Code, w.Body.String())
+	}
+	bearer("GET", "/api/v1/lessons", readToken, "", http.StatusUnauthorized, codeTokenExpired)
+	if w := teacher.do("DELETE", revoke, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for revoked token, got %d", w.Code)
+	}
+	student := &testClient{t: t, handler: handler}
+	student.do("POST", "/api/v1/auth/login", `{"login":"student","password":"password"}`)
+	if w := student.do("DELETE", "/api/v1/tokens/"+strconv.FormatInt(ids["sync"], 10), ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for token of another user, got %d", w.Code)
+	}
+	json.Unmarshal(teacher.do("GET", "/api/v1/tokens", "").Body.Bytes(), &list)
+	for _, token := range list.Tokens {
+		if token.ID == ids["dashboard"] {
+			t.Error("Expected revoked token to disappear from list")
+		}
+	}
+
+	// просроченный токен не принимается
+	db.Exec(`UPDATE api_tokens SET ExpiresAt = ? WHERE id = ?`, time.Now().Add(-time.Minute).Unix(), ids["reports"])
+	bearer("GET", "/api/v1/lessons", exportToken, "", http.StatusUnauthorized, codeTokenExpired)
+	bearer("GET", "/api/v1/lessons", manageToken, "", http.StatusOK, "")
+}
+
+// TestMarkAttendanceOnce проверяет что одновременные отметки одного студента дают одну запись
+func TestMarkAttendanceOnce(t *testing.T) {
+	useTestDatabase(t)
+	handler := NewHTTPHandler()
+	relaxAuthLimits(t)
+	teacher := &testClient{t: t, handler: handler}
+	student := &testClient{t: t, handler: handler}
+	teacher.do("POST", "/api/v1/auth/login", `{"login":"teacher","password":"password"}`)
+	student.do("POST", "/api/v1/auth/login", `{"login":"student","password":"password"}`)
+
+	var created LessonCreateResponse
+	json.Unmarshal(teacher.do("POST", "/api/v1/lessons", `{"name":"Once","date":"2024-06-01","type":"Lecture"}`).Body.Bytes(), &created)
+	db := database.Get()
+	var lessonID int64
+	if err := db.QueryRow(`SELECT id FROM lessons WHERE QrToken = ?`, created.QrToken).Scan(&lessonID); err != nil {
+		t.Fatal(err)
+	}
+
+	const parallel = 8
+	statuses := make(chan int, parallel)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", "/api/v1/attendance?token="+url.QueryEscape(created.QrToken), nil)
+			req.Header.Set("Origin", "http://"+req.Host)
+			for _, cookie := range student.cookies {
+				req.AddCookie(cookie)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			statuses <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusOK] != 1 || counts[http.StatusConflict] != parallel-1 {
+		t.Errorf("Expected one 200 and %d 409, got %v", parallel-1, counts)
+	}
+
+	// повторная вставка мимо обработчика отклоняется индексом
+	_, err := db.Exec(`INSERT INTO attendances (LessonId, StudentId, Status, ConfirmedDate, GroupId) VALUES (?, 2, 1, datetime('now'), 101)`, lessonID)
+	if err == nil {
+		t.Error("Expected unique constraint error for duplicate mark")
+	}
+	var marks int
+	db.QueryRow(`SELECT COUNT(*) FROM attendances WHERE LessonId = ?`, lessonID).Scan(&marks)
+	if marks != 1 {
+		t.Errorf("Expected one attendance row, got %d", marks)
+	}
+}
+
+// TestTruncatedChallenge проверяет что короткие challenge и cookie дают 401, а не панику
+func TestTruncatedChallenge(t *testing.T) {
+	useTestDatabase(t)
+	handler := NewHTTPHandler()
+	relaxAuthLimits(t)
+	client := &testClient{t: t, handler: handler}
+
+	// base64 короче nonce шифра
+	for _, challenge := range []string{"", "AAAA", "AAAAAAAAAAAAAAAA"} {
+		for _, target := range []string{"/api/v1/auth/totp", "/api/v1/totp/enroll", "/api/v1/totp/confirm"} {
+			w := client.do("POST", target, `{"challenge":"`+challenge+`","code":"123456"}`)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("%s with challenge %q: expected 401, got %d: %s", target, challenge, w.Code, w.Body.String())
+			}
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/lessons", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "AAAA"})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Truncated session cookie: expected 401, got %d", w.Code)
+	}
+}
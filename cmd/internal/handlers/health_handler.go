@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"qr_code/internal/config"
+	"qr_code/internal/database"
+	"time"
+)
+
+type HealthCheck struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type HealthResponse struct {
+	Success bool                   `json:"success"`
+	Message string                 `json:"message"`
+	Checks  map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+const (
+	checkOK   = "ok"
+	checkFail = "fail"
+)
+
+// настройки проверки готовности, значения по умолчанию совпадают с config.Health
+var (
+	readyDBTimeout   = 2 * time.Second
+	readyCertMinDays = 7
+	readyCertFile    string
+)
+
+func configureHealth(cfg config.Health, server config.HTTPServer) {
+	readyDBTimeout = cfg.DBTimeout
+	readyCertMinDays = cfg.CertMinValidDays
+	readyCertFile = server.CertFile
+}
+
+// процесс жив и отвечает; зависимости не проверяются, чтобы оркестратор не перезапускал из-за базы
+func handler_healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(HealthResponse{
+		Success: true,
+		Message: "alive",
+	})
+}
+
+// готовность принимать трафик: конфиг, база, миграции, сертификат
+func handler_readyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	checks := map[string]HealthCheck{
+		"config":      checkConfig(),
+		"database":    checkDatabase(r.Context()),
+		"migrations":  checkMigrations(r.Context()),
+		"certificate": checkCertificate(time.Now()),
+	}
+
+	response := HealthResponse{
+		Success: true,
+		Message: "ready",
+		Checks:  checks,
+	}
+	for _, check := range checks {
+		if check.Status != checkOK {
+			response.Success = false
+			response.Message = "not ready"
+		}
+	}
+	if !response.Success {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// /readyz открыт без авторизации: причина ошибки (пути, ошибки базы) только в лог
+const checkFailedDetail = "check failed, see server log"
+
+func healthResult(check, detail string, err error) HealthCheck {
+	if err != nil {
+		slog.Error("readiness check failed", "check", check, "err", err)
+		return HealthCheck{Status: checkFail, Detail: checkFailedDetail}
+	}
+	return HealthCheck{Status: checkOK, Detail: detail}
+}
+
+func checkConfig() HealthCheck {
+	if !config.Loaded() {
+		return healthResult("config", "", errors.New("config is not loaded"))
+	}
+	return healthResult("config", "env "+config.Get().Env, nil)
+}
+
+func checkDatabase(ctx context.Context) HealthCheck {
+	ctx, cancel := context.WithTimeout(ctx, readyDBTimeout)
+	defer cancel()
+	start := time.Now()
+	if err := database.Get().PingContext(ctx); err != nil {
+		return healthResult("database", "", err)
+	}
+	return healthResult("database", fmt.Sprintf("ping %s", time.Since(start).Round(time.Microsecond)), nil)
+}
+
+func checkMigrations(ctx context.Context) HealthCheck {
+	var version int
+	if err := database.Get().QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return healthResult("migrations", "", err)
+	}
+	if version != database.SchemaVersion {
+		return healthResult("migrations", "", fmt.Errorf("schema version %d, expected %d", version, database.SchemaVersion))
+	}
+	return healthResult("migrations", fmt.Sprintf("schema version %d", version), nil)
+}
+
+// сертификат с диска: тот же файл, что перечитывает сервер
+func checkCertificate(now time.Time) HealthCheck {
+	if readyCertFile == "" {
+		return healthResult("certificate", "not configured", nil)
+	}
+	data, err := os.ReadFile(readyCertFile)
+	if err != nil {
+		return healthResult("certificate", "", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return healthResult("certificate", "", errors.New("no PEM certificate in "+readyCertFile))
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return healthResult("certificate", "", err)
+	}
+	left := cert.NotAfter.Sub(now)
+	if left < time.Duration(readyCertMinDays)*24*time.Hour {
+		return healthResult("certificate", "", fmt.Errorf("certificate expires %s (in %d days, minimum %d)",
+			cert.NotAfter.Format(time.RFC3339), int(left.Hours()/24), readyCertMinDays))
+	}
+	return healthResult("certificate", fmt.Sprintf("expires %s (in %d days)", cert.NotAfter.Format(time.RFC3339), int(left.Hours()/24)), nil)
+}
@@ -1,57 +1,33 @@
 package handlers
 
 import (
-	"log"
 	"net/http"
 	"qr_code/internal/config"
+	"qr_code/internal/cors"
+	"qr_code/internal/i18n"
+	"qr_code/internal/logger"
+	"qr_code/internal/metrics"
 )
 
-// регистрация хандлеров и запуск
-func RegisterHTTPHandlers() {
+// настройка хандлеров по конфигу; возвращает обработчик для серверов
+func NewHTTPHandler() http.Handler {
 	cfg := config.Get()
-	// запуск обработчиков на функции
-	http.HandleFunc("/auth", handler_auth)
-	// lessons
-	http.HandleFunc("/lessons/create", handler_lessons_create)
-	http.HandleFunc("/lessons/mark", handler_lessons_mark)
-	// teacher
-	http.HandleFunc("/teacher/getInfo", handler_teacher_getinfo)
-	http.HandleFunc("/teacher/getLesson", handler_teacher_getlesson)
-	http.HandleFunc("/teacher/export", handler_export_attendances)
-	//archive
-	http.HandleFunc("/archive/getLessons", handler_archive_getlessons)
-	http.HandleFunc("/archive/deleteLesson", handler_archive_deleteLesson)
-	http.HandleFunc("/archive/add", handler_archive_add)
-	// student
-	http.HandleFunc("/student/getInfo", handler_student_getinfo)
-	// logout
-	http.HandleFunc("/logout", LogoutHandler)
-	// конфиг сервера
-	httpServer := &http.Server{
-		Handler:      nil,
-		ReadTimeout:  cfg.HTTPServer.Timeout,
-		WriteTimeout: cfg.HTTPServer.Timeout,
-		IdleTimeout:  cfg.HTTPServer.IdleTimeout,
+	configureRateLimits(cfg.RateLimit)
+	configurePasswords(cfg.Password)
+	configureTOTP(cfg.TOTP)
+	configureOIDC(cfg.OIDC)
+	configureAuth(cfg.Auth, cfg.LDAP)
+	configureCSRF(cfg.CSRF)
+	configureCORS(cfg.Env, cfg.CORS)
+	configureHealth(cfg.Health, cfg.HTTPServer)
+	configureI18n(cfg.I18n)
+	configureTrash(cfg.Trash)
+	configureAutoArchive(cfg.AutoArchive)
+	mux := newRouter()
+	if cfg.Metrics.Enabled {
+		mux.Handle("GET "+cfg.Metrics.Path, metricsHandler(cfg.Metrics))
 	}
-
-	// HTTPS сервер
-	go func() {
-		httpsServer := &http.Server{
-			Handler:      nil,
-			ReadTimeout:  cfg.HTTPServer.Timeout,
-			WriteTimeout: cfg.HTTPServer.Timeout,
-			IdleTimeout:  cfg.HTTPServer.IdleTimeout,
-		}
-		httpsServer.Addr = cfg.HTTPServer.HttpsAddress
-		log.Printf("Starting HTTPS server on %s\n", httpsServer.Addr)
-		err := httpsServer.ListenAndServeTLS("cert/server.crt", "cert/server.key")
-		if err != nil {
-			log.Printf("HTTPS server error: %v", err)
-		}
-	}()
-
-	// HTTP сервер основной
-	httpServer.Addr = cfg.HTTPServer.HttpAddress
-	log.Printf("Starting HTTP server on %s\n", httpServer.Addr)
-	httpServer.ListenAndServe()
+	// CORS для всех маршрутов, preflight отвечается до хандлеров;
+	// снаружи - request id и строка доступа на каждый запрос, язык ответа
+	return logger.Middleware(i18n.Middleware(cors.Middleware(corsPolicy, metrics.Middleware(jsonMuxErrors(mux)))))
 }
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"qr_code/internal/config"
+	"qr_code/internal/database"
+	"qr_code/internal/i18n"
+	"qr_code/internal/logger"
+)
+
+// запрос смены языка; пустая строка - по Accept-Language браузера
+type LanguageRequest struct {
+	Language string `json:"language"`
+}
+
+type LanguageResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	// сохранённая настройка (пусто - не задана)
+	Language string `json:"language"`
+	// язык, на котором сервер отвечает сейчас
+	Effective string   `json:"effective"`
+	Supported []string `json:"supported"`
+}
+
+func configureI18n(cfg config.I18n) {
+	i18n.SetDefault(cfg.DefaultLanguage)
+}
+
+// перевод сообщения на язык запроса
+func tr(r *http.Request, message string) string {
+	return i18n.T(i18n.FromContext(r.Context()), message)
+}
+
+func trf(r *http.Request, format string, args ...any) string {
+	return i18n.Tf(i18n.FromContext(r.Context()), format, args...)
+}
+
+// язык из настроек пользователя; NULL и неизвестные значения - пустая строка
+func userLanguage(db *sql.DB, userID any) (string, error) {
+	var language sql.NullString
+	err := db.QueryRow(`SELECT Language FROM user WHERE id = ?`, userID).Scan(&language)
+	if err != nil {
+		return "", err
+	}
+	if !i18n.Supported(language.String) {
+		return "", nil
+	}
+	return language.String, nil
+}
+
+// просмотр и смена языка интерфейса
+func handler_settings_language(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" && r.Method != "PUT" {
+		writeMethodNotAllowed(w, r, "GET", "PUT")
+		return
+	}
+
+	// смена языка перевыпускает cookie сессии, поэтому только сессия
+	scope := scopeRead
+	if r.Method == "PUT" {
+		scope = scopeSession
+	}
+	userData, err := authenticate(r, scope)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	db := database.Get()
+	if r.Method == "GET" {
+		language, err := userLanguage(db, userData["user_id"])
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		json.NewEncoder(w).Encode(LanguageResponse{
+			Success:   true,
+			Message:   tr(r, "Language settings retrieved"),
+			Language:  language,
+			Effective: i18n.FromContext(r.Context()),
+			Supported: i18n.Languages(),
+		})
+		return
+	}
+
+	var languageRequest LanguageRequest
+	if err := json.NewDecoder(r.Body).Decode(&languageRequest); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON format")
+		return
+	}
+	if languageRequest.Language != "" && !i18n.Supported(languageRequest.Language) {
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Unsupported language")
+		return
+	}
+
+	// пустая строка хранится как NULL
+	language := sql.NullString{String: languageRequest.Language, Valid: languageRequest.Language != ""}
+	if _, err := db.Exec(`UPDATE user SET Language = ? WHERE id = ?`, language, userData["user_id"]); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	// язык хранится и в cookie, чтобы не читать его из базы на каждом запросе
+	userData["language"] = languageRequest.Language
+	if err := setSessionCookie(w, r, userData); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	i18n.SetUserLanguage(r.Context(), languageRequest.Language)
+
+	logger.FromContext(r.Context()).Info("language changed", "language", languageRequest.Language)
+	json.NewEncoder(w).Encode(LanguageResponse{
+		Success:   true,
+		Message:   tr(r, "Language changed"),
+		Language:  languageRequest.Language,
+		Effective: i18n.FromContext(r.Context()),
+		Supported: i18n.Languages(),
+	})
+}
@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"qr_code/internal/database"
+	"qr_code/internal/logger"
+	"qr_code/internal/qrtoken"
+	"qr_code/internal/utils"
+	"strconv"
+	"time"
+)
+
+// запрос изменение занятия; не переданные поля не меняются
+type LessonUpdateRequest struct {
+	NameLesson *string `json:"name"`
+	Date       *string `json:"date"`
+	TypeLes    *string `json:"type"`
+	// старый QR код перестаёт приниматься (например, его сфотографировали и разослали)
+	InvalidateOldToken bool `json:"invalidateOldToken"`
+}
+
+// ответ изменение: новый QR код с новыми данными занятия
+type LessonUpdateResponse struct {
+	Success         bool   `json:"success"`
+	Message         string `json:"message"`
+	QrToken         string `json:"qrToken,omitempty"`
+	OldTokenRevoked bool   `json:"oldTokenRevoked"`
+}
+
+// запись истории изменений занятия
+type LessonHistoryEntry struct {
+	ID            int64     `json:"id"`
+	ChangedAt     time.Time `json:"changed_at"`
+	ChangedBy     int64     `json:"changed_by"`
+	ChangedByName string    `json:"changed_by_name"`
+	OldName       string    `json:"old_name"`
+	OldDate       string    `json:"old_date"`
+	OldType       string    `json:"old_type"`
+	NewName       string    `json:"new_name"`
+	NewDate       string    `json:"new_date"`
+	NewType       string    `json:"new_type"`
+	TokenRevoked  bool      `json:"token_revoked"`
+}
+
+type LessonHistoryResponse struct {
+	Success bool                 `json:"success"`
+	Message string               `json:"message"`
+	History []LessonHistoryEntry `json:"history"`
+}
+
+// изменение названия, даты и типа занятия владельцем; QR код выпускается заново
+func handler_lessons_update(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "PATCH" {
+		writeMethodNotAllowed(w, r, "PATCH")
+		return
+	}
+	// cookie сессии или Bearer токен
+	userData, err := authenticate(r, scopeManage)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	// role check
+	if userData["role"] != "Teacher" {
+		writeError(w, r, http.StatusForbidden, codeForbidden, "Access denied")
+		return
+	}
+
+	lessonId, err := strconv.Atoi(lessonIDParam(r))
+	if err != nil || lessonId <= 0 {
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Invalid Lesson ID format")
+		return
+	}
+
+	var updateRequest LessonUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&updateRequest); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON format")
+		return
+	}
+	if updateRequest.NameLesson == nil && updateRequest.Date == nil && updateRequest.TypeLes == nil {
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Nothing to update: pass name, date or type")
+		return
+	}
+
+	// те же проверки, что при создании
+	for _, field := range []*string{updateRequest.Date, updateRequest.TypeLes} {
+		if field == nil {
+			continue
+		}
+		if *field == "" {
+			writeError(w, r, http.StatusBadRequest, codeValidation, "Input is empty")
+			return
+		}
+		if !utils.IsSafeString(*field) {
+			writeError(w, r, http.StatusBadRequest, codeValidation, "Input contains invalid characters. Only letters, numbers, @, ., -, _ are allowed (3-50 characters)")
+			return
+		}
+	}
+	if updateRequest.Date != nil {
+		if _, err := time.Parse(time.DateOnly, *updateRequest.Date); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeValidation, "Dates must be in YYYY-MM-DD format")
+			return
+		}
+	}
+
+	db := database.Get()
+	tx, err := db.Begin()
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var oldName, oldDate, oldType string
+	var oldToken sql.NullString
+	var isActive bool
+	var generation int64
+	err = tx.QueryRow(`
+		SELECT NameLesson, Date, TypeLes, QrToken, IsActive, QrGeneration
+		FROM lessons WHERE id = ? AND TeacherId = ? AND DeletedAt IS NULL`,
+		lessonId, userData["user_id"],
+	).Scan(&oldName, &oldDate, &oldType, &oldToken, &isActive, &generation)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, codeLessonNotFound, "Lesson not found or access denied")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if !isActive {
+		writeError(w, r, http.StatusConflict, codeLessonArchived, "Lesson is archived")
+		return
+	}
+
+	newName, newDate, newType := oldName, oldDate, oldType
+	if updateRequest.NameLesson != nil {
+		newName = utils.CleanString(*updateRequest.NameLesson)
+	}
+	if updateRequest.Date != nil {
+		newDate = utils.CleanString(*updateRequest.Date)
+	}
+	if updateRequest.TypeLes != nil {
+		newType = utils.CleanString(*updateRequest.TypeLes)
+	}
+
+	// данные занятия зашиты в QR код, поэтому код выпускается заново, со следующим поколением
+	fullName, _ := userData["full_name"].(string)
+	generation++
+	qrToken, err := qrtoken.Generate(int64(lessonId), generation, newName, newDate, newType, fullName)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	// без отзыва прежние коды продолжают приниматься для этого же занятия,
+	// с отзывом - не принимается ни один код до нового
+	revoked := updateRequest.InvalidateOldToken && oldToken.String != ""
+	_, err = tx.Exec(`
+		UPDATE lessons SET NameLesson = ?, Date = ?, TypeLes = ?, QrToken = ?, QrGeneration = ?,
+			QrMinGeneration = CASE WHEN ? THEN ? ELSE QrMinGeneration END
+		WHERE id = ?`,
+		newName, newDate, newType, qrToken, generation, revoked, generation, lessonId,
+	)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	now := time.Now().Unix()
+
+	_, err = tx.Exec(`
+		INSERT INTO lesson_history (LessonId, ChangedBy, ChangedAt, OldName, OldDate, OldType, NewName, NewDate, NewType, TokenRevoked)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		lessonId, userData["user_id"], now, oldName, oldDate, oldType, newName, newDate, newType, revoked,
+	)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	logger.FromContext(r.Context()).Info("lesson updated", "login", userData["login"], "lesson_id", lessonId, "old_token_revoked", revoked)
+	response := LessonUpdateResponse{
+		Success:         true,
+		Message:         tr(r, "Lesson updated successfully"),
+		QrToken:         qrToken,
+		OldTokenRevoked: revoked,
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// история изменений занятия, новые записи первыми
+func handler_lessons_history(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r, "GET")
+		return
+	}
+	// cookie сессии или Bearer токен
+	userData, err := authenticate(r, scopeRead)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	// role check
+	if userData["role"] != "Teacher" {
+		writeError(w, r, http.StatusForbidden, codeForbidden, "Access denied")
+		return
+	}
+
+	lessonId, err := strconv.Atoi(lessonIDParam(r))
+	if err != nil || lessonId <= 0 {
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Invalid Lesson ID format")
+		return
+	}
+
+	db := database.Get()
+
+	// историю видит только владелец занятия
+	var owned int
+	err = db.QueryRow(`SELECT COUNT(*) FROM lessons WHERE id = ? AND TeacherId = ? AND DeletedAt IS NULL`, lessonId, userData["user_id"]).Scan(&owned)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if owned == 0 {
+		writeError(w, r, http.StatusNotFound, codeLessonNotFound, "Lesson not found or access denied")
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT lesson_history.id, lesson_history.ChangedAt, lesson_history.ChangedBy, COALESCE(user.FullName, ''),
+			lesson_history.OldName, lesson_history.OldDate, lesson_history.OldType,
+			lesson_history.NewName, lesson_history.NewDate, lesson_history.NewType, lesson_history.TokenRevoked
+		FROM lesson_history
+		LEFT JOIN user ON user.id = lesson_history.ChangedBy
+		WHERE lesson_history.LessonId = ?
+		ORDER BY lesson_history.id DESC`,
+		lessonId,
+	)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	history := []LessonHistoryEntry{}
+	for rows.Next() {
+		var entry LessonHistoryEntry
+		var changedAt int64
+		err := rows.Scan(&entry.ID, &changedAt, &entry.ChangedBy, &entry.ChangedByName,
+			&entry.OldName, &entry.OldDate, &entry.OldType,
+			&entry.NewName, &entry.NewDate, &entry.NewType, &entry.TokenRevoked)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		entry.ChangedAt = time.Unix(changedAt, 0).UTC()
+		history = append(history, entry)
+	}
+	if err := rows.Err(); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	response := LessonHistoryResponse{
+		Success: true,
+		Message: tr(r, "Lesson history retrieved successfully"),
+		History: history,
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -1,22 +1,35 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
-	"fmt"
-	"log"
+	"errors"
 	"net/http"
-	"qr_code/internal/cookie"
-	"qr_code/internal/cors"
 	"qr_code/internal/database"
+	"qr_code/internal/logger"
 	"qr_code/internal/qrtoken"
 	"qr_code/internal/utils"
+	"strconv"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
 )
 
+// приём отметок открыт: сессия начата и ещё не закончилась
+const sessionOpenExpr = `(SessionStart IS NOT NULL AND SessionStart <= datetime('now') AND (SessionEnd IS NULL OR SessionEnd > datetime('now')))`
+
+// время автозакрытия; параметр (минуты) передаётся дважды, 0 - без автозакрытия
+const sessionEndExpr = `CASE WHEN ? > 0 THEN datetime('now', '+' || ? || ' minutes') END`
+
 // запрос создание
 type LessonCreateRequest struct {
 	NameLesson string `json:"name"`
 	Date       string `json:"date"`
 	TypeLes    string `json:"type"`
+	// автозакрытие приёма отметок через N минут (0 - без ограничения)
+	AutoCloseMinutes int `json:"autoCloseMinutes"`
+	// группы, для которых проводится занятие (необязательно)
+	Groups []int `json:"groups"`
 	// IsActive   bool   `json:"isActive"`
 	// TeacherId  int    `json:"teacherId"`
 }
@@ -28,9 +41,23 @@ type LessonCreateResponse struct {
 	QrToken string `json:"qrToken,omitempty"`
 }
 
+// ответ open/close
+type LessonSessionResponse struct {
+	Success      bool       `json:"success"`
+	Message      string     `json:"message"`
+	LessonID     int        `json:"lessonId,omitempty"`
+	IsOpen       bool       `json:"isOpen"`
+	SessionStart *time.Time `json:"sessionStart,omitempty"`
+	SessionEnd   *time.Time `json:"sessionEnd,omitempty"`
+}
+
+// максимальное время автозакрытия - сутки
+const maxAutoCloseMinutes = 24 * 60
+
 // ответ mark
 type LessonMarkResponse struct {
 	Success     bool   `json:"success"`
+	Code        string `json:"code,omitempty"`
 	Message     string `json:"message"`
 	ID          int64  `json:"id"`
 	Name        string `json:"nameLesson"`
@@ -42,54 +69,22 @@ type LessonMarkResponse struct {
 
 func handler_lessons_create(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	cors.SetCORSHeaders(&w, r)
-	// 200
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
 
 	if r.Method != "POST" {
-		response := LessonCreateResponse{
-			Success: false,
-			Message: "Only POST method allowed",
-		}
-		json.NewEncoder(w).Encode(response)
-		return
-	}
-
-	// cookie check
-	sessionCookie, err := r.Cookie("session")
-	if err != nil {
-		response := LessonCreateResponse{
-			Success: false,
-			Message: "Not authorized",
-		}
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(response)
+		writeMethodNotAllowed(w, r, "POST")
 		return
 	}
 
-	// valid cookie check
-	userData, err := cookie.DecryptCookie(sessionCookie.Value)
+	// cookie сессии или Bearer токен
+	userData, err := authenticate(r, scopeManage)
 	if err != nil {
-		response := LessonCreateResponse{
-			Success: false,
-			Message: "Invalid session: " + err.Error(),
-		}
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(response)
+		writeAPIError(w, r, err)
 		return
 	}
 
 	// role check
 	if userData["role"] != "Teacher" {
-		response := LessonCreateResponse{
-			Success: false,
-			Message: "Access denied",
-		}
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusForbidden, codeForbidden, "Access denied")
 		return
 	}
 
@@ -97,72 +92,72 @@ func handler_lessons_create(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	// проверка на невалидно переданный json (не получается распарсить)
 	if err := decoder.Decode(&lessonCreateRequest); err != nil {
-		response := LessonCreateResponse{
-			Success: false,
-			Message: "Invalid JSON format",
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON format")
 		return
 	}
 	// id in cookie != id in request
 	userID, _ := userData["user_id"].(float64)
 	/*if !ok || int(userID) != lessonCreateRequest.TeacherId {
-		response := LessonCreateResponse{
-			Success: false,
-			Message: "Access denied",
-		}
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusForbidden, codeForbidden, "Access denied")
 		return
 	}*/
 
-	log.Printf("Teacher %s creating lesson", userData["login"])
+	logger.FromContext(r.Context()).Info("creating lesson", "login", userData["login"])
 
 	// проверка полей на пустоту
 	if lessonCreateRequest.Date == "" || lessonCreateRequest.TypeLes == "" || userID < 0 {
-		response := LessonCreateResponse{
-			Success: false,
-			Message: "Input is empty",
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Input is empty")
 		return
 	}
 
 	// проверка полей на валидность по символам
 	if !utils.IsSafeString(lessonCreateRequest.Date) || !utils.IsSafeString(lessonCreateRequest.TypeLes) {
-		response := LessonCreateResponse{
-			Success: false,
-			Message: "Input contains invalid characters. Only letters, numbers, @, ., -, _ are allowed (3-50 characters)",
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Input contains invalid characters. Only letters, numbers, @, ., -, _ are allowed (3-50 characters)")
+		return
+	}
+	if _, err := time.Parse(time.DateOnly, lessonCreateRequest.Date); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Dates must be in YYYY-MM-DD format")
 		return
 	}
 
+	if lessonCreateRequest.AutoCloseMinutes < 0 || lessonCreateRequest.AutoCloseMinutes > maxAutoCloseMinutes {
+		writeError(w, r, http.StatusBadRequest, codeValidation, trf(r, "autoCloseMinutes must be between 0 and %d", maxAutoCloseMinutes))
+		return
+	}
+
+	for _, groupId := range lessonCreateRequest.Groups {
+		if groupId <= 0 {
+			writeError(w, r, http.StatusBadRequest, codeValidation, "Group IDs must be positive numbers")
+			return
+		}
+	}
+
 	cleanName, cleanDate, cleanTypeLes := utils.CleanString(lessonCreateRequest.NameLesson), utils.CleanString(lessonCreateRequest.Date), utils.CleanString(lessonCreateRequest.TypeLes)
 
 	// база данных логика
+	// приём отметок открывается сразу при создании занятия
 	db := database.Get()
 	result, err := db.Exec(`
-        INSERT INTO lessons (NameLesson, Date, TypeLes, QrToken, IsActive, TeacherId) 
-        VALUES (?, ?, ?, ?, ?, ?)`,
+        INSERT INTO lessons (NameLesson, Date, TypeLes, QrToken, IsActive, TeacherId, SessionStart, SessionEnd) 
+        VALUES (?, ?, ?, ?, ?, ?, datetime('now'), `+sessionEndExpr+`)`,
 		cleanName, cleanDate, cleanTypeLes, "", true, userID,
+		lessonCreateRequest.AutoCloseMinutes, lessonCreateRequest.AutoCloseMinutes,
 	)
 
 	if err != nil {
-		response := LessonCreateResponse{
-			Success: false,
-			Message: "Database error: " + err.Error(),
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+		writeInternalError(w, r, err)
 		return
 	}
 
 	id, _ := result.LastInsertId()
-	qrToken, _ := qrtoken.Generate(id, cleanName, cleanDate, cleanTypeLes, userData["full_name"].(string))
+	for _, groupId := range lessonCreateRequest.Groups {
+		_, err = db.Exec(`INSERT OR IGNORE INTO lesson_groups (LessonId, GroupId) VALUES (?, ?)`, id, groupId)
+		if err != nil {
+			logger.FromContext(r.Context()).Error("adding group to lesson failed", "group_id", groupId, "lesson_id", id, "err", err)
+		}
+	}
+	fullName, _ := userData["full_name"].(string)
+	qrToken, _ := qrtoken.Generate(id, 0, cleanName, cleanDate, cleanTypeLes, fullName)
 
 	_, _ = db.Exec(`
 		UPDATE lessons SET QrToken = ? WHERE id = ?`,
@@ -170,7 +165,7 @@ func handler_lessons_create(w http.ResponseWriter, r *http.Request) {
 	)
 	response := LessonCreateResponse{
 		Success: true,
-		Message: fmt.Sprintf("Lesson '%s' created successfully with ID: %d", cleanName, id),
+		Message: trf(r, "Lesson '%s' created successfully with ID: %d", cleanName, id),
 		QrToken: qrToken,
 	}
 	json.NewEncoder(w).Encode(response)
@@ -178,94 +173,83 @@ func handler_lessons_create(w http.ResponseWriter, r *http.Request) {
 
 func handler_lessons_mark(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	cors.SetCORSHeaders(&w, r)
-	// 200
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
 
 	if r.Method != "POST" && r.Method != "GET" {
-		response := LessonMarkResponse{
-			Success: false,
-			Message: "Only POST/GET method allowed",
-		}
-		json.NewEncoder(w).Encode(response)
+		writeMethodNotAllowed(w, r, "POST", "GET")
 		return
 	}
-
-	// cookie check
-	sessionCookie, err := r.Cookie("session")
-	if err != nil {
-		response := LessonMarkResponse{
-			Success: false,
-			Message: "Not authorized",
-		}
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(response)
+	// GET остался только у старого /lessons/mark: cookie уходит и с чужих страниц
+	if r.Method == "GET" && !sameOriginNavigation(r) {
+		writeError(w, r, http.StatusForbidden, codeCSRFRejected, "Cross-site request rejected")
 		return
 	}
 
-	// valid cookie check
-	userData, err := cookie.DecryptCookie(sessionCookie.Value)
+	// cookie сессии или Bearer токен
+	userData, err := authenticate(r, scopeManage)
 	if err != nil {
-		response := LessonMarkResponse{
-			Success: false,
-			Message: "Invalid session: " + err.Error(),
-		}
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(response)
+		writeAPIError(w, r, err)
 		return
 	}
 
 	// role check
 	if userData["role"] != "Student" {
-		response := LessonMarkResponse{
-			Success: false,
-			Message: "Access denied",
-		}
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusForbidden, codeForbidden, "Access denied")
 		return
 	}
 
 	q := r.URL.Query()
 	qrToken := q.Get("token")
 	if qrToken == "" {
-		response := LessonMarkResponse{
-			Success: false,
-			Message: "Missing token parameter",
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Missing token parameter")
 		return
 	}
 
 	token, err := qrtoken.Parse(qrToken)
 	if err != nil {
-		response := LessonMarkResponse{
-			Success: false,
-			Message: "Invalid or expired QR token",
-		}
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusForbidden, codeQRTokenInvalid, "Invalid or expired QR token")
+		return
+	}
+
+	// db
+	db := database.Get()
+
+	// занятие должно существовать, быть активным и с открытым приёмом отметок
+	var isActive, isOpen bool
+	var minGeneration int64
+	err = db.QueryRow(`
+		SELECT IsActive, `+sessionOpenExpr+`, QrMinGeneration
+		FROM lessons WHERE id = ? AND DeletedAt IS NULL`,
+		token.ID,
+	).Scan(&isActive, &isOpen, &minGeneration)
+
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, codeLessonNotFound, "Lesson not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	// код старше отзыва при изменении занятия
+	if token.Generation < minGeneration {
+		writeError(w, r, http.StatusForbidden, codeQRTokenInvalid, "Invalid or expired QR token")
+		return
+	}
+	if !isActive {
+		writeError(w, r, http.StatusConflict, codeLessonArchived, "Lesson is archived")
+		return
+	}
+	if !isOpen {
+		writeError(w, r, http.StatusForbidden, codeSessionClosed, "Attendance session is closed")
 		return
 	}
 
 	studentID, ok := userData["user_id"].(float64)
 	if !ok {
-		response := LessonMarkResponse{
-			Success: false,
-			Message: "Invalid user data",
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+		writeInternalError(w, r, errors.New("user_id missing in session"))
 		return
 	}
 
-	// db insert
-	db := database.Get()
-
 	// check exists
 	var count int
 	err = db.QueryRow(`
@@ -275,20 +259,17 @@ func handler_lessons_mark(w http.ResponseWriter, r *http.Request) {
 	).Scan(&count)
 
 	if err != nil {
-		response := LessonMarkResponse{
-			Success: false,
-			Message: "Database error: " + err.Error(),
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+		writeInternalError(w, r, err)
 		return
 	}
 
 	// if exists
 	if count > 0 {
+		// данные занятия нужны клиенту, чтобы показать, где уже есть отметка
 		response := LessonMarkResponse{
 			Success:     false,
-			Message: 	 "Attendance already marked",
+			Code:        codeAttendanceAlreadyMarked,
+			Message:     tr(r, "Attendance already marked"),
 			ID:          token.ID,
 			Name:        token.Name,
 			Date:        token.Date,
@@ -296,31 +277,36 @@ func handler_lessons_mark(w http.ResponseWriter, r *http.Request) {
 			TeacherName: token.TeacherName,
 			Created:     token.Created,
 		}
-		w.WriteHeader(http.StatusBadRequest) // 400
+		w.WriteHeader(http.StatusConflict)
 		json.NewEncoder(w).Encode(response)
 		return
 	}
 	// not exist, add
+	groupID, _ := userData["group_id"].(float64)
 	_, err = db.Exec(`
-		INSERT INTO attendances (LessonId, StudentId, Status, ConfirmedDate) 
-		VALUES (?, ?, 1, datetime('now'))`,
-		token.ID, int64(studentID),
+		INSERT INTO attendances (LessonId, StudentId, Status, ConfirmedDate, GroupId) 
+		VALUES (?, ?, 1, datetime('now'), ?)`,
+		token.ID, int64(studentID), int64(groupID),
 	)
 
 	if err != nil {
-		response := LessonMarkResponse{
-			Success: false,
-			Message: "Already marked or error",
+		// параллельная отметка того же студента
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			writeError(w, r, http.StatusConflict, codeAttendanceAlreadyMarked, "Attendance already marked")
+			return
 		}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeInternalError(w, r, err)
 		return
 	}
 
-	log.Printf("ID: %d, Lesson: %s, Teacher: %s\n", token.ID, token.Name, token.TeacherName)
+	logger.FromContext(r.Context()).Info("attendance marked", "lesson_id", token.ID, "student_id", studentID)
+	fullName, _ := userData["full_name"].(string)
+	publishMark(db, token.ID, int64(studentID), int64(groupID), fullName)
+	attendanceMarks.Inc()
 	response := LessonMarkResponse{
 		Success:     true,
-		Message:     "Attendance marked successfully",
+		Message:     tr(r, "Attendance marked successfully"),
 		ID:          token.ID,
 		Name:        token.Name,
 		Date:        token.Date,
@@ -330,3 +316,106 @@ func handler_lessons_mark(w http.ResponseWriter, r *http.Request) {
 	}
 	json.NewEncoder(w).Encode(response)
 }
+
+// открытие приёма отметок (повторное открытие начинает новое окно)
+func handler_lessons_open(w http.ResponseWriter, r *http.Request) {
+	handler_lessons_session(w, r, true)
+}
+
+// закрытие приёма отметок
+func handler_lessons_close(w http.ResponseWriter, r *http.Request) {
+	handler_lessons_session(w, r, false)
+}
+
+func handler_lessons_session(w http.ResponseWriter, r *http.Request, open bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r, "POST")
+		return
+	}
+	// cookie сессии или Bearer токен
+	userData, err := authenticate(r, scopeManage)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	// role check
+	if userData["role"] != "Teacher" {
+		writeError(w, r, http.StatusForbidden, codeForbidden, "Access denied")
+		return
+	}
+
+	q := r.URL.Query()
+	lessonIdParam := lessonIDParam(r)
+	if lessonIdParam == "" {
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Lesson ID is required")
+		return
+	}
+	// конвертация в int
+	lessonId, err := strconv.Atoi(lessonIdParam)
+	if err != nil || lessonId <= 0 {
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Invalid Lesson ID format")
+		return
+	}
+
+	// автозакрытие, только для open
+	autoCloseMinutes := 0
+	if open && q.Get("autoCloseMinutes") != "" {
+		autoCloseMinutes, err = strconv.Atoi(q.Get("autoCloseMinutes"))
+		if err != nil || autoCloseMinutes < 0 || autoCloseMinutes > maxAutoCloseMinutes {
+			writeError(w, r, http.StatusBadRequest, codeValidation, trf(r, "autoCloseMinutes must be between 0 and %d", maxAutoCloseMinutes))
+			return
+		}
+	}
+
+	db := database.Get()
+
+	// архивное занятие открыть нельзя
+	var result sql.Result
+	if open {
+		result, err = db.Exec(`
+			UPDATE lessons SET SessionStart = datetime('now'), SessionEnd = `+sessionEndExpr+`
+			WHERE id = ? AND TeacherId = ? AND IsActive = TRUE`,
+			autoCloseMinutes, autoCloseMinutes, lessonId, userData["user_id"],
+		)
+	} else {
+		result, err = db.Exec(`
+			UPDATE lessons SET SessionEnd = datetime('now')
+			WHERE id = ? AND TeacherId = ? AND IsActive = TRUE AND `+sessionOpenExpr,
+			lessonId, userData["user_id"],
+		)
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		message := "Lesson not found, you are not the owner, or lesson is archived"
+		if !open {
+			message = "Lesson not found, you are not the owner, or session is not open"
+		}
+		writeError(w, r, http.StatusNotFound, codeLessonNotFound, message)
+		return
+	}
+
+	response := LessonSessionResponse{
+		Success:  true,
+		LessonID: lessonId,
+	}
+	err = db.QueryRow(`SELECT SessionStart, SessionEnd, `+sessionOpenExpr+` FROM lessons WHERE id = ?`, lessonId).Scan(
+		&response.SessionStart, &response.SessionEnd, &response.IsOpen,
+	)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("reading lesson session failed", "err", err)
+	}
+
+	if open {
+		response.Message = tr(r, "Attendance session opened")
+	} else {
+		response.Message = tr(r, "Attendance session closed")
+	}
+	logger.FromContext(r.Context()).Info("lesson session changed", "login", userData["login"], "lesson_id", lessonId, "open", open)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// размер страницы списков занятий: по умолчанию и максимальный
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// сортировка: параметр sort -> колонка; "-" перед именем - по убыванию
+var lessonSortColumns = map[string]string{
+	"date":    "Date",
+	"name":    "NameLesson",
+	"created": "id",
+}
+
+// какие занятия в списке: текущие, архив или корзина
+type lessonState int
+
+const (
+	lessonsActive lessonState = iota
+	lessonsArchived
+	lessonsDeleted
+)
+
+// параметры списка занятий (GET /api/v1/lessons, GET /api/v1/archive, GET /api/v1/trash)
+type lessonListQuery struct {
+	From    string // Date >= from, YYYY-MM-DD
+	To      string // Date <= to, YYYY-MM-DD
+	Name    string // подстрока названия
+	Type    string // тип занятия целиком
+	GroupID int64  // занятие проводится для группы
+	Sort    string
+	Limit   int
+	Offset  int
+	Cursor  *lessonCursor
+}
+
+// курсор следующей страницы: значение сортируемой колонки и id последнего занятия
+type lessonCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+func (c lessonCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeLessonCursor(text string) (*lessonCursor, bool) {
+	data, err := base64.RawURLEncoding.DecodeString(text)
+	if err != nil {
+		return nil, false
+	}
+	var cursor lessonCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID <= 0 {
+		return nil, false
+	}
+	return &cursor, true
+}
+
+// разбор query параметров; ошибки - 400 VALIDATION_FAILED
+func parseLessonListQuery(r *http.Request) (lessonListQuery, error) {
+	params := r.URL.Query()
+	query := lessonListQuery{
+		From:  params.Get("from"),
+		To:    params.Get("to"),
+		Name:  strings.TrimSpace(params.Get("name")),
+		Type:  strings.TrimSpace(params.Get("type")),
+		Sort:  params.Get("sort"),
+		Limit: defaultPageSize,
+	}
+
+	for _, date := range []string{query.From, query.To} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			return query, newAPIError(http.StatusBadRequest, codeValidation, "Dates must be in YYYY-MM-DD format")
+		}
+	}
+	if query.From != "" && query.To != "" && query.From > query.To {
+		return query, newAPIError(http.StatusBadRequest, codeValidation, "from must not be later than to")
+	}
+
+	if value := params.Get("group"); value != "" {
+		groupID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || groupID <= 0 {
+			return query, newAPIError(http.StatusBadRequest, codeValidation, "Group IDs must be positive numbers")
+		}
+		query.GroupID = groupID
+	}
+
+	if query.Sort == "" {
+		query.Sort = "-date"
+	}
+	if _, ok := lessonSortColumns[strings.TrimPrefix(query.Sort, "-")]; !ok {
+		return query, newAPIError(http.StatusBadRequest, codeValidation, "sort must be one of date, name, created (prefix - for descending order)")
+	}
+
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return query, newAPIError(http.StatusBadRequest, codeValidation, trf(r, "limit must be between 1 and %d", maxPageSize))
+		}
+		query.Limit = limit
+	}
+	if value := params.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return query, newAPIError(http.StatusBadRequest, codeValidation, "offset must not be negative")
+		}
+		query.Offset = offset
+	}
+
+	if value := params.Get("cursor"); value != "" {
+		if query.Offset > 0 {
+			return query, newAPIError(http.StatusBadRequest, codeValidation, "Use either cursor or offset, not both")
+		}
+		cursor, ok := decodeLessonCursor(value)
+		// курсор привязан к сортировке, с которой получен
+		if !ok || cursor.Sort != query.Sort {
+			return query, newAPIError(http.StatusBadRequest, codeValidation, "Invalid cursor")
+		}
+		query.Cursor = cursor
+	}
+	return query, nil
+}
+
+// страница занятий учителя в состоянии state;
+// total - число занятий по фильтрам без учёта страницы, nextCursor пустой на последней странице
+func queryLessons(db *sql.DB, teacherID any, state lessonState, query lessonListQuery) (lessons []Lesson, total int, nextCursor string, err error) {
+	where := []string{"TeacherId = ?"}
+	args := []any{teacherID}
+	switch state {
+	case lessonsActive:
+		where = append(where, "IsActive = TRUE", "DeletedAt IS NULL")
+	case lessonsArchived:
+		where = append(where, "IsActive = FALSE", "DeletedAt IS NULL")
+	case lessonsDeleted:
+		where = append(where, "DeletedAt IS NOT NULL")
+	}
+	if query.From != "" {
+		where = append(where, "Date >= ?")
+		args = append(args, query.From)
+	}
+	if query.To != "" {
+		where = append(where, "Date <= ?")
+		args = append(args, query.To)
+	}
+	if query.Name != "" {
+		where = append(where, `NameLesson LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likeEscaper.Replace(query.Name)+"%")
+	}
+	if query.Type != "" {
+		where = append(where, "TypeLes = ?")
+		args = append(args, query.Type)
+	}
+	if query.GroupID > 0 {
+		where = append(where, "EXISTS (SELECT 1 FROM lesson_groups WHERE LessonId = lessons.id AND GroupId = ?)")
+		args = append(args, query.GroupID)
+	}
+
+	err = db.QueryRow(`SELECT COUNT(*) FROM lessons WHERE `+strings.Join(where, " AND "), args...).Scan(&total)
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	descending := strings.HasPrefix(query.Sort, "-")
+	column := lessonSortColumns[strings.TrimPrefix(query.Sort, "-")]
+	direction, compare := "ASC", ">"
+	if descending {
+		direction, compare = "DESC", "<"
+	}
+
+	// keyset: следующая страница начинается после (значение, id) последнего занятия
+	if query.Cursor != nil {
+		if column == "id" {
+			where = append(where, "id "+compare+" ?")
+			args = append(args, query.Cursor.ID)
+		} else {
+			where = append(where, "("+column+" "+compare+" ? OR ("+column+" = ? AND id "+compare+" ?))")
+			args = append(args, query.Cursor.Value, query.Cursor.Value, query.Cursor.ID)
+		}
+	}
+
+	orderBy := column + " " + direction
+	if column != "id" {
+		orderBy += ", id " + direction
+	}
+	// на одну запись больше страницы - чтобы узнать, есть ли следующая
+	args = append(args, query.Limit+1, query.Offset)
+	rows, err := db.Query(`
+		SELECT `+lessonColumns+`, DeletedAt FROM lessons
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY `+orderBy+`
+		LIMIT ? OFFSET ?`, args...)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var lesson Lesson
+		err := rows.Scan(&lesson.ID, &lesson.NameLesson, &lesson.Date, &lesson.TypeLes, &lesson.QrToken, &lesson.IsActive, &lesson.TeacherId,
+			&lesson.SessionStart, &lesson.SessionEnd, &lesson.IsOpen, &lesson.DeletedAt)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		lessons = append(lessons, lesson)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, "", err
+	}
+
+	if len(lessons) > query.Limit {
+		lessons = lessons[:query.Limit]
+		last := lessons[len(lessons)-1]
+		cursor := lessonCursor{Sort: query.Sort, ID: int64(last.ID)}
+		switch column {
+		case "Date":
+			cursor.Value = last.Date
+		case "NameLesson":
+			cursor.Value = last.NameLesson
+		}
+		nextCursor = cursor.encode()
+	}
+	return lessons, total, nextCursor, nil
+}
+
+// % и _ в подстроке ищутся как обычные символы
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"qr_code/internal/database"
+	"qr_code/internal/logger"
+	"qr_code/internal/pubsub"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// событие отметки для живой ленты преподавателя
+type LiveMarkEvent struct {
+	LessonID  int64     `json:"lessonId"`
+	StudentID int64     `json:"studentId"`
+	FullName  string    `json:"fullName"`
+	GroupId   int64     `json:"groupId"`
+	Time      time.Time `json:"time"`
+	Flags     []string  `json:"flags"`
+	Marked    int       `json:"marked"`
+	Enrolled  int       `json:"enrolled"`
+}
+
+// начальное состояние при подключении к ленте
+type LiveSnapshotEvent struct {
+	LessonID int64 `json:"lessonId"`
+	IsOpen   bool  `json:"isOpen"`
+	Marked   int   `json:"marked"`
+	Enrolled int   `json:"enrolled"`
+}
+
+type LiveErrorResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// флаги отметки
+const (
+	// отметка позже lateAfterMinutes от открытия приёма
+	flagLate = "late"
+	// студент не из групп занятия
+	flagNotEnrolled = "notEnrolled"
+)
+
+const lateAfterMinutes = 15
+
+// интервал keep-alive комментариев, чтобы прокси не закрывали соединение
+const liveKeepAlive = 15 * time.Second
+
+func liveTopic(lessonID int64) string {
+	return fmt.Sprintf("lesson:%d", lessonID)
+}
+
+// отмечено / записано на занятие (студенты групп занятия)
+func lessonCounts(db *sql.DB, lessonID int64) (marked, enrolled int, err error) {
+	err = db.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM attendances WHERE LessonId = ?),
+			(SELECT COUNT(*) FROM user
+				JOIN lesson_groups ON lesson_groups.GroupId = user.GroupId
+				WHERE lesson_groups.LessonId = ? AND user.Role = 'Student')`,
+		lessonID, lessonID,
+	).Scan(&marked, &enrolled)
+	return marked, enrolled, err
+}
+
+// публикация успешной отметки в ленту занятия
+func publishMark(db *sql.DB, lessonID, studentID, groupID int64, fullName string) {
+	// ленту никто не смотрит - флаги и счётчики не нужны
+	topic := liveTopic(lessonID)
+	if !pubsub.Get().HasSubscribers(topic) {
+		return
+	}
+
+	event := LiveMarkEvent{
+		LessonID:  lessonID,
+		StudentID: studentID,
+		FullName:  fullName,
+		GroupId:   groupID,
+		Time:      time.Now().UTC(),
+		Flags:     []string{},
+	}
+
+	var late, hasGroups, inGroups bool
+	err := db.QueryRow(`
+		SELECT
+			COALESCE((julianday('now') - julianday(SessionStart)) * 1440 > ?, FALSE),
+			EXISTS(SELECT 1 FROM lesson_groups WHERE LessonId = lessons.id),
+			EXISTS(SELECT 1 FROM lesson_groups WHERE LessonId = lessons.id AND GroupId = ?)
+		FROM lessons WHERE id = ?`,
+		lateAfterMinutes, groupID, lessonID,
+	).Scan(&late, &hasGroups, &inGroups)
+	if err != nil {
+		slog.Error("live feed: reading lesson failed", "lesson_id", lessonID, "err", err)
+	}
+	if late {
+		event.Flags = append(event.Flags, flagLate)
+	}
+	if hasGroups && !inGroups {
+		event.Flags = append(event.Flags, flagNotEnrolled)
+	}
+
+	event.Marked, event.Enrolled, err = lessonCounts(db, lessonID)
+	if err != nil {
+		slog.Error("live feed: counting lesson failed", "lesson_id", lessonID, "err", err)
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("live feed: marshal failed", "err", err)
+		return
+	}
+	if err := pubsub.Get().Publish(topic, data); err != nil {
+		slog.Error("live feed: publish failed", "lesson_id", lessonID, "err", err)
+	}
+}
+
+// закрывается при остановке сервера: Shutdown не дождётся потоков, которые не кончаются сами
+var (
+	liveShutdown     = make(chan struct{})
+	liveShutdownOnce sync.Once
+)
+
+func CloseLiveStreams() {
+	liveShutdownOnce.Do(func() { close(liveShutdown) })
+}
+
+// живая лента отметок занятия (Server-Sent Events)
+func handler_lessons_live(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r, "GET")
+		return
+	}
+	// cookie сессии или Bearer токен
+	userData, err := authenticate(r, scopeRead)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	// role check
+	if userData["role"] != "Teacher" {
+		writeError(w, r, http.StatusForbidden, codeForbidden, "Access denied")
+		return
+	}
+
+	lessonId, err := strconv.ParseInt(lessonIDParam(r), 10, 64)
+	if err != nil || lessonId <= 0 {
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Invalid Lesson ID format")
+		return
+	}
+
+	db := database.Get()
+
+	// только своё занятие
+	snapshot := LiveSnapshotEvent{LessonID: lessonId}
+	err = db.QueryRow(`SELECT `+sessionOpenExpr+` FROM lessons WHERE id = ? AND TeacherId = ? AND DeletedAt IS NULL`,
+		lessonId, userData["user_id"]).Scan(&snapshot.IsOpen)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, codeLessonNotFound, "Lesson not found or access denied")
+		return
+	}
+
+	snapshot.Marked, snapshot.Enrolled, err = lessonCounts(db, lessonId)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	// поток живёт дольше WriteTimeout сервера
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		logger.FromContext(r.Context()).Warn("live feed: cannot reset write deadline", "err", err)
+	}
+
+	events, cancel := pubsub.Get().Subscribe(liveTopic(lessonId))
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	data, _ := json.Marshal(snapshot)
+	fmt.Fprintf(w, "event: snapshot\ndata: %s\n\n", data)
+	if err := rc.Flush(); err != nil {
+		logger.FromContext(r.Context()).Error("live feed: streaming not supported", "err", err)
+		return
+	}
+
+	logger.FromContext(r.Context()).Info("live feed subscribed", "login", userData["login"], "lesson_id", lessonId)
+
+	keepAlive := time.NewTicker(liveKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-liveShutdown:
+			return
+		case data, ok := <-events:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "event: mark\ndata: %s\n\n", data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"database/sql"
+	"math"
+	"net"
+	"net/http"
+	"qr_code/internal/config"
+	"qr_code/internal/ratelimit"
+	"strconv"
+	"time"
+)
+
+// лимиты /auth, значения по умолчанию совпадают с config.RateLimit
+var (
+	authLimitStore   ratelimit.Store = ratelimit.NewMemoryStore()
+	authIPLimiter                    = ratelimit.New(authLimitStore, "auth:ip:", 120, 30)
+	authLoginLimiter                 = ratelimit.New(authLimitStore, "auth:login:", 10, 5)
+
+	lockoutThreshold = 5
+	lockoutBase      = time.Minute
+	lockoutMax       = time.Hour
+)
+
+// настройка лимитов из конфига
+func configureRateLimits(cfg config.RateLimit) {
+	authIPLimiter = ratelimit.New(authLimitStore, "auth:ip:", cfg.AuthPerIPPerMinute, cfg.AuthIPBurst)
+	authLoginLimiter = ratelimit.New(authLimitStore, "auth:login:", cfg.AuthPerLoginPerMinute, cfg.AuthLoginBurst)
+	lockoutThreshold = cfg.LockoutThreshold
+	lockoutBase = cfg.LockoutBase
+	lockoutMax = cfg.LockoutMax
+}
+
+// ip клиента без порта
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Retry-After в целых секундах (округление вверх)
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// сколько ещё заблокирован логин (0 - не заблокирован)
+func loginLockedFor(db *sql.DB, login string) (time.Duration, error) {
+	var lockedUntil sql.NullInt64
+	err := db.QueryRow(`SELECT LockedUntil FROM login_failures WHERE Login = ?`, login).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !lockedUntil.Valid {
+		return 0, nil
+	}
+	return max(time.Until(time.Unix(lockedUntil.Int64, 0)), 0), nil
+}
+
+// учёт неудачной попытки. после lockoutThreshold неудач логин блокируется,
+// каждая следующая неудача удваивает срок (до lockoutMax)
+func registerLoginFailure(db *sql.DB, login string) error {
+	now := time.Now()
+
+	var failures int
+	err := db.QueryRow(`
+		INSERT INTO login_failures (Login, Failures, LastFailure) VALUES (?, 1, ?)
+		ON CONFLICT(Login) DO UPDATE SET Failures = Failures + 1, LastFailure = excluded.LastFailure
+		RETURNING Failures`,
+		login, now.Unix(),
+	).Scan(&failures)
+	if err != nil {
+		return err
+	}
+
+	if lockoutThreshold <= 0 || failures < lockoutThreshold {
+		return nil
+	}
+
+	_, err = db.Exec(`UPDATE login_failures SET LockedUntil = ? WHERE Login = ?`, now.Add(lockoutDuration(failures)).Unix(), login)
+	return err
+}
+
+// срок блокировки после failures неудач подряд (failures >= lockoutThreshold).
+// удвоение останавливается у lockoutMax: сдвиг на большое число неудач переполнил бы Duration
+func lockoutDuration(failures int) time.Duration {
+	lock := lockoutBase
+	for i := lockoutThreshold; i < failures; i++ {
+		if lock >= lockoutMax/2 {
+			return lockoutMax
+		}
+		lock *= 2
+	}
+	if lock > lockoutMax || lock <= 0 {
+		return lockoutMax
+	}
+	return lock
+}
+
+// сброс после успешного входа или разблокировки
+func resetLoginFailures(db *sql.DB, login string) error {
+	_, err := db.Exec(`DELETE FROM login_failures WHERE Login = ?`, login)
+	return err
+}
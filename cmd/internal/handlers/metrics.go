@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"qr_code/internal/config"
+	"qr_code/internal/database"
+	"qr_code/internal/metrics"
+)
+
+var (
+	// result: success | failure | locked | rate_limited | no_account | error
+	loginAttempts = metrics.NewCounterVec("qr_login_attempts_total",
+		"Login attempts by result.", "result")
+	// скорость отметок: rate(qr_attendance_marks_total[1m]) * 60
+	attendanceMarks = metrics.NewCounterVec("qr_attendance_marks_total",
+		"Successful attendance marks.")
+	_ = metrics.NewGaugeFunc("qr_active_lessons",
+		"Lessons with an open attendance session.", countActiveLessons)
+)
+
+func countActiveLessons() float64 {
+	var count int
+	err := database.Get().QueryRow(`SELECT COUNT(*) FROM lessons WHERE IsActive = TRUE AND ` + sessionOpenExpr).Scan(&count)
+	if err != nil {
+		slog.Error("counting active lessons failed", "err", err)
+	}
+	return float64(count)
+}
+
+// /metrics; при заданном bearer_token Prometheus должен передать его в Authorization
+func metricsHandler(cfg config.Metrics) http.Handler {
+	next := metrics.Handler()
+	if cfg.BearerToken == "" {
+		return next
+	}
+	expected := []byte("Bearer " + cfg.BearerToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "Not authorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
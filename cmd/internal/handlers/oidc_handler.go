@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"qr_code/internal/authn"
+	"qr_code/internal/cipher"
+	"qr_code/internal/config"
+	"qr_code/internal/cookie"
+	"qr_code/internal/database"
+	"qr_code/internal/logger"
+	"qr_code/internal/oidc"
+	"slices"
+	"strings"
+	"time"
+)
+
+// клиент провайдера; в тестах подменяется
+type oidcClient interface {
+	AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error)
+	Exchange(ctx context.Context, code, verifier, nonce string) (*oidc.Claims, error)
+}
+
+// nil - вход через OIDC выключен
+var (
+	oidcProvider oidcClient
+	oidcConfig   config.OIDC
+)
+
+// cookie с state/nonce/verifier между редиректами
+const (
+	oidcFlowCookie = "oidc_flow"
+	oidcFlowTTL    = 10 * time.Minute
+)
+
+func configureOIDC(cfg config.OIDC) {
+	oidcConfig = cfg
+	if !cfg.Enabled {
+		oidcProvider = nil
+		return
+	}
+	oidcProvider = oidc.NewProvider(oidc.Settings{
+		Issuer:       cfg.Issuer,
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  cfg.RedirectURL,
+		Scopes:       cfg.Scopes,
+		GroupsClaim:  cfg.GroupsClaim,
+	})
+}
+
+// роль по группам провайдера
+func oidcRole(groups []string) string {
+	for _, g := range groups {
+		if slices.Contains(oidcConfig.AdminGroups, g) {
+			return "Admin"
+		}
+	}
+	for _, g := range groups {
+		if slices.Contains(oidcConfig.TeacherGroups, g) {
+			return "Teacher"
+		}
+	}
+	return "Student"
+}
+
+// номер учебной группы из групп провайдера ("student:Д-Э 309" -> "Д-Э 309")
+func oidcStudentGroup(groups []string) string {
+	if oidcConfig.StudentGroupPrefix == "" {
+		return ""
+	}
+	for _, g := range groups {
+		if name, ok := strings.CutPrefix(g, oidcConfig.StudentGroupPrefix); ok && name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// редирект на страницу входа провайдера
+func handler_oidc_login(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if oidcProvider == nil {
+		writeError(w, r, http.StatusNotFound, codeOIDCDisabled, "OIDC login is disabled")
+		return
+	}
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r, "GET")
+		return
+	}
+
+	state, err1 := cipher.RandomToken(16)
+	nonce, err2 := cipher.RandomToken(16)
+	verifier, challenge, err3 := oidc.NewPKCE()
+	if err := errors.Join(err1, err2, err3); err != nil {
+		writeInternalError(w, r, fmt.Errorf("oidc: random: %w", err))
+		return
+	}
+
+	authURL, err := oidcProvider.AuthCodeURL(r.Context(), state, nonce, challenge)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("oidc: login failed", "err", err)
+		writeError(w, r, http.StatusBadGateway, codeIdPUnavailable, "Identity provider is unavailable")
+		return
+	}
+
+	flow, err := cookie.EncryptChallenge(map[string]interface{}{
+		"purpose":  "oidc",
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"exp":      time.Now().Add(oidcFlowTTL).Unix(),
+	})
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("oidc: login: %w", err))
+		return
+	}
+
+	// Lax - cookie должна вернуться при редиректе от провайдера.
+	// путь "/": callback бывает и по старому пути, и под /api/v1
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    flow,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(oidcFlowTTL.Seconds()),
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// возврат от провайдера: обмен кода, сопоставление пользователя, второй фактор, выдача сессии
+func handler_oidc_callback(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if oidcProvider == nil {
+		writeError(w, r, http.StatusNotFound, codeOIDCDisabled, "OIDC login is disabled")
+		return
+	}
+
+	// flow cookie одноразовая
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		MaxAge:   -1,
+	})
+
+	q := r.URL.Query()
+	if idpError := q.Get("error"); idpError != "" {
+		logger.FromContext(r.Context()).Warn("oidc: provider returned error", "error", idpError)
+		writeError(w, r, http.StatusUnauthorized, codeIdPLoginRejected, "Login was rejected by the identity provider")
+		return
+	}
+
+	flowCookie, err := r.Cookie(oidcFlowCookie)
+	var flow map[string]interface{}
+	if err == nil {
+		flow, err = parseLoginChallenge(flowCookie.Value, "oidc")
+	}
+	if err != nil || q.Get("state") == "" || flow["state"] != q.Get("state") {
+		writeError(w, r, http.StatusBadRequest, codeOIDCStateInvalid, "Invalid or expired login state, start again")
+		return
+	}
+
+	verifier, _ := flow["verifier"].(string)
+	nonce, _ := flow["nonce"].(string)
+	claims, err := oidcProvider.Exchange(r.Context(), q.Get("code"), verifier, nonce)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("oidc: exchange failed", "err", err)
+		writeError(w, r, http.StatusUnauthorized, codeIdPLoginFailed, "Identity provider login failed")
+		return
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		writeError(w, r, http.StatusForbidden, codeIdPEmailUnverified, "Identity provider did not return a verified email")
+		return
+	}
+
+	email := strings.ToLower(claims.Email)
+
+	// совпавшая по подтверждённой почте локальная запись входит со своей ролью и группой
+	identity, err := authn.UpsertExternalUser(database.Get(), authn.ExternalUser{
+		Provider: "oidc",
+		Subject:  claims.Subject,
+		Login:    email,
+		Email:    email,
+		// проверено выше, authn повторно требует подтверждения для привязки
+		EmailVerified: claims.EmailVerified,
+		FullName:      claims.Name,
+		Role:          oidcRole(claims.Groups),
+		GroupName:     oidcStudentGroup(claims.Groups),
+	}, oidcConfig.JITProvisioning, true)
+	if err == authn.ErrNoAccount {
+		logger.FromContext(r.Context()).Warn("oidc: no account", "email", email)
+		writeError(w, r, http.StatusForbidden, codeNoAccount, "No account is registered for this user")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("oidc: user mapping: %w", err))
+		return
+	}
+
+	// тот же второй фактор, что при входе по паролю. callback открывает браузер,
+	// поэтому challenge уходит фронтенду во фрагменте адреса, а не JSON-ответом
+	code, challenge, err := secondFactorChallenge(identity.UserID, identity.Login, identity.FullName, identity.Role, identity.GroupID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if code != "" {
+		logger.FromContext(r.Context()).Info("first factor accepted, second factor pending", "user_id", identity.UserID, "login", identity.Login, "role", identity.Role)
+		http.Redirect(w, r, oidcChallengeRedirect(code, challenge), http.StatusFound)
+		return
+	}
+
+	if err := issueSession(w, r, identity.UserID, identity.Login, identity.FullName, identity.Role, identity.GroupID); err != nil {
+		writeInternalError(w, r, fmt.Errorf("creating session: %w", err))
+		return
+	}
+	http.Redirect(w, r, oidcConfig.PostLoginRedirect, http.StatusFound)
+}
+
+// адрес фронтенда с challenge второго фактора во фрагменте: фрагмент не уходит на сервер и в логи
+func oidcChallengeRedirect(code, challenge string) string {
+	target, _, _ := strings.Cut(oidcConfig.PostLoginRedirect, "#")
+	return target + "#" + url.Values{"code": {code}, "challenge": {challenge}}.Encode()
+}
@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"qr_code/internal/cipher"
+	"qr_code/internal/config"
+	"qr_code/internal/database"
+	"qr_code/internal/i18n"
+	"qr_code/internal/logger"
+	"qr_code/internal/notify"
+	"qr_code/internal/password"
+	"qr_code/internal/ratelimit"
+	"qr_code/internal/utils"
+	"time"
+)
+
+// запрос смены пароля
+type PasswordChangeRequest struct {
+	OldPassword string `json:"oldPassword"`
+	NewPassword string `json:"newPassword"`
+}
+
+// запрос ссылки сброса
+type PasswordResetRequest struct {
+	Login string `json:"login"`
+}
+
+// установка нового пароля по токену из письма
+type PasswordResetConfirmRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
+type PasswordResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// настройки паролей, значения по умолчанию совпадают с config.Password
+var (
+	passwordPolicy = password.DefaultPolicy
+	resetTokenTTL  = time.Hour
+	resetURL       = "http://localhost/reset-password?token="
+
+	// отдельный лимит писем на логин: не больше 3 подряд, дальше 1 в минуту
+	resetLoginLimiter = ratelimit.New(authLimitStore, "reset:login:", 1, 3)
+)
+
+func configurePasswords(cfg config.Password) {
+	passwordPolicy.MinLength = cfg.MinLength
+	resetTokenTTL = cfg.ResetTokenTTL
+	resetURL = cfg.ResetURL
+}
+
+// одинаковый ответ для существующих и несуществующих логинов
+const resetRequestedMessage = "If the account exists and has an email, a reset link has been sent"
+
+// письмо со ссылкой сброса: логин, срок действия, ссылка и токен
+const (
+	resetEmailSubject = "Password reset"
+	resetEmailBody    = "A password reset was requested for login %s.\n\n" +
+		"Open the link to set a new password (valid for %s):\n%s%s\n\n" +
+		"If you did not request it, ignore this message."
+)
+
+func handler_password_change(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r, "POST")
+		return
+	}
+	// только cookie сессии, API токеном нельзя
+	userData, err := authenticate(r, scopeSession)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	var changeRequest PasswordChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&changeRequest); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON format")
+		return
+	}
+
+	login, _ := userData["login"].(string)
+
+	// подбор старого пароля ограничен так же, как /auth
+	if ok, wait := authLoginLimiter.Allow(login); !ok {
+		setRetryAfter(w, wait)
+		writeError(w, r, http.StatusTooManyRequests, codeRateLimited, "Too many requests, try again later")
+		return
+	}
+
+	if err := passwordPolicy.Validate(changeRequest.NewPassword, login); err != nil {
+		writeError(w, r, http.StatusBadRequest, codePasswordPolicy, policyMessage(r, err))
+		return
+	}
+	if changeRequest.NewPassword == changeRequest.OldPassword {
+		writeError(w, r, http.StatusBadRequest, codePasswordPolicy, "New password must differ from the old one")
+		return
+	}
+
+	db := database.Get()
+	result, err := db.Exec(`UPDATE user SET PassHash = ? WHERE id = ? AND PassHash = ?`,
+		password.Hash(changeRequest.NewPassword), userData["user_id"], password.Hash(changeRequest.OldPassword))
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		writeError(w, r, http.StatusForbidden, codeOldPasswordIncorrect, "Old password is incorrect")
+		return
+	}
+
+	// неиспользованные ссылки сброса больше не нужны
+	if _, err := db.Exec(`UPDATE password_resets SET UsedAt = ? WHERE UserId = ? AND UsedAt IS NULL`,
+		time.Now().Unix(), userData["user_id"]); err != nil {
+		logger.FromContext(r.Context()).Error("invalidating reset tokens failed", "err", err)
+	}
+
+	logger.FromContext(r.Context()).Info("password changed", "login", login)
+	response := PasswordResponse{
+		Success: true,
+		Message: tr(r, "Password changed successfully"),
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func handler_password_reset_request(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r, "POST")
+		return
+	}
+
+	// лимит по ip, чтобы не рассылать письма пачками
+	if ok, wait := authIPLimiter.Allow(clientIP(r)); !ok {
+		setRetryAfter(w, wait)
+		writeError(w, r, http.StatusTooManyRequests, codeRateLimited, "Too many requests, try again later")
+		return
+	}
+
+	var resetRequest PasswordResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&resetRequest); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON format")
+		return
+	}
+	if !utils.IsSafeString(resetRequest.Login) {
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Login contains invalid characters. Only letters, numbers, @, ., -, _ are allowed (3-50 characters)")
+		return
+	}
+	login := utils.CleanString(resetRequest.Login)
+
+	// и лимит по логину
+	if ok, wait := resetLoginLimiter.Allow(login); !ok {
+		setRetryAfter(w, wait)
+		writeError(w, r, http.StatusTooManyRequests, codeRateLimited, "Too many requests, try again later")
+		return
+	}
+
+	db := database.Get()
+
+	var userID int64
+	var email, language sql.NullString
+	err := db.QueryRow(`SELECT id, Email, Language FROM user WHERE Login = ? AND PassHash != ''`, login).Scan(&userID, &email, &language)
+	if err != nil && err != sql.ErrNoRows {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	// существование логина наружу не раскрываем
+	if err == sql.ErrNoRows || email.String == "" {
+		logger.FromContext(r.Context()).Info("password reset requested for unknown login or login without email", "login", login)
+		json.NewEncoder(w).Encode(PasswordResponse{
+			Success: true,
+			Message: tr(r, resetRequestedMessage),
+		})
+		return
+	}
+
+	token, err := cipher.RandomToken(32)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	now := time.Now()
+	_, err = db.Exec(`
+		INSERT INTO password_resets (TokenHash, UserId, CreatedAt, ExpiresAt)
+		VALUES (?, ?, ?, ?)`,
+		cipher.SHA256(token), userID, now.Unix(), now.Add(resetTokenTTL).Unix(),
+	)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	// отправка в фоне: smtp может отвечать дольше таймаута запроса
+	// письмо на языке из настроек пользователя, иначе на языке запроса
+	lang := i18n.FromContext(r.Context())
+	if i18n.Supported(language.String) {
+		lang = language.String
+	}
+	subject := i18n.T(lang, resetEmailSubject)
+	body := i18n.Tf(lang, resetEmailBody, login, resetTokenTTL, resetURL, token)
+	go func(to string) {
+		if err := notify.Get().Send(to, subject, body); err != nil {
+			logger.FromContext(r.Context()).Error("sending reset email failed", "user_id", userID, "err", err)
+		}
+	}(email.String)
+
+	logger.FromContext(r.Context()).Info("password reset requested", "login", login)
+	json.NewEncoder(w).Encode(PasswordResponse{
+		Success: true,
+		Message: tr(r, resetRequestedMessage),
+	})
+}
+
+func handler_password_reset_confirm(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r, "POST")
+		return
+	}
+
+	if ok, wait := authIPLimiter.Allow(clientIP(r)); !ok {
+		setRetryAfter(w, wait)
+		writeError(w, r, http.StatusTooManyRequests, codeRateLimited, "Too many requests, try again later")
+		return
+	}
+
+	var confirmRequest PasswordResetConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&confirmRequest); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON format")
+		return
+	}
+	if confirmRequest.Token == "" {
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Reset token is required")
+		return
+	}
+
+	db := database.Get()
+	now := time.Now().Unix()
+	tokenHash := cipher.SHA256(confirmRequest.Token)
+
+	var userID int64
+	var login string
+	err := db.QueryRow(`
+		SELECT user.id, user.Login FROM password_resets
+		JOIN user ON user.id = password_resets.UserId
+		WHERE password_resets.TokenHash = ? AND password_resets.UsedAt IS NULL AND password_resets.ExpiresAt > ?`,
+		tokenHash, now,
+	).Scan(&userID, &login)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusBadRequest, codeResetTokenInvalid, "Invalid or expired reset token")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	if err := passwordPolicy.Validate(confirmRequest.NewPassword, login); err != nil {
+		writeError(w, r, http.StatusBadRequest, codePasswordPolicy, policyMessage(r, err))
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	// токен одноразовый: при параллельных запросах пройдёт только один
+	result, err := tx.Exec(`UPDATE password_resets SET UsedAt = ? WHERE TokenHash = ? AND UsedAt IS NULL`, now, tokenHash)
+	if err == nil {
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			err = sql.ErrNoRows
+		}
+	}
+	if err == nil {
+		_, err = tx.Exec(`UPDATE user SET PassHash = ? WHERE id = ?`, password.Hash(confirmRequest.NewPassword), userID)
+	}
+	if err == nil {
+		// остальные ссылки этого пользователя тоже гасим
+		_, err = tx.Exec(`UPDATE password_resets SET UsedAt = ? WHERE UserId = ? AND UsedAt IS NULL`, now, userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			writeError(w, r, http.StatusBadRequest, codeResetTokenInvalid, "Invalid or expired reset token")
+			return
+		}
+		writeInternalError(w, r, err)
+		return
+	}
+
+	// новый пароль снимает блокировку входа
+	if err := resetLoginFailures(db, login); err != nil {
+		logger.FromContext(r.Context()).Error("lockout reset failed", "err", err)
+	}
+	authLoginLimiter.Reset(login)
+
+	logger.FromContext(r.Context()).Info("password reset completed", "login", login)
+	json.NewEncoder(w).Encode(PasswordResponse{
+		Success: true,
+		Message: tr(r, "Password has been reset"),
+	})
+}
+
+// нарушение политики паролей на языке запроса
+func policyMessage(r *http.Request, err error) string {
+	reason := tr(r, err.Error())
+	switch {
+	case errors.Is(err, password.ErrTooShort):
+		reason = trf(r, "password is too short: minimum %d characters", passwordPolicy.MinLength)
+	case errors.Is(err, password.ErrTooLong):
+		reason = trf(r, "password is too long: maximum %d characters", passwordPolicy.MaxLength)
+	}
+	return trf(r, "New password does not meet policy: %s", reason)
+}
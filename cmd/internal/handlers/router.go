@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"net/http"
+	"qr_code/internal/apidoc"
+	"qr_code/internal/server"
+)
+
+// маршруты API. метод указан в шаблоне: на чужой метод ServeMux сам отвечает 405 с Allow
+func newRouter() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	// проверки для оркестратора
+	mux.HandleFunc("GET "+server.HealthPath, handler_healthz)
+	mux.HandleFunc("GET "+server.ReadyPath, handler_readyz)
+
+	// описание API и страница документации
+	mux.HandleFunc("GET /api/v1/openapi.yaml", apidoc.ServeYAML)
+	mux.HandleFunc("GET /api/v1/openapi.json", apidoc.ServeJSON)
+	mux.HandleFunc("GET /api/docs", apidoc.ServeDocs)
+
+	// v1: ресурсные пути
+	mux.HandleFunc("POST /api/v1/auth/login", handler_auth)
+	mux.HandleFunc("POST /api/v1/auth/totp", handler_auth_totp)
+	mux.HandleFunc("POST /api/v1/auth/logout", LogoutHandler)
+	mux.HandleFunc("GET /api/v1/auth/oidc/login", handler_oidc_login)
+	mux.HandleFunc("GET /api/v1/auth/oidc/callback", handler_oidc_callback)
+	mux.HandleFunc("POST /api/v1/totp/enroll", handler_totp_enroll)
+	mux.HandleFunc("POST /api/v1/totp/confirm", handler_totp_confirm)
+	mux.HandleFunc("POST /api/v1/totp/disable", handler_totp_disable)
+	// занятия преподавателя
+	mux.HandleFunc("GET /api/v1/lessons", handler_teacher_getinfo)
+	mux.HandleFunc("POST /api/v1/lessons", handler_lessons_create)
+	mux.HandleFunc("GET /api/v1/lessons/{id}", handler_teacher_getlesson)
+	mux.HandleFunc("PATCH /api/v1/lessons/{id}", handler_lessons_update)
+	mux.HandleFunc("GET /api/v1/lessons/{id}/history", handler_lessons_history)
+	mux.HandleFunc("POST /api/v1/lessons/{id}/open", handler_lessons_open)
+	mux.HandleFunc("POST /api/v1/lessons/{id}/close", handler_lessons_close)
+	mux.HandleFunc("POST /api/v1/lessons/{id}/archive", handler_archive_add)
+	mux.HandleFunc("POST /api/v1/lessons/{id}/unarchive", handler_archive_restore)
+	mux.HandleFunc("GET /api/v1/lessons/{id}/live", handler_lessons_live)
+	mux.HandleFunc("GET /api/v1/lessons/{id}/export", handler_export_attendances)
+	// архив
+	mux.HandleFunc("GET /api/v1/archive", handler_archive_getlessons)
+	mux.HandleFunc("DELETE /api/v1/archive/{id}", handler_archive_deleteLesson)
+	mux.HandleFunc("POST /api/v1/archive/bulk", handler_archive_bulk)
+
+	mux.HandleFunc("GET /api/v1/trash", handler_trash_getlessons)
+	mux.HandleFunc("POST /api/v1/trash/{id}/restore", handler_trash_restore)
+	mux.HandleFunc("DELETE /api/v1/trash/{id}", handler_trash_purge)
+	// отметка студента по QR (GET - переход по ссылке из QR кода)
+	mux.HandleFunc("POST /api/v1/attendance", handler_lessons_mark)
+	mux.HandleFunc("GET /api/v1/student", handler_student_getinfo)
+	// пароль
+	mux.HandleFunc("POST /api/v1/password/change", handler_password_change)
+	mux.HandleFunc("POST /api/v1/password/reset/request", handler_password_reset_request)
+	mux.HandleFunc("POST /api/v1/password/reset/confirm", handler_password_reset_confirm)
+	// API токены
+	mux.HandleFunc("GET /api/v1/tokens", handler_tokens_list)
+	mux.HandleFunc("POST /api/v1/tokens", handler_tokens_create)
+	mux.HandleFunc("DELETE /api/v1/tokens/{id}", handler_tokens_revoke)
+	// настройки пользователя
+	mux.HandleFunc("GET /api/v1/settings/language", handler_settings_language)
+	mux.HandleFunc("PUT /api/v1/settings/language", handler_settings_language)
+	// admin
+	mux.HandleFunc("POST /api/v1/admin/unlock", handler_admin_unlock)
+
+	// старые пути для уже выпущенных клиентов
+	mux.HandleFunc("POST /auth", handler_auth)
+	mux.HandleFunc("POST /auth/totp", handler_auth_totp)
+	mux.HandleFunc("GET /auth/oidc/login", handler_oidc_login)
+	mux.HandleFunc("GET /auth/oidc/callback", handler_oidc_callback)
+	mux.HandleFunc("POST /totp/enroll", handler_totp_enroll)
+	mux.HandleFunc("POST /totp/confirm", handler_totp_confirm)
+	mux.HandleFunc("POST /totp/disable", handler_totp_disable)
+	mux.HandleFunc("POST /lessons/create", handler_lessons_create)
+	mux.HandleFunc("GET /lessons/mark", handler_lessons_mark)
+	mux.HandleFunc("POST /lessons/mark", handler_lessons_mark)
+	mux.HandleFunc("POST /lessons/open", handler_lessons_open)
+	mux.HandleFunc("POST /lessons/close", handler_lessons_close)
+	mux.HandleFunc("GET /lessons/live", handler_lessons_live)
+	mux.HandleFunc("GET /teacher/getInfo", handler_teacher_getinfo)
+	mux.HandleFunc("GET /teacher/getLesson", handler_teacher_getlesson)
+	mux.HandleFunc("GET /teacher/export", handler_export_attendances)
+	mux.HandleFunc("GET /archive/getLessons", handler_archive_getlessons)
+	mux.HandleFunc("POST /archive/deleteLesson", handler_archive_deleteLesson)
+	mux.HandleFunc("DELETE /archive/deleteLesson", handler_archive_deleteLesson)
+	mux.HandleFunc("POST /archive/add", handler_archive_add)
+	mux.HandleFunc("GET /student/getInfo", handler_student_getinfo)
+	mux.HandleFunc("POST /password/change", handler_password_change)
+	mux.HandleFunc("POST /password/reset/request", handler_password_reset_request)
+	mux.HandleFunc("POST /password/reset/confirm", handler_password_reset_confirm)
+	mux.HandleFunc("POST /tokens/create", handler_tokens_create)
+	mux.HandleFunc("GET /tokens/list", handler_tokens_list)
+	mux.HandleFunc("POST /tokens/revoke", handler_tokens_revoke)
+	mux.HandleFunc("POST /admin/unlock", handler_admin_unlock)
+	mux.HandleFunc("/logout", LogoutHandler)
+
+	return mux
+}
+
+// id занятия: из пути /api/v1/lessons/{id} или из ?lessonId= старых маршрутов
+func lessonIDParam(r *http.Request) string {
+	if id := r.PathValue("id"); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("lessonId")
+}
@@ -3,8 +3,6 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
-	"qr_code/internal/cookie"
-	"qr_code/internal/cors"
 )
 
 type StudentInfoResponse struct {
@@ -16,60 +14,30 @@ type StudentInfoResponse struct {
 
 func handler_student_getinfo(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	cors.SetCORSHeaders(&w, r)
-	// 200
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
 	if r.Method != "GET" {
-		response := StudentInfoResponse{
-			Success: false,
-			Message: "Only GET method allowed",
-		}
-		json.NewEncoder(w).Encode(response)
-		return
-	}
-	// cookie check
-	sessionCookie, err := r.Cookie("session")
-	if err != nil {
-		response := StudentInfoResponse{
-			Success: false,
-			Message: "Not authorized",
-		}
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(response)
+		writeMethodNotAllowed(w, r, "GET")
 		return
 	}
-
-	// valid cookie check
-	userData, err := cookie.DecryptCookie(sessionCookie.Value)
+	// cookie сессии или Bearer токен
+	userData, err := authenticate(r, scopeRead)
 	if err != nil {
-		response := StudentInfoResponse{
-			Success: false,
-			Message: "Invalid session: " + err.Error(),
-		}
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(response)
+		writeAPIError(w, r, err)
 		return
 	}
 
 	// role check
 	if userData["role"] != "Student" {
-		response := StudentInfoResponse{
-			Success: false,
-			Message: "Access denied",
-		}
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusForbidden, codeForbidden, "Access denied")
 		return
 	}
 
+	fullName, _ := userData["full_name"].(string)
+	groupID, _ := userData["group_id"].(float64)
 	response := StudentInfoResponse{
 		Success:  true,
-		Message:  "Profile uploaded successfully",
-		FullName: userData["full_name"].(string),
-		GroupID:  userData["group_id"].(float64),
+		Message:  tr(r, "Profile uploaded successfully"),
+		FullName: fullName,
+		GroupID:  groupID,
 	}
 	json.NewEncoder(w).Encode(response)
 }
@@ -3,42 +3,53 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
-	"fmt"
-	"log"
 	"net/http"
-	"qr_code/internal/cookie"
-	"qr_code/internal/cors"
 	"qr_code/internal/database"
 	"strconv"
+	"time"
 )
 
 type Lesson struct {
-	ID         int    `json:"id"`
-	NameLesson string `json:"name_lesson"`
-	Date       string `json:"date"`
-	TypeLes    string `json:"type_les"`
-	QrToken    string `json:"qr_token"`
-	IsActive   bool   `json:"is_active"`
-	TeacherId  int    `json:"teacher_id"`
+	ID           int        `json:"id"`
+	NameLesson   string     `json:"name_lesson"`
+	Date         string     `json:"date"`
+	TypeLes      string     `json:"type_les"`
+	QrToken      string     `json:"qr_token"`
+	IsActive     bool       `json:"is_active"`
+	TeacherId    int        `json:"teacher_id"`
+	SessionStart *time.Time `json:"session_start"`
+	SessionEnd   *time.Time `json:"session_end"`
+	IsOpen       bool       `json:"is_open"`
+	// только в корзине
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
+// колонки занятия в порядке полей Lesson
+const lessonColumns = `id, NameLesson, Date, TypeLes, QrToken, IsActive, TeacherId, SessionStart, SessionEnd, ` + sessionOpenExpr
+
 type TeacherInfoResponse struct {
 	Success  bool     `json:"success"`
 	Message  string   `json:"message"`
 	FullName string   `json:"fullname"`
 	Lessons  []Lesson `json:"lessons"`
+	// всего занятий по фильтрам; next_cursor - для следующей страницы, пустой на последней
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 type TeacherGetLessonResponse struct {
-	Success  bool     `json:"success"`
-	Message  string   `json:"message"`
-	ID         int    `json:"id"`
-	NameLesson string `json:"name_lesson"`
-	Date       string `json:"date"`
-	TypeLes    string `json:"type_les"`
-	QrToken    string `json:"qr_token"`
-	IsActive   bool   `json:"is_active"`
-	TeacherId  int    `json:"teacher_id"`
+	Success      bool       `json:"success"`
+	Message      string     `json:"message"`
+	ID           int        `json:"id"`
+	NameLesson   string     `json:"name_lesson"`
+	Date         string     `json:"date"`
+	TypeLes      string     `json:"type_les"`
+	QrToken      string     `json:"qr_token"`
+	IsActive     bool       `json:"is_active"`
+	TeacherId    int        `json:"teacher_id"`
+	SessionStart *time.Time `json:"session_start"`
+	SessionEnd   *time.Time `json:"session_end"`
+	IsOpen       bool       `json:"is_open"`
 }
 
 // ответ
@@ -49,298 +60,158 @@ type GetAttendancesResponse struct {
 
 func handler_teacher_getinfo(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	cors.SetCORSHeaders(&w, r)
-	// 200
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
 	if r.Method != "GET" {
-		response := TeacherInfoResponse{
-			Success: false,
-			Message: "Only GET method allowed",
-		}
-		json.NewEncoder(w).Encode(response)
+		writeMethodNotAllowed(w, r, "GET")
 		return
 	}
-	// cookie check
-	sessionCookie, err := r.Cookie("session")
+	// cookie сессии или Bearer токен
+	userData, err := authenticate(r, scopeRead)
 	if err != nil {
-		response := TeacherInfoResponse{
-			Success: false,
-			Message: "Not authorized",
-		}
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(response)
-		return
-	}
-
-	// valid cookie check
-	userData, err := cookie.DecryptCookie(sessionCookie.Value)
-	if err != nil {
-		response := TeacherInfoResponse{
-			Success: false,
-			Message: "Invalid session: " + err.Error(),
-		}
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(response)
+		writeAPIError(w, r, err)
 		return
 	}
 
 	// role check
 	if userData["role"] != "Teacher" {
-		response := TeacherInfoResponse{
-			Success: false,
-			Message: "Access denied",
-		}
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusForbidden, codeForbidden, "Access denied")
 		return
 	}
 
-	db := database.Get()
-
-	// get all teacher lessons
-	rows, err := db.Query(`SELECT * FROM lessons WHERE TeacherId = ? AND IsActive = TRUE`, userData["user_id"])
+	query, err := parseLessonListQuery(r)
 	if err != nil {
-		response := TeacherInfoResponse{
-			Success: false,
-			Message: "Database error: " + err.Error(),
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+		writeAPIError(w, r, err)
 		return
 	}
-	defer rows.Close()
-
-	var lessons []Lesson
-
-	// read all
-	for rows.Next() {
-		var lesson Lesson
-		err := rows.Scan(&lesson.ID, &lesson.NameLesson, &lesson.Date, &lesson.TypeLes, &lesson.QrToken, &lesson.IsActive, &lesson.TeacherId)
-		if err != nil {
-			log.Printf("Error scanning lesson: %v\n", err)
-			continue
-		}
-		lessons = append(lessons, lesson)
-	}
 
-	// check error iterations
-	if err = rows.Err(); err != nil {
-		response := TeacherInfoResponse{
-			Success: false,
-			Message: "Error reading lessons: " + err.Error(),
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+	lessons, total, nextCursor, err := queryLessons(database.Get(), userData["user_id"], lessonsActive, query)
+	if err != nil {
+		writeInternalError(w, r, err)
 		return
 	}
+	fullName, _ := userData["full_name"].(string)
 	response := TeacherInfoResponse{
-		Success:  true,
-		Message:  "Lessons retrieved successfully",
-		FullName: userData["full_name"].(string),
-		Lessons:  lessons,
+		Success:    true,
+		Message:    tr(r, "Lessons retrieved successfully"),
+		FullName:   fullName,
+		Lessons:    lessons,
+		Total:      total,
+		NextCursor: nextCursor,
 	}
 	json.NewEncoder(w).Encode(response)
 }
 
 func handler_teacher_getlesson(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	cors.SetCORSHeaders(&w, r)
-
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
 	if r.Method != "GET" {
-		response := TeacherGetLessonResponse{
-			Success: false,
-			Message: "Only GET method allowed",
-		}
-		json.NewEncoder(w).Encode(response)
+		writeMethodNotAllowed(w, r, "GET")
 		return
 	}
 
-	sessionCookie, err := r.Cookie("session")
+	// cookie сессии или Bearer токен
+	userData, err := authenticate(r, scopeRead)
 	if err != nil {
-		response := TeacherGetLessonResponse{
-			Success: false,
-			Message: "Not authorized",
-		}
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(response)
+		writeAPIError(w, r, err)
 		return
 	}
 
-	// valid cookie check
-	_, err = cookie.DecryptCookie(sessionCookie.Value)
-	if err != nil {
-		response := TeacherGetLessonResponse{
-			Success: false,
-			Message: "Invalid session: " + err.Error(),
-		}
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(response)
+	// role check: в ответе текущий QR код, его видит только владелец занятия
+	if userData["role"] != "Teacher" {
+		writeError(w, r, http.StatusForbidden, codeForbidden, "Access denied")
 		return
 	}
 
-	// role check
-	/*if userData["role"] != "Teacher" {
-		response := TeacherInfoResponse{
-			Success: false,
-			Message: "Access denied",
-		}
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(response)
-		return
-	}*/
-
 	// проверка get параметра
-	lessonIdParam := r.URL.Query().Get("lessonId")
+	lessonIdParam := lessonIDParam(r)
 	if lessonIdParam == "" {
-		response := TeacherGetLessonResponse{
-			Success: false,
-			Message: "Lesson ID is required",
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Lesson ID is required")
 		return
 	}
 	// конвертация в int
 	lessonId, err := strconv.Atoi(lessonIdParam)
 	if err != nil {
-		response := TeacherGetLessonResponse{
-			Success: false,
-			Message: "Invalid Lesson ID format",
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Invalid Lesson ID format")
 		return
 	}
 	if lessonId <= 0 {
-		response := TeacherGetLessonResponse{
-			Success: false,
-			Message: "Lesson ID must be positive number",
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Lesson ID must be positive number")
 		return
 	}
 	// логика бд
 	db := database.Get()
 
 	var lesson TeacherGetLessonResponse
-	err = db.QueryRow(`SELECT * FROM lessons WHERE id = ?`, lessonId).Scan(
+	err = db.QueryRow(`SELECT `+lessonColumns+` FROM lessons WHERE id = ? AND TeacherId = ? AND DeletedAt IS NULL`,
+		lessonId, userData["user_id"]).Scan(
 		&lesson.ID, &lesson.NameLesson, &lesson.Date, &lesson.TypeLes, &lesson.QrToken, &lesson.IsActive, &lesson.TeacherId,
+		&lesson.SessionStart, &lesson.SessionEnd, &lesson.IsOpen,
 	)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			response := TeacherGetLessonResponse{
-				Success: false,
-				Message: "Lesson not found",
-			}
-			w.WriteHeader(http.StatusNotFound)
-			json.NewEncoder(w).Encode(response)
+			writeError(w, r, http.StatusNotFound, codeLessonNotFound, "Lesson not found or access denied")
 			return
 		}
-		
-		response := TeacherGetLessonResponse{
-			Success: false,
-			Message: "Database error: " + err.Error(),
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+
+		writeInternalError(w, r, err)
 		return
 	}
 
 	lesson.Success = true
-	lesson.Message = "Lesson retrieved successfully"
-	
+	lesson.Message = tr(r, "Lesson retrieved successfully")
+
 	json.NewEncoder(w).Encode(lesson)
 }
 
-func handler_export_attendances(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	cors.SetCORSHeaders(&w, r)
+// заголовок колонки выгрузки: key - поле в data
+type ExportColumn struct {
+	Key   string `json:"key"`
+	Title string `json:"title"`
+}
 
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-	if r.Method != "GET" {
-		response := TeacherInfoResponse{
-			Success: false,
-			Message: "Only GET method allowed",
-		}
-		json.NewEncoder(w).Encode(response)
-		return
+// заголовки колонок выгрузки на языке запроса
+func exportColumns(r *http.Request) []ExportColumn {
+	return []ExportColumn{
+		{Key: "fullName", Title: tr(r, "Full name")},
+		{Key: "groupId", Title: tr(r, "Group")},
+		{Key: "status", Title: tr(r, "Status")},
+		{Key: "confirmedDate", Title: tr(r, "Marked at")},
 	}
+}
 
-	sessionCookie, err := r.Cookie("session")
-	if err != nil {
-		response := TeacherInfoResponse{
-			Success: false,
-			Message: "Not authorized",
-		}
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(response)
+func handler_export_attendances(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r, "GET")
 		return
 	}
 
-	// valid cookie check
-	userData, err := cookie.DecryptCookie(sessionCookie.Value)
+	// cookie сессии или Bearer токен
+	userData, err := authenticate(r, scopeExport)
 	if err != nil {
-		response := TeacherInfoResponse{
-			Success: false,
-			Message: "Invalid session: " + err.Error(),
-		}
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(response)
+		writeAPIError(w, r, err)
 		return
 	}
 
 	// role check
 	if userData["role"] != "Teacher" {
-		response := TeacherInfoResponse{
-			Success: false,
-			Message: "Access denied",
-		}
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusForbidden, codeForbidden, "Access denied")
 		return
 	}
 
 	// проверка get параметра
-	lessonIdParam := r.URL.Query().Get("lessonId")
+	lessonIdParam := lessonIDParam(r)
 	if lessonIdParam == "" {
-		response := GetAttendancesResponse{
-			Success: false,
-			Message: "Lesson ID is required",
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Lesson ID is required")
 		return
 	}
 	// конвертация в int
 	lessonId, err := strconv.Atoi(lessonIdParam)
 	if err != nil {
-		response := GetAttendancesResponse{
-			Success: false,
-			Message: "Invalid Lesson ID format",
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Invalid Lesson ID format")
 		return
 	}
 	if lessonId <= 0 {
-		response := GetAttendancesResponse{
-			Success: false,
-			Message: "Lesson ID must be positive number",
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Lesson ID must be positive number")
 		return
 	}
 	// логика бд
@@ -352,17 +223,11 @@ func handler_export_attendances(w http.ResponseWriter, r *http.Request) {
 	err = db.QueryRow(`
 		SELECT TeacherId, NameLesson 
 		FROM lessons 
-		WHERE id = ? AND TeacherId = ?
-	`, lessonId, int(userData["user_id"].(float64))).Scan(&teacherID, &lessonName)
-	fmt.Printf("[export] teacherID: %d, lessonName: %s\n", teacherID, lessonName)
+		WHERE id = ? AND TeacherId = ? AND DeletedAt IS NULL
+	`, lessonId, userData["user_id"]).Scan(&teacherID, &lessonName)
 
 	if err != nil {
-		response := GetAttendancesResponse{
-			Success: false,
-			Message: "Lesson not found or access denied",
-		}
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, http.StatusNotFound, codeLessonNotFound, "Lesson not found or access denied")
 		return
 	}
 	// запрос на экспорт студентов которые посетили пару
@@ -379,12 +244,7 @@ func handler_export_attendances(w http.ResponseWriter, r *http.Request) {
 	`, lessonId)
 
 	if err != nil {
-		response := GetAttendancesResponse{
-			Success: false,
-			Message: "Database error: " + err.Error(),
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+		writeInternalError(w, r, err)
 		return
 	}
 	defer rows.Close()
@@ -411,9 +271,9 @@ func handler_export_attendances(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		statusText := "Присутствовал"
+		statusText := tr(r, "Present")
 		if status == 0 {
-			statusText = "Отсутствовал"
+			statusText = tr(r, "Absent")
 		}
 		
 		dateText := ""
@@ -437,13 +297,15 @@ func handler_export_attendances(w http.ResponseWriter, r *http.Request) {
 		Message    string             `json:"message"`
 		LessonName string             `json:"lessonName"`
 		LessonId   int                `json:"lessonId"`
+		Columns    []ExportColumn     `json:"columns"`
 		Data       []AttendanceExport `json:"data"`
 		Count      int                `json:"count"`
 	}{
 		Success:    true,
-		Message:    "Attendances exported successfully",
+		Message:    tr(r, "Attendances exported successfully"),
 		LessonName: lessonName,
 		LessonId:   lessonId,
+		Columns:    exportColumns(r),
 		Data:       attendances,
 		Count:      len(attendances),
 	}
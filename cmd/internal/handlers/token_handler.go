@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"qr_code/internal/cipher"
+	"qr_code/internal/database"
+	"qr_code/internal/logger"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// срок жизни токена в днях
+const (
+	defaultTokenDays = 90
+	maxTokenDays     = 365
+)
+
+type APITokenCreateRequest struct {
+	Name          string `json:"name"`
+	Scope         string `json:"scope"`
+	ExpiresInDays int    `json:"expiresInDays"`
+}
+
+type APITokenRevokeRequest struct {
+	ID int64 `json:"id"`
+}
+
+type APIToken struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Scope      string     `json:"scope"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+type APITokenResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	// сам токен показывается один раз, при создании
+	Token  string     `json:"token,omitempty"`
+	Tokens []APIToken `json:"tokens,omitempty"`
+}
+
+// выпуск токена; только из браузерной сессии
+func handler_tokens_create(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r, "POST")
+		return
+	}
+
+	userData, err := authenticate(r, scopeSession)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	var createRequest APITokenCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&createRequest); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON format")
+		return
+	}
+
+	name := strings.TrimSpace(createRequest.Name)
+	if name == "" || utf8.RuneCountInString(name) > 100 {
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Token name is required (up to 100 characters)")
+		return
+	}
+	if _, ok := scopeRank[createRequest.Scope]; !ok {
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Scope must be one of: read, export, manage")
+		return
+	}
+	days := createRequest.ExpiresInDays
+	if days == 0 {
+		days = defaultTokenDays
+	}
+	if days < 1 || days > maxTokenDays {
+		writeError(w, r, http.StatusBadRequest, codeValidation, "expiresInDays must be between 1 and 365")
+		return
+	}
+
+	random, err := cipher.RandomToken(32)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("api token random: %w", err))
+		return
+	}
+	token := apiTokenPrefix + random
+
+	now := time.Now()
+	db := database.Get()
+	_, err = db.Exec(`
+		INSERT INTO api_tokens (UserId, Name, TokenHash, Scope, CreatedAt, ExpiresAt)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		userData["user_id"], name, cipher.SHA256(token), createRequest.Scope,
+		now.Unix(), now.AddDate(0, 0, days).Unix())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	logger.FromContext(r.Context()).Info("api token created", "name", name, "scope", createRequest.Scope, "login", userData["login"])
+	json.NewEncoder(w).Encode(APITokenResponse{
+		Success: true,
+		Message: tr(r, "Token created, it will not be shown again"),
+		Token:   token,
+	})
+}
+
+// токены пользователя без отозванных
+func handler_tokens_list(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r, "GET")
+		return
+	}
+
+	userData, err := authenticate(r, scopeSession)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	db := database.Get()
+	rows, err := db.Query(`
+		SELECT id, Name, Scope, CreatedAt, ExpiresAt, LastUsedAt FROM api_tokens
+		WHERE UserId = ? AND RevokedAt IS NULL
+		ORDER BY CreatedAt DESC`, userData["user_id"])
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		var (
+			token                APIToken
+			createdAt, expiresAt int64
+			lastUsedAt           *int64
+		)
+		if err := rows.Scan(&token.ID, &token.Name, &token.Scope, &createdAt, &expiresAt, &lastUsedAt); err != nil {
+			logger.FromContext(r.Context()).Error("scanning api token failed", "err", err)
+			continue
+		}
+		token.CreatedAt = time.Unix(createdAt, 0)
+		token.ExpiresAt = time.Unix(expiresAt, 0)
+		if lastUsedAt != nil {
+			t := time.Unix(*lastUsedAt, 0)
+			token.LastUsedAt = &t
+		}
+		tokens = append(tokens, token)
+	}
+
+	json.NewEncoder(w).Encode(APITokenResponse{
+		Success: true,
+		Message: tr(r, "Tokens retrieved successfully"),
+		Tokens:  tokens,
+	})
+}
+
+// отзыв токена владельцем
+func handler_tokens_revoke(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" && r.Method != "DELETE" {
+		writeMethodNotAllowed(w, r, "POST", "DELETE")
+		return
+	}
+
+	userData, err := authenticate(r, scopeSession)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	// id в пути (DELETE /api/v1/tokens/{id}) или в теле
+	var revokeRequest APITokenRevokeRequest
+	if id := r.PathValue("id"); id != "" {
+		revokeRequest.ID, err = strconv.ParseInt(id, 10, 64)
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&revokeRequest)
+	}
+	if err != nil || revokeRequest.ID <= 0 {
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Token id is required")
+		return
+	}
+
+	db := database.Get()
+	result, err := db.Exec(`UPDATE api_tokens SET RevokedAt = ? WHERE id = ? AND UserId = ? AND RevokedAt IS NULL`,
+		time.Now().Unix(), revokeRequest.ID, userData["user_id"])
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		writeError(w, r, http.StatusNotFound, codeTokenNotFound, "Token not found")
+		return
+	}
+
+	logger.FromContext(r.Context()).Info("api token revoked", "token_id", revokeRequest.ID, "login", userData["login"])
+	json.NewEncoder(w).Encode(APITokenResponse{
+		Success: true,
+		Message: tr(r, "Token revoked"),
+	})
+}
@@ -0,0 +1,478 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"qr_code/internal/cipher"
+	"qr_code/internal/config"
+	"qr_code/internal/cookie"
+	"qr_code/internal/database"
+	"qr_code/internal/logger"
+	"qr_code/internal/totp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// второй шаг входа
+type AuthTOTPRequest struct {
+	Challenge    string `json:"challenge"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// enroll/confirm/disable; challenge - если вход ещё не завершён (обязательная регистрация)
+type TOTPRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+type TOTPResponse struct {
+	Success       bool     `json:"success"`
+	Message       string   `json:"message"`
+	Secret        string   `json:"secret,omitempty"`
+	URI           string   `json:"uri,omitempty"`
+	QRCode        string   `json:"qrCode,omitempty"`
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+}
+
+// назначение промежуточного токена входа
+const (
+	challengeTOTP   = "totp"
+	challengeEnroll = "totp_enroll"
+)
+
+const (
+	challengeTTL      = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+// настройки, значения по умолчанию совпадают с config.TOTP
+var (
+	totpIssuer        = "QR Attendance"
+	totpRequiredRoles []string
+)
+
+func configureTOTP(cfg config.TOTP) {
+	totpIssuer = cfg.Issuer
+	totpRequiredRoles = cfg.RequiredRoles
+}
+
+func totpRequiredFor(role string) bool {
+	return slices.Contains(totpRequiredRoles, role)
+}
+
+func userTOTPEnabled(db *sql.DB, userID int64) (bool, error) {
+	var enabled bool
+	err := db.QueryRow(`SELECT Enabled FROM user_totp WHERE UserId = ?`, userID).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return enabled, err
+}
+
+// промежуточный токен: пароль уже проверен, сессия ещё не выдана
+func newLoginChallenge(purpose string, id int64, login, fullName, role string, groupID int64) (string, error) {
+	return cookie.EncryptChallenge(map[string]interface{}{
+		"purpose":   purpose,
+		"user_id":   id,
+		"login":     login,
+		"role":      role,
+		"full_name": fullName,
+		"group_id":  groupID,
+		"exp":       time.Now().Add(challengeTTL).Unix(),
+	})
+}
+
+var errInvalidChallenge = errors.New("invalid or expired challenge")
+
+func parseLoginChallenge(challenge, purpose string) (map[string]interface{}, error) {
+	data, err := cookie.DecryptChallenge(challenge)
+	if err != nil {
+		return nil, errInvalidChallenge
+	}
+	exp, _ := data["exp"].(float64)
+	if data["purpose"] != purpose || time.Now().Unix() > int64(exp) {
+		return nil, errInvalidChallenge
+	}
+	return data, nil
+}
+
+// завершение входа по данным из challenge
+func completeLoginFromChallenge(w http.ResponseWriter, r *http.Request, data map[string]interface{}, recoveryCodes []string) {
+	id, _ := data["user_id"].(float64)
+	groupID, _ := data["group_id"].(float64)
+	login, _ := data["login"].(string)
+	fullName, _ := data["full_name"].(string)
+	role, _ := data["role"].(string)
+
+	if err := issueSession(w, r, int64(id), login, fullName, role, int64(groupID)); err != nil {
+		writeInternalError(w, r, fmt.Errorf("creating session: %w", err))
+		return
+	}
+	response := authSuccessResponse(r, fullName, role, int64(groupID))
+	response.RecoveryCodes = recoveryCodes
+	json.NewEncoder(w).Encode(response)
+}
+
+// проверка кода с защитой от повторного использования
+func verifyTOTPCode(db *sql.DB, userID int64, code string, enabled bool) (bool, error) {
+	var secret string
+	var lastStep int64
+	err := db.QueryRow(`SELECT Secret, LastStep FROM user_totp WHERE UserId = ? AND Enabled = ?`,
+		userID, enabled).Scan(&secret, &lastStep)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	ok, step := totp.Validate(secret, code, time.Now())
+	if !ok || step <= lastStep {
+		return false, nil
+	}
+	// условие по LastStep - если тот же код пришёл параллельно
+	result, err := db.Exec(`UPDATE user_totp SET LastStep = ? WHERE UserId = ? AND LastStep < ?`, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	return affected == 1, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// одноразовый код восстановления
+func useRecoveryCode(db *sql.DB, userID int64, code string) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE totp_recovery_codes SET UsedAt = ?
+		WHERE UserId = ? AND CodeHash = ? AND UsedAt IS NULL`,
+		time.Now().Unix(), userID, cipher.SHA256(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	return affected == 1, nil
+}
+
+// новый набор кодов восстановления (старые удаляются)
+func generateRecoveryCodes(tx *sql.Tx, userID int64) ([]string, error) {
+	if _, err := tx.Exec(`DELETE FROM totp_recovery_codes WHERE UserId = ?`, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for len(codes) < recoveryCodeCount {
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(secret[:5] + "-" + secret[5:10])
+		_, err = tx.Exec(`INSERT OR IGNORE INTO totp_recovery_codes (UserId, CodeHash) VALUES (?, ?)`,
+			userID, cipher.SHA256(normalizeRecoveryCode(code)))
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// пользователь для enroll/confirm: по сессии или по challenge обязательной регистрации
+func totpUser(r *http.Request, challenge string) (data map[string]interface{}, fromChallenge bool, err error) {
+	if challenge != "" {
+		data, err = parseLoginChallenge(challenge, challengeEnroll)
+		return data, true, err
+	}
+	data, err = authenticate(r, scopeSession)
+	return data, false, err
+}
+
+// второй шаг входа: код из приложения или код восстановления
+func handler_auth_totp(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r, "POST")
+		return
+	}
+
+	var totpRequest AuthTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&totpRequest); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON format")
+		return
+	}
+
+	data, err := parseLoginChallenge(totpRequest.Challenge, challengeTOTP)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, codeChallengeInvalid, "Invalid or expired challenge, log in again")
+		return
+	}
+	userID, _ := data["user_id"].(float64)
+	login, _ := data["login"].(string)
+
+	if ok, wait := authLoginLimiter.Allow(login); !ok {
+		setRetryAfter(w, wait)
+		writeError(w, r, http.StatusTooManyRequests, codeRateLimited, "Too many requests, try again later")
+		return
+	}
+
+	db := database.Get()
+
+	// блокировка действует и на второй шаг
+	lockedFor, err := loginLockedFor(db, login)
+	if err == nil && lockedFor > 0 {
+		loginAttempts.Inc("locked")
+		setRetryAfter(w, lockedFor)
+		writeError(w, r, http.StatusTooManyRequests, codeAccountLocked, "Too many failed login attempts, account is temporarily locked")
+		return
+	}
+
+	var valid bool
+	if totpRequest.RecoveryCode != "" {
+		valid, err = useRecoveryCode(db, int64(userID), totpRequest.RecoveryCode)
+	} else {
+		valid, err = verifyTOTPCode(db, int64(userID), totpRequest.Code, true)
+	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("totp verify: %w", err))
+		return
+	}
+	if !valid {
+		loginAttempts.Inc("failure")
+		if err := registerLoginFailure(db, login); err != nil {
+			logger.FromContext(r.Context()).Error("lockout update failed", "err", err)
+		}
+		writeError(w, r, http.StatusUnauthorized, codeTOTPInvalid, "Invalid two-factor code")
+		return
+	}
+
+	if totpRequest.RecoveryCode != "" {
+		logger.FromContext(r.Context()).Info("login with a recovery code", "login", login)
+	}
+	completeLoginFromChallenge(w, r, data, nil)
+}
+
+// начало регистрации: новый секрет и otpauth ссылка
+func handler_totp_enroll(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r, "POST")
+		return
+	}
+
+	var totpRequest TOTPRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&totpRequest); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON format")
+			return
+		}
+	}
+
+	userData, _, err := totpUser(r, totpRequest.Challenge)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "Not authorized")
+		return
+	}
+	userID, _ := userData["user_id"].(float64)
+	login, _ := userData["login"].(string)
+
+	db := database.Get()
+
+	enabled, err := userTOTPEnabled(db, int64(userID))
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if enabled {
+		writeError(w, r, http.StatusConflict, codeTOTPAlreadyEnabled, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	// неподтверждённый секрет перезаписывается при повторном enroll
+	_, err = db.Exec(`
+		INSERT INTO user_totp (UserId, Secret, Enabled, LastStep, CreatedAt) VALUES (?, ?, 0, 0, ?)
+		ON CONFLICT(UserId) DO UPDATE SET Secret = excluded.Secret, LastStep = 0, CreatedAt = excluded.CreatedAt
+		WHERE Enabled = 0`,
+		int64(userID), secret, time.Now().Unix(),
+	)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	uri := totp.ProvisioningURI(totpIssuer, login, secret)
+	qrCode, err := totp.ProvisioningQR(uri)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("totp qr code: %w", err))
+		return
+	}
+
+	response := TOTPResponse{
+		Success: true,
+		Message: tr(r, "Scan the code in an authenticator app and confirm with a generated code"),
+		Secret:  secret,
+		URI:     uri,
+		QRCode:  qrCode,
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// подтверждение регистрации первым кодом, выдача кодов восстановления
+func handler_totp_confirm(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r, "POST")
+		return
+	}
+
+	var totpRequest TOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&totpRequest); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON format")
+		return
+	}
+
+	userData, fromChallenge, err := totpUser(r, totpRequest.Challenge)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "Not authorized")
+		return
+	}
+	userID, _ := userData["user_id"].(float64)
+	login, _ := userData["login"].(string)
+
+	if ok, wait := authLoginLimiter.Allow(login); !ok {
+		setRetryAfter(w, wait)
+		writeError(w, r, http.StatusTooManyRequests, codeRateLimited, "Too many requests, try again later")
+		return
+	}
+
+	db := database.Get()
+
+	valid, err := verifyTOTPCode(db, int64(userID), totpRequest.Code, false)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if !valid {
+		writeError(w, r, http.StatusBadRequest, codeTOTPInvalid, "Invalid code or enrollment not started")
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	var codes []string
+	_, err = tx.Exec(`UPDATE user_totp SET Enabled = 1 WHERE UserId = ?`, int64(userID))
+	if err == nil {
+		codes, err = generateRecoveryCodes(tx, int64(userID))
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		tx.Rollback()
+		writeInternalError(w, r, err)
+		return
+	}
+
+	logger.FromContext(r.Context()).Info("two-factor authentication enabled", "login", login)
+
+	// обязательная регистрация при входе - сразу выдаём сессию
+	if fromChallenge {
+		completeLoginFromChallenge(w, r, userData, codes)
+		return
+	}
+
+	response := TOTPResponse{
+		Success:       true,
+		Message:       tr(r, "Two-factor authentication enabled. Store the recovery codes in a safe place"),
+		RecoveryCodes: codes,
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// отключение (нужен текущий код); для обязательных ролей запрещено
+func handler_totp_disable(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r, "POST")
+		return
+	}
+	// только cookie сессии, API токеном нельзя
+	userData, err := authenticate(r, scopeSession)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	role, _ := userData["role"].(string)
+	if totpRequiredFor(role) {
+		writeError(w, r, http.StatusForbidden, codeTOTPRequired, "Two-factor authentication is required for your role")
+		return
+	}
+
+	var totpRequest TOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&totpRequest); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON format")
+		return
+	}
+
+	userID, _ := userData["user_id"].(float64)
+	login, _ := userData["login"].(string)
+
+	if ok, wait := authLoginLimiter.Allow(login); !ok {
+		setRetryAfter(w, wait)
+		writeError(w, r, http.StatusTooManyRequests, codeRateLimited, "Too many requests, try again later")
+		return
+	}
+
+	db := database.Get()
+
+	valid, err := verifyTOTPCode(db, int64(userID), totpRequest.Code, true)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if !valid {
+		writeError(w, r, http.StatusBadRequest, codeTOTPInvalid, "Invalid two-factor code")
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	_, err = tx.Exec(`DELETE FROM totp_recovery_codes WHERE UserId = ?`, int64(userID))
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM user_totp WHERE UserId = ?`, int64(userID))
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		tx.Rollback()
+		writeInternalError(w, r, err)
+		return
+	}
+
+	logger.FromContext(r.Context()).Info("two-factor authentication disabled", "login", login)
+	response := TOTPResponse{
+		Success: true,
+		Message: tr(r, "Two-factor authentication disabled"),
+	}
+	json.NewEncoder(w).Encode(response)
+}
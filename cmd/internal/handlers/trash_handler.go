@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"qr_code/internal/config"
+	"qr_code/internal/database"
+	"qr_code/internal/logger"
+	"strconv"
+	"time"
+)
+
+// срок хранения в корзине и период очистки, см. configureTrash
+var (
+	trashRetentionDays = 30
+	trashPurgeInterval = time.Hour
+)
+
+func configureTrash(cfg config.Trash) {
+	trashRetentionDays = cfg.RetentionDays
+	trashPurgeInterval = cfg.PurgeInterval
+}
+
+type TrashResponse struct {
+	Success  bool     `json:"success"`
+	Message  string   `json:"message"`
+	FullName string   `json:"fullname"`
+	Lessons  []Lesson `json:"lessons"`
+	// всего занятий по фильтрам; next_cursor - для следующей страницы, пустой на последней
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	// через сколько дней после deleted_at занятие удаляется совсем
+	RetentionDays int `json:"retention_days"`
+}
+
+// корзина преподавателя: те же фильтры и страницы, что у архива
+func handler_trash_getlessons(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r, "GET")
+		return
+	}
+	// cookie сессии или Bearer токен
+	userData, err := authenticate(r, scopeRead)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	// role check
+	if userData["role"] != "Teacher" {
+		writeError(w, r, http.StatusForbidden, codeForbidden, "Access denied")
+		return
+	}
+
+	query, err := parseLessonListQuery(r)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	lessons, total, nextCursor, err := queryLessons(database.Get(), userData["user_id"], lessonsDeleted, query)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	fullName, _ := userData["full_name"].(string)
+	response := TrashResponse{
+		Success:       true,
+		Message:       tr(r, "Trash retrieved successfully"),
+		FullName:      fullName,
+		Lessons:       lessons,
+		Total:         total,
+		NextCursor:    nextCursor,
+		RetentionDays: trashRetentionDays,
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// возврат занятия из корзины в архив
+func handler_trash_restore(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r, "POST")
+		return
+	}
+	// cookie сессии или Bearer токен
+	userData, err := authenticate(r, scopeManage)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	// role check
+	if userData["role"] != "Teacher" {
+		writeError(w, r, http.StatusForbidden, codeForbidden, "Access denied")
+		return
+	}
+
+	lessonId, err := strconv.Atoi(lessonIDParam(r))
+	if err != nil || lessonId <= 0 {
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Invalid Lesson ID format")
+		return
+	}
+
+	result, err := database.Get().Exec(`
+		UPDATE lessons SET DeletedAt = NULL
+		WHERE ID = ? AND TeacherId = ? AND DeletedAt IS NOT NULL`,
+		lessonId, userData["user_id"])
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		writeError(w, r, http.StatusNotFound, codeLessonNotFound, "Lesson not found in trash or you are not the owner")
+		return
+	}
+
+	logger.FromContext(r.Context()).Info("lesson restored from trash", "login", userData["login"], "lesson_id", lessonId)
+	response := ArchiveInfoResponse{
+		Success: true,
+		Message: tr(r, "Lesson restored to archive"),
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// окончательное удаление из корзины, не дожидаясь срока хранения
+func handler_trash_purge(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "DELETE" {
+		writeMethodNotAllowed(w, r, "DELETE")
+		return
+	}
+	// cookie сессии или Bearer токен
+	userData, err := authenticate(r, scopeManage)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	// role check
+	if userData["role"] != "Teacher" {
+		writeError(w, r, http.StatusForbidden, codeForbidden, "Access denied")
+		return
+	}
+
+	lessonId, err := strconv.Atoi(lessonIDParam(r))
+	if err != nil || lessonId <= 0 {
+		writeError(w, r, http.StatusBadRequest, codeValidation, "Invalid Lesson ID format")
+		return
+	}
+
+	purged, err := purgeLessons(database.Get(), `id = ? AND TeacherId = ?`, lessonId, userData["user_id"])
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if purged == 0 {
+		writeError(w, r, http.StatusNotFound, codeLessonNotFound, "Lesson not found in trash or you are not the owner")
+		return
+	}
+
+	logger.FromContext(r.Context()).Info("lesson purged", "login", userData["login"], "lesson_id", lessonId)
+	response := ArchiveInfoResponse{
+		Success: true,
+		Message: tr(r, "Lesson deleted successfully"),
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// удаление занятий из корзины (DeletedAt IS NOT NULL AND where) вместе с отметками,
+// группами, историей и отозванными кодами; возвращает число удалённых занятий
+func purgeLessons(db *sql.DB, where string, args ...any) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	selected := `SELECT id FROM lessons WHERE DeletedAt IS NOT NULL AND ` + where
+	for _, table := range []string{"attendances", "lesson_groups", "lesson_history"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE LessonId IN (`+selected+`)`, args...); err != nil {
+			return 0, fmt.Errorf("purge %s: %w", table, err)
+		}
+	}
+	result, err := tx.Exec(`DELETE FROM lessons WHERE DeletedAt IS NOT NULL AND `+where, args...)
+	if err != nil {
+		return 0, fmt.Errorf("purge lessons: %w", err)
+	}
+	purged, _ := result.RowsAffected()
+	return purged, tx.Commit()
+}
+
+// удаление занятий, пролежавших в корзине дольше срока хранения
+func purgeExpiredTrash(db *sql.DB) (int64, error) {
+	return purgeLessons(db, `DeletedAt <= datetime('now', ?)`, fmt.Sprintf("-%d days", trashRetentionDays))
+}
+
+// RunTrashPurge чистит корзину сразу и затем раз в trash.purge_interval, пока не отменён ctx
+func RunTrashPurge(ctx context.Context) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		purged, err := purgeExpiredTrash(database.Get())
+		if err != nil {
+			slog.Error("trash purge failed", "err", err)
+		} else if purged > 0 {
+			slog.Info("trash purged", "lessons", purged, "retention_days", trashRetentionDays)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
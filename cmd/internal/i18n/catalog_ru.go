@@ -0,0 +1,149 @@
+package i18n
+
+// русский каталог; ключ - текст сообщения из кода (для форматов - формат fmt с теми же глаголами)
+var ru = map[string]string{
+	// общие
+	"Allowed methods: %s":   "Разрешённые методы: %s",
+	"Method not allowed":    "Метод не поддерживается",
+	"Not found":             "Не найдено",
+	"Invalid JSON format":   "Неверный формат JSON",
+	"Internal server error": "Внутренняя ошибка сервера",
+	"Input is empty":        "Пустое значение",
+	"Input contains invalid characters. Only letters, numbers, @, ., -, _ are allowed (3-50 characters)": "Недопустимые символы. Разрешены буквы, цифры, @, ., -, _ (от 3 до 50 символов)",
+	"Too many requests, try again later": "Слишком много запросов, попробуйте позже",
+
+	// сессия и доступ
+	"Not authorized":                         "Требуется вход",
+	"Invalid session":                        "Недействительная сессия",
+	"Cross-site request rejected":            "Запрос с чужого сайта отклонён",
+	"Access denied":                          "Доступ запрещён",
+	"This action requires a browser session": "Это действие доступно только из браузера",
+
+	// вход
+	"Authentication successful":                                     "Вход выполнен",
+	"Login and password are required":                               "Введите логин и пароль",
+	"Invalid login or password":                                     "Неверный логин или пароль",
+	"No account is registered for this user":                        "Для этого пользователя нет учётной записи",
+	"Authentication service is unavailable":                         "Сервис входа недоступен",
+	"Too many failed login attempts, account is temporarily locked": "Слишком много неудачных попыток, вход временно заблокирован",
+	"Login contains invalid characters. Only letters, numbers, @, ., -, _ are allowed (3-50 characters)": "Логин содержит недопустимые символы. Разрешены буквы, цифры, @, ., -, _ (от 3 до 50 символов)",
+	"Login unlocked": "Вход разблокирован",
+
+	// двухфакторная аутентификация
+	"Two-factor authentication code required":                                     "Введите код двухфакторной аутентификации",
+	"Two-factor authentication enrollment required":                               "Необходимо подключить двухфакторную аутентификацию",
+	"Two-factor authentication is required for your role":                         "Для вашей роли обязательна двухфакторная аутентификация",
+	"Two-factor authentication is already enabled":                                "Двухфакторная аутентификация уже подключена",
+	"Two-factor authentication enabled. Store the recovery codes in a safe place": "Двухфакторная аутентификация подключена. Сохраните коды восстановления в надёжном месте",
+	"Two-factor authentication disabled":                                          "Двухфакторная аутентификация отключена",
+	"Scan the code in an authenticator app and confirm with a generated code":     "Отсканируйте код в приложении-аутентификаторе и подтвердите сгенерированным кодом",
+	"Invalid two-factor code":                                                     "Неверный код",
+	"Invalid code or enrollment not started":                                      "Неверный код или подключение не начато",
+	"Invalid or expired challenge, log in again":                                  "Срок подтверждения истёк, войдите заново",
+
+	// вход через OIDC
+	"OIDC login is disabled":                            "Вход через внешнего провайдера отключён",
+	"Invalid or expired login state, start again":       "Срок входа истёк, начните заново",
+	"Identity provider is unavailable":                  "Провайдер входа недоступен",
+	"Identity provider login failed":                    "Не удалось войти через провайдера",
+	"Login was rejected by the identity provider":       "Провайдер отклонил вход",
+	"Identity provider did not return a verified email": "Провайдер не подтвердил адрес почты",
+
+	// пароли
+	"Old password is incorrect":                                          "Неверный текущий пароль",
+	"New password must differ from the old one":                          "Новый пароль должен отличаться от текущего",
+	"New password does not meet policy: %s":                              "Новый пароль не соответствует требованиям: %s",
+	"password is too short: minimum %d characters":                       "слишком короткий, минимум %d символов",
+	"password is too long: maximum %d characters":                        "слишком длинный, максимум %d символов",
+	"password must contain a letter":                                     "нужна хотя бы одна буква",
+	"password must contain a digit":                                      "нужна хотя бы одна цифра",
+	"password must not contain the login":                                "пароль не должен содержать логин",
+	"password must not start or end with whitespace":                     "пароль не должен начинаться или заканчиваться пробелом",
+	"Password changed successfully":                                      "Пароль изменён",
+	"Password has been reset":                                            "Пароль сброшен",
+	"Reset token is required":                                            "Не указан токен сброса",
+	"Invalid or expired reset token":                                     "Ссылка для сброса недействительна или устарела",
+	"If the account exists and has an email, a reset link has been sent": "Если учётная запись существует и у неё указана почта, ссылка для сброса отправлена",
+	"Password reset":                                                     "Сброс пароля",
+	"A password reset was requested for login %s.\n\nOpen the link to set a new password (valid for %s):\n%s%s\n\nIf you did not request it, ignore this message.": "Для логина %s запрошен сброс пароля.\n\nПерейдите по ссылке, чтобы задать новый пароль (действует %s):\n%s%s\n\nЕсли вы не запрашивали сброс, просто проигнорируйте это письмо.",
+
+	// API токены
+	"Invalid or expired API token":                  "API токен недействителен или истёк",
+	"API token scope does not allow this action":    "Прав API токена недостаточно для этого действия",
+	"Token name is required (up to 100 characters)": "Укажите название токена (до 100 символов)",
+	"Scope must be one of: read, export, manage":    "Права токена: read, export или manage",
+	"expiresInDays must be between 1 and 365":       "expiresInDays должен быть от 1 до 365",
+	"Token id is required":                          "Не указан id токена",
+	"Token not found":                               "Токен не найден",
+	"Token created, it will not be shown again":     "Токен создан, повторно он показан не будет",
+	"Tokens retrieved successfully":                 "Список токенов получен",
+	"Token revoked":                                 "Токен отозван",
+
+	// занятия
+	"Lesson '%s' created successfully with ID: %d":                       "Занятие «%s» создано, ID: %d",
+	"Lesson ID is required":                                              "Не указан ID занятия",
+	"Invalid Lesson ID format":                                           "Неверный формат ID занятия",
+	"Lesson ID must be positive number":                                  "ID занятия должен быть положительным числом",
+	"Group IDs must be positive numbers":                                 "ID групп должны быть положительными числами",
+	"autoCloseMinutes must be between 0 and %d":                          "autoCloseMinutes должен быть от 0 до %d",
+	"Lesson not found":                                                   "Занятие не найдено",
+	"Lesson not found or access denied":                                  "Занятие не найдено или нет доступа",
+	"Lesson not found, you are not the owner, or already archived":       "Занятие не найдено, принадлежит другому преподавателю или уже в архиве",
+	"Lesson not found, you are not the owner, or lesson is archived":     "Занятие не найдено, принадлежит другому преподавателю или в архиве",
+	"Lesson not found, you are not the owner, or lesson is still active": "Занятие не найдено, принадлежит другому преподавателю или ещё не в архиве",
+	"Lesson not found, you are not the owner, or session is not open":    "Занятие не найдено, принадлежит другому преподавателю или приём отметок не открыт",
+	"Lesson is archived":                                                 "Занятие в архиве",
+	"Lesson retrieved successfully":                                      "Занятие получено",
+	"Lessons retrieved successfully":                                     "Список занятий получен",
+	"Lesson archived successfully":                                       "Занятие перенесено в архив",
+	"Lesson deleted successfully":                                        "Занятие удалено",
+	"Lesson moved to trash":                                              "Занятие перенесено в корзину",
+	"Lesson restored from archive":                                       "Занятие возвращено из архива",
+	"Lesson restored to archive":                                         "Занятие возвращено в архив",
+	"Trash retrieved successfully":                                       "Корзина получена",
+	"Lesson not found, you are not the owner, or lesson is not archived": "Занятие не найдено, принадлежит другому преподавателю или не в архиве",
+	"Lesson not found in trash or you are not the owner":                 "Занятие не найдено в корзине или принадлежит другому преподавателю",
+	"Archive lessons retrieved successfully":                             "Архив занятий получен",
+	"Attendance session opened":                                          "Приём отметок открыт",
+	"Attendance session closed":                                          "Приём отметок закрыт",
+	"Lesson updated successfully":                                        "Занятие изменено",
+	"Lesson history retrieved successfully":                              "История изменений занятия получена",
+	"Nothing to update: pass name, date or type":                         "Нечего менять: укажите name, date или type",
+	"Pass either ids or before":                                          "Укажите либо ids, либо before",
+	"At most %d lesson IDs per request":                                  "Не больше %d ID занятий за запрос",
+	"Lesson IDs must be positive numbers":                                "ID занятий должны быть положительными числами",
+	"Lessons archived: %d":                                               "Перенесено в архив: %d",
+	"Lessons moved to archive":                                           "Занятия перенесены в архив",
+	"%d of your lessons dated more than %d days ago were moved to the archive automatically:\n\n%s\n\nYou can return them from the archive at any time.": "Занятий с датой старше %[2]d дней автоматически перенесено в архив: %[1]d\n\n%[3]s\n\nИх можно вернуть из архива в любой момент.",
+
+	// страницы и фильтры списков занятий
+	"Dates must be in YYYY-MM-DD format":                                      "Даты указываются в формате ГГГГ-ММ-ДД",
+	"from must not be later than to":                                          "from не может быть позже to",
+	"sort must be one of date, name, created (prefix - for descending order)": "sort: date, name или created (с - в начале - по убыванию)",
+	"limit must be between 1 and %d":                                          "limit должен быть от 1 до %d",
+	"offset must not be negative":                                             "offset не может быть отрицательным",
+	"Use either cursor or offset, not both":                                   "Укажите cursor или offset, но не оба",
+	"Invalid cursor":                                                          "Неверный курсор",
+
+	// отметки
+	"Missing token parameter":        "Не указан параметр token",
+	"Invalid or expired QR token":    "QR-код недействителен или устарел",
+	"Attendance session is closed":   "Приём отметок закрыт",
+	"Attendance marked successfully": "Посещение отмечено",
+	"Attendance already marked":      "Посещение уже отмечено",
+
+	// выгрузка
+	"Attendances exported successfully": "Посещаемость выгружена",
+	"Full name":                         "ФИО",
+	"Group":                             "Группа",
+	"Status":                            "Статус",
+	"Marked at":                         "Время отметки",
+	"Present":                           "Присутствовал",
+	"Absent":                            "Отсутствовал",
+
+	// профиль и настройки
+	"Profile uploaded successfully": "Профиль получен",
+	"Language settings retrieved":   "Настройки языка получены",
+	"Language changed":              "Язык изменён",
+	"Unsupported language":          "Язык не поддерживается",
+}
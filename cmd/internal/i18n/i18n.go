@@ -0,0 +1,91 @@
+package i18n
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// поддерживаемые языки; исходные тексты сообщений в коде - английские
+const (
+	English = "en"
+	Russian = "ru"
+)
+
+// каталоги переводов: английский текст (или формат fmt) -> перевод
+var catalogs = map[string]map[string]string{
+	Russian: ru,
+}
+
+var defaultLanguage = English
+
+// SetDefault задаёт язык для запросов без предпочтения и Accept-Language
+func SetDefault(lang string) {
+	if Supported(lang) {
+		defaultLanguage = lang
+	}
+}
+
+func Default() string {
+	return defaultLanguage
+}
+
+func Supported(lang string) bool {
+	return lang == English || catalogs[lang] != nil
+}
+
+// Languages возвращает поддерживаемые языки
+func Languages() []string {
+	langs := []string{English}
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs[1:])
+	return langs
+}
+
+// T переводит сообщение; без перевода возвращается исходный текст
+func T(lang, message string) string {
+	if translated, ok := catalogs[lang][message]; ok {
+		return translated
+	}
+	return message
+}
+
+// Tf переводит формат и подставляет аргументы
+func Tf(lang, format string, args ...any) string {
+	return fmt.Sprintf(T(lang, format), args...)
+}
+
+// Has сообщает, есть ли перевод сообщения на язык
+func Has(lang, message string) bool {
+	if lang == English {
+		return true
+	}
+	_, ok := catalogs[lang][message]
+	return ok
+}
+
+// Negotiate выбирает язык по заголовку Accept-Language (с учётом q);
+// пустая строка - ни один язык из заголовка не поддерживается
+func Negotiate(acceptLanguage string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		// ru-RU, en-GB -> основной язык
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if q > bestQ && Supported(primary) {
+			best, bestQ = primary, q
+		}
+	}
+	return best
+}
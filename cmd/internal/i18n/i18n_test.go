@@ -0,0 +1,84 @@
+package i18n
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestNegotiate проверяет выбор языка по Accept-Language
+func TestNegotiate(t *testing.T) {
+	testCases := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"ru-RU,ru;q=0.9,en-US;q=0.8,en;q=0.7", Russian},
+		{"en-GB,en;q=0.9,ru;q=0.8", English},
+		{"de-DE,de;q=0.9,en;q=0.5,ru;q=0.6", Russian},
+		{"fr, *;q=0.5", ""},
+		{"RU", Russian},
+		{"ru;q=abc, en;q=0.1", English},
+	}
+	for _, tc := range testCases {
+		if got := Negotiate(tc.header); got != tc.want {
+			t.Errorf("Negotiate(%q) = %q, want %q", tc.header, got, tc.want)
+		}
+	}
+}
+
+// TestTranslate проверяет перевод, формат и запасной исходный текст
+func TestTranslate(t *testing.T) {
+	if got := T(Russian, "Present"); got != "Присутствовал" {
+		t.Errorf("Unexpected translation %q", got)
+	}
+	if got := T(English, "Present"); got != "Present" {
+		t.Errorf("English should return source text, got %q", got)
+	}
+	if got := T(Russian, "Unknown message"); got != "Unknown message" {
+		t.Errorf("Missing translation should fall back to source text, got %q", got)
+	}
+	if got := Tf(Russian, "autoCloseMinutes must be between 0 and %d", 240); !strings.HasSuffix(got, "от 0 до 240") {
+		t.Errorf("Unexpected formatted translation %q", got)
+	}
+
+	// форматы перевода должны совпадать с исходными по глаголам
+	for source, translated := range ru {
+		if strings.Count(source, "%") != strings.Count(translated, "%") {
+			t.Errorf("Format verbs differ in translation of %q", source)
+		}
+	}
+}
+
+// TestMiddlewareLanguage проверяет порядок: настройка пользователя, заголовок, язык по умолчанию
+func TestMiddlewareLanguage(t *testing.T) {
+	defer SetDefault(Default())
+	SetDefault(Russian)
+
+	var got []string
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, FromContext(r.Context()))
+		SetUserLanguage(r.Context(), Russian)
+		got = append(got, FromContext(r.Context()))
+		SetUserLanguage(r.Context(), "")
+		got = append(got, FromContext(r.Context()))
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if strings.Join(got, ",") != "en,ru,en" {
+		t.Errorf("Expected en,ru,en, got %v", got)
+	}
+	if w.Header().Get("Vary") != "Accept-Language" {
+		t.Errorf("Expected Vary: Accept-Language, got %q", w.Header().Get("Vary"))
+	}
+
+	got = nil
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if got[0] != Russian {
+		t.Errorf("Expected default language without header, got %q", got[0])
+	}
+}
@@ -0,0 +1,45 @@
+package i18n
+
+import (
+	"context"
+	"net/http"
+)
+
+type contextKey struct{}
+
+// язык запроса; предпочтение пользователя заполняет аутентификация
+type requestLanguage struct {
+	header string
+	user   string
+}
+
+// Middleware выбирает язык по Accept-Language
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// ответ зависит от заголовка: кэши не должны отдавать его другим
+		w.Header().Add("Vary", "Accept-Language")
+		lang := &requestLanguage{header: Negotiate(r.Header.Get("Accept-Language"))}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, lang)))
+	})
+}
+
+// SetUserLanguage запоминает язык из настроек пользователя; он важнее Accept-Language.
+// пустая строка - настройки нет
+func SetUserLanguage(ctx context.Context, lang string) {
+	if info, ok := ctx.Value(contextKey{}).(*requestLanguage); ok && (lang == "" || Supported(lang)) {
+		info.user = lang
+	}
+}
+
+// FromContext возвращает язык ответа: настройка пользователя, Accept-Language, язык по умолчанию
+func FromContext(ctx context.Context) string {
+	if info, ok := ctx.Value(contextKey{}).(*requestLanguage); ok {
+		if info.user != "" {
+			return info.user
+		}
+		if info.header != "" {
+			return info.header
+		}
+	}
+	return defaultLanguage
+}
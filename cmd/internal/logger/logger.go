@@ -0,0 +1,66 @@
+package logger
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// ключи, значения которых не попадают в лог (cookie, токены, хэши, пароли, коды).
+// сравнение по имени целиком: old_token_revoked или session_start не секрет
+var sensitiveKeys = map[string]bool{
+	"pass": true, "password": true, "passhash": true, "pass_hash": true, "hash": true,
+	"new_password": true, "old_password": true, "bind_password": true, "smtp_password": true,
+	"cookie": true, "set_cookie": true, "session": true, "session_cookie": true,
+	"token": true, "access_token": true, "refresh_token": true, "id_token": true, "api_token": true,
+	"bearer_token": true, "reset_token": true, "qr_token": true,
+	"secret": true, "client_secret": true, "totp_secret": true, "authorization": true,
+	"otp": true, "totp": true, "otp_code": true, "totp_code": true, "authorization_code": true,
+	"recovery": true, "recovery_code": true, "recovery_codes": true,
+	"challenge": true, "verifier": true, "code_verifier": true,
+}
+
+// Setup делает slog логгером по умолчанию: текст локально, JSON в dev/prod.
+// log.Printf тоже идёт через него
+func Setup(env string) *slog.Logger {
+	logger := New(os.Stdout, env)
+	slog.SetDefault(logger)
+	return logger
+}
+
+func New(w io.Writer, env string) *slog.Logger {
+	options := &slog.HandlerOptions{
+		Level:       slog.LevelInfo,
+		ReplaceAttr: redact,
+	}
+	if env == "local" {
+		options.Level = slog.LevelDebug
+		return slog.New(slog.NewTextHandler(w, options))
+	}
+	return slog.New(slog.NewJSONHandler(w, options))
+}
+
+// политика редактирования: по имени ключа и по виду значения
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if sensitiveKey(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+	if a.Value.Kind() == slog.KindString && sensitiveValue(a.Value.String()) {
+		return slog.String(a.Key, redacted)
+	}
+	return a
+}
+
+func sensitiveKey(key string) bool {
+	return sensitiveKeys[strings.ReplaceAll(strings.ToLower(key), "-", "_")]
+}
+
+// API токены и заголовки Authorization узнаются по значению
+func sensitiveValue(value string) bool {
+	return strings.HasPrefix(value, "qrt_") ||
+		strings.HasPrefix(value, "Bearer ") ||
+		strings.HasPrefix(value, "Basic ")
+}
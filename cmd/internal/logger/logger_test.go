@@ -0,0 +1,118 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureDefault подменяет логгер по умолчанию на JSON в буфер
+func captureDefault(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(New(&buf, "prod"))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func lastEntry(t *testing.T, buf *bytes.Buffer) map[string]interface{} {
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &entry); err != nil {
+		t.Fatalf("Log line is not JSON: %v", err)
+	}
+	return entry
+}
+
+// TestRedaction проверяет что секреты не попадают в лог
+func TestRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	New(&buf, "prod").Info("login",
+		"login", "teacher",
+		"session", "AES-GCM-cookie",
+		"PassHash", "5f4dcc3b5aa765d61d8327deb882cf99",
+		"reset_token", "abc",
+		"header", "Bearer qrt_secret",
+		"value", "qrt_secret",
+		"token_id", 7,
+		"old_token_revoked", true,
+		"session_start", "08:30",
+	)
+
+	out := buf.String()
+	for _, secret := range []string{"AES-GCM-cookie", "5f4dcc3b", "abc", "qrt_secret"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("Secret %q leaked into log: %s", secret, out)
+		}
+	}
+	entry := lastEntry(t, &buf)
+	if entry["login"] != "teacher" {
+		t.Errorf("Expected login to be kept, got %v", entry["login"])
+	}
+	if entry["token_id"] != float64(7) {
+		t.Errorf("Expected token_id to be kept, got %v", entry["token_id"])
+	}
+	// ключ сравнивается целиком, вхождение "token" или "session" не скрывает значение
+	if entry["old_token_revoked"] != true || entry["session_start"] != "08:30" {
+		t.Errorf("Expected benign attributes to be kept, got %v, %v", entry["old_token_revoked"], entry["session_start"])
+	}
+}
+
+// TestMiddlewareRequestID проверяет передачу X-Request-ID и строку доступа
+func TestMiddlewareRequestID(t *testing.T) {
+	buf := captureDefault(t)
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetUserID(r.Context(), 42)
+		FromContext(r.Context()).Info("inside")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("ok"))
+	}))
+
+	req := httptest.NewRequest("POST", "/api/v1/attendance?token=secret", nil)
+	req.Header.Set(RequestIDHeader, "lb-123")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if got := w.Header().Get(RequestIDHeader); got != "lb-123" {
+		t.Errorf("Expected request id to be propagated, got %q", got)
+	}
+	if !strings.Contains(buf.String(), `"msg":"inside","request_id":"lb-123"`) {
+		t.Errorf("Handler log line has no request id: %s", buf.String())
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Errorf("Query string leaked into access log: %s", buf.String())
+	}
+
+	entry := lastEntry(t, buf)
+	if entry["msg"] != "request" || entry["path"] != "/api/v1/attendance" {
+		t.Errorf("Unexpected access line: %v", entry)
+	}
+	if entry["status"] != float64(http.StatusCreated) || entry["bytes"] != float64(2) {
+		t.Errorf("Expected status 201 and 2 bytes, got %v and %v", entry["status"], entry["bytes"])
+	}
+	if entry["user_id"] != float64(42) {
+		t.Errorf("Expected user_id 42, got %v", entry["user_id"])
+	}
+}
+
+// TestMiddlewareGeneratesRequestID проверяет новый id вместо отсутствующего или небезопасного
+func TestMiddlewareGeneratesRequestID(t *testing.T) {
+	captureDefault(t)
+	handler := Middleware(http.NotFoundHandler())
+
+	for _, incoming := range []string{"", "bad id\nwith newline"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(RequestIDHeader, incoming)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		got := w.Header().Get(RequestIDHeader)
+		if got == "" || got == incoming || !validRequestID.MatchString(got) {
+			t.Errorf("Expected generated request id for %q, got %q", incoming, got)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// id от балансировщика принимаем только в безопасном виде
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type contextKey struct{}
+
+// данные запроса; user_id заполняет аутентификация
+type requestInfo struct {
+	id     string
+	userID any
+}
+
+// Middleware выдаёт запросу id (из X-Request-ID или новый) и пишет строку доступа
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		info := &requestInfo{id: id}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), contextKey{}, info)))
+
+		// путь без query: в нём бывают одноразовые токены отметки
+		attrs := []any{
+			"request_id", id,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"bytes", recorder.bytes,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"remote_ip", remoteIP(r),
+		}
+		if info.userID != nil {
+			attrs = append(attrs, "user_id", info.userID)
+		}
+		slog.Info("request", attrs...)
+	})
+}
+
+// SetUserID запоминает пользователя для строки доступа
+func SetUserID(ctx context.Context, userID any) {
+	if info, ok := ctx.Value(contextKey{}).(*requestInfo); ok {
+		info.userID = userID
+	}
+}
+
+// FromContext возвращает логгер с request_id текущего запроса
+func FromContext(ctx context.Context) *slog.Logger {
+	if info, ok := ctx.Value(contextKey{}).(*requestInfo); ok {
+		return slog.Default().With("request_id", info.id)
+	}
+	return slog.Default()
+}
+
+func newRequestID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// запоминает статус и размер ответа
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// для http.ResponseController (SSE: Flush, SetWriteDeadline)
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// RequestID - id текущего запроса (для ответа с ошибкой)
+func RequestID(ctx context.Context) string {
+	if info, ok := ctx.Value(contextKey{}).(*requestInfo); ok {
+		return info.id
+	}
+	return ""
+}
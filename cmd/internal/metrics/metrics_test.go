@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T) string {
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %q", w.Header().Get("Content-Type"))
+	}
+	return w.Body.String()
+}
+
+func expectLines(t *testing.T, out string, lines ...string) {
+	for _, line := range lines {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected line %q in:\n%s", line, out)
+		}
+	}
+}
+
+// TestTextFormat проверяет вывод счётчика, гистограммы и gauge
+func TestTextFormat(t *testing.T) {
+	counter := NewCounterVec("test_events_total", "Test events.", "kind")
+	counter.Inc("a")
+	counter.Add(2, `quote"d`)
+	histogram := NewHistogramVec("test_duration_seconds", "Test durations.", []float64{0.1, 1}, "op")
+	histogram.Observe(0.05, "read")
+	histogram.Observe(0.5, "read")
+	histogram.Observe(3, "read")
+	NewGaugeFunc("test_open", "Test gauge.", func() float64 { return 4 })
+
+	expectLines(t, scrape(t),
+		"# TYPE test_events_total counter",
+		`test_events_total{kind="a"} 1`,
+		`test_events_total{kind="quote\"d"} 2`,
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{op="read",le="0.1"} 1`,
+		`test_duration_seconds_bucket{op="read",le="1"} 2`,
+		`test_duration_seconds_bucket{op="read",le="+Inf"} 3`,
+		`test_duration_seconds_sum{op="read"} 3.55`,
+		`test_duration_seconds_count{op="read"} 3`,
+		"# TYPE test_open gauge",
+		"test_open 4",
+	)
+}
+
+// TestMiddlewareRouteLabel проверяет метку по шаблону маршрута, а не по пути
+func TestMiddlewareRouteLabel(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/lessons/{id}", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "lesson")
+	})
+	handler := Middleware(mux)
+
+	for _, path := range []string{"/api/v1/lessons/1", "/api/v1/lessons/2", "/nope"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/lessons/3", nil))
+
+	if got := httpRequests.Value("/api/v1/lessons/{id}", "GET", "200"); got != 2 {
+		t.Errorf("Expected 2 requests for lesson route, got %v", got)
+	}
+	if got := httpRequests.Value("unmatched", "GET", "404"); got != 1 {
+		t.Errorf("Expected 1 unmatched request, got %v", got)
+	}
+	if got := httpRequests.Value("unmatched", "POST", "405"); got != 1 {
+		t.Errorf("Expected 1 request with wrong method, got %v", got)
+	}
+	expectLines(t, scrape(t), `qr_http_request_duration_seconds_count{route="/api/v1/lessons/{id}",method="GET"} 2`)
+}
@@ -0,0 +1,112 @@
+package notify
+
+import (
+	"fmt"
+	"log/slog"
+	"net/smtp"
+	"os"
+	"qr_code/internal/config"
+	"strings"
+	"sync"
+	"time"
+)
+
+// доставка сообщений пользователю (письма со ссылкой сброса пароля и т.п.)
+type Notifier interface {
+	Send(to, subject, body string) error
+}
+
+// вывод в лог, для разработки; в prod запрещён: в письмах ссылки сброса пароля
+type ConsoleNotifier struct{}
+
+func (ConsoleNotifier) Send(to, subject, body string) error {
+	slog.Info("notify", "to", to, "subject", subject, "body", body)
+	return nil
+}
+
+// дописывает сообщения в файл, для разработки и тестов
+type FileNotifier struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (n *FileNotifier) Send(to, subject, body string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC1123Z), to, subject, body)
+	return err
+}
+
+type SMTPNotifier struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (n *SMTPNotifier) Send(to, subject, body string) error {
+	// защита от подстановки заголовков
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("invalid header value")
+	}
+
+	var auth smtp.Auth
+	if n.Username != "" {
+		host := n.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", n.Username, n.Password, host)
+	}
+
+	msg := "From: " + n.From + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + strings.ReplaceAll(body, "\n", "\r\n")
+	return smtp.SendMail(n.Addr, auth, n.From, []string{to}, []byte(msg))
+}
+
+var (
+	instance Notifier
+	once     sync.Once
+)
+
+// инициализация по config.Notifier
+func MustInit() {
+	once.Do(func() {
+		cfg := config.Get().Notifier
+		switch cfg.Kind {
+		case "console":
+			instance = ConsoleNotifier{}
+		case "file":
+			instance = &FileNotifier{Path: cfg.FilePath}
+		case "smtp":
+			instance = &SMTPNotifier{
+				Addr:     cfg.SMTPAddress,
+				Username: cfg.SMTPUsername,
+				Password: cfg.SMTPPassword,
+				From:     cfg.From,
+			}
+		default:
+			slog.Error("unknown notifier kind", "kind", cfg.Kind)
+			os.Exit(1)
+		}
+	})
+}
+
+// global notifier instance
+func Get() Notifier {
+	if instance == nil {
+		panic("notifier not initialized. call MustInit()")
+	}
+	return instance
+}
@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestFileNotifier проверяет что письма дописываются в файл с заголовками
+func TestFileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	n := &FileNotifier{Path: path}
+	if err := n.Send("first@example.org", "Password reset", "link 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Send("second@example.org", "Lessons moved to archive", "list"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mail := string(data)
+	for _, want := range []string{"To: first@example.org\nSubject: Password reset\n\nlink 1\n", "To: second@example.org\nSubject: Lessons moved to archive\n\nlist\n"} {
+		if !strings.Contains(mail, want) {
+			t.Errorf("Expected mail file to contain %q, got:\n%s", want, mail)
+		}
+	}
+	if strings.Count(mail, "Date: ") != 2 {
+		t.Errorf("Expected two messages, got:\n%s", mail)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected mail file mode 0600, got %v, %v", info.Mode().Perm(), err)
+	}
+}
+
+// TestConsoleNotifier проверяет вывод письма в лог
+func TestConsoleNotifier(t *testing.T) {
+	var buf bytes.Buffer
+	saved := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer slog.SetDefault(saved)
+
+	if err := (ConsoleNotifier{}).Send("user@example.org", "Password reset", "link"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"to=user@example.org", `subject="Password reset"`, "body=link"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected log to contain %q, got %s", want, buf.String())
+		}
+	}
+}
+
+// TestSMTPNotifierHeaderInjection проверяет отказ при переводе строки в заголовках
+func TestSMTPNotifierHeaderInjection(t *testing.T) {
+	n := &SMTPNotifier{Addr: "127.0.0.1:1", From: "noreply@localhost"}
+	for _, c := range []struct{ to, subject string }{
+		{"user@example.org\r\nBcc: other@example.org", "Password reset"},
+		{"user@example.org", "Password reset\nBcc: other@example.org"},
+	} {
+		if err := n.Send(c.to, c.subject, "body"); err == nil || err.Error() != "invalid header value" {
+			t.Errorf("Expected header injection to be rejected for %q / %q, got %v", c.to, c.subject, err)
+		}
+	}
+}
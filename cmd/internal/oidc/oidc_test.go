@@ -0,0 +1,241 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// mockIdP - минимальный OpenID провайдер в процессе теста
+type mockIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	// выданные коды: code -> challenge и nonce из authorize
+	codes map[string]authRequest
+	// правка claims перед подписью (для негативных тестов)
+	mutate func(claims map[string]interface{})
+}
+
+type authRequest struct {
+	challenge string
+	nonce     string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{key: key, codes: make(map[string]authRequest)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	// пользователь сразу "вошёл": редирект с кодом
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("code_challenge_method") != "S256" {
+			http.Error(w, "pkce required", http.StatusBadRequest)
+			return
+		}
+		code := "code-" + q.Get("state")
+		idp.codes[code] = authRequest{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+		http.Redirect(w, r, q.Get("redirect_uri")+"?code="+code+"&state="+q.Get("state"), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		req, ok := idp.codes[r.Form.Get("code")]
+		if !ok || pkceChallenge(r.Form.Get("code_verifier")) != req.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		delete(idp.codes, r.Form.Get("code"))
+
+		claims := map[string]interface{}{
+			"iss":            idp.server.URL,
+			"aud":            "client-1",
+			"sub":            "user-42",
+			"email":          "ivanov@uni.example",
+			"email_verified": true,
+			"name":           "Иванов Иван",
+			"groups":         []string{"staff", "student:Д-Э 309"},
+			"nonce":          req.nonce,
+			"iat":            time.Now().Unix(),
+			"exp":            time.Now().Add(time.Hour).Unix(),
+		}
+		if idp.mutate != nil {
+			idp.mutate(claims)
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     idp.sign(t, claims),
+		})
+	})
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *mockIdP) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test-key", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// login проходит authorize как браузер и возвращает code из редиректа
+func (idp *mockIdP) login(t *testing.T, provider *Provider, state, nonce, challenge string) string {
+	authURL, err := provider.AuthCodeURL(context.Background(), state, nonce, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.Query().Get("state") != state {
+		t.Fatalf("state not returned: %s", location)
+	}
+	return location.Query().Get("code")
+}
+
+func newTestProvider(idp *mockIdP) *Provider {
+	return NewProvider(Settings{
+		Issuer:      idp.server.URL,
+		ClientID:    "client-1",
+		RedirectURL: "http://app.local/auth/oidc/callback",
+		Scopes:      []string{"openid", "email", "profile"},
+	})
+}
+
+// TestExchange проверяет полный authorization code + PKCE поток
+func TestExchange(t *testing.T) {
+	idp := newMockIdP(t)
+	provider := newTestProvider(idp)
+
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := idp.login(t, provider, "state-1", "nonce-1", challenge)
+
+	claims, err := provider.Exchange(context.Background(), code, verifier, "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	if claims.Subject != "user-42" || claims.Email != "ivanov@uni.example" || !claims.EmailVerified {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+	if len(claims.Groups) != 2 || claims.Groups[1] != "student:Д-Э 309" {
+		t.Errorf("unexpected groups: %v", claims.Groups)
+	}
+}
+
+// TestExchangeWrongVerifier проверяет что без верного code_verifier код не обменять
+func TestExchangeWrongVerifier(t *testing.T) {
+	idp := newMockIdP(t)
+	provider := newTestProvider(idp)
+
+	_, challenge, _ := NewPKCE()
+	code := idp.login(t, provider, "state-2", "nonce-2", challenge)
+
+	otherVerifier, _, _ := NewPKCE()
+	if _, err := provider.Exchange(context.Background(), code, otherVerifier, "nonce-2"); err == nil {
+		t.Fatal("Expected error for wrong code_verifier")
+	}
+}
+
+// TestExchangeRejectsBadTokens проверяет проверки id_token
+func TestExchangeRejectsBadTokens(t *testing.T) {
+	testCases := []struct {
+		name   string
+		nonce  string
+		mutate func(claims map[string]interface{})
+		want   error
+	}{
+		{"nonce mismatch", "other-nonce", nil, ErrNonce},
+		{"wrong audience", "n", func(c map[string]interface{}) { c["aud"] = "someone-else" }, ErrInvalidToken},
+		{"wrong issuer", "n", func(c map[string]interface{}) { c["iss"] = "https://evil.example" }, ErrInvalidToken},
+		{"expired", "n", func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, ErrInvalidToken},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			idp := newMockIdP(t)
+			idp.mutate = tc.mutate
+			provider := newTestProvider(idp)
+
+			verifier, challenge, _ := NewPKCE()
+			code := idp.login(t, provider, "state", "n", challenge)
+
+			_, err := provider.Exchange(context.Background(), code, verifier, tc.nonce)
+			if !errors.Is(err, tc.want) {
+				t.Errorf("Expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+// TestVerifyIDTokenBadSignature проверяет отказ для токена, подписанного чужим ключом
+func TestVerifyIDTokenBadSignature(t *testing.T) {
+	idp := newMockIdP(t)
+	provider := newTestProvider(idp)
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forged := &mockIdP{key: otherKey}
+	token := forged.sign(t, map[string]interface{}{
+		"iss": idp.server.URL,
+		"aud": "client-1",
+		"sub": "attacker",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	if _, err := provider.verifyIDToken(context.Background(), token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken, got %v", err)
+	}
+}
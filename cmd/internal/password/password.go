@@ -0,0 +1,70 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"qr_code/internal/cipher"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// требования к новому паролю
+type Policy struct {
+	MinLength int
+	MaxLength int
+}
+
+var DefaultPolicy = Policy{
+	MinLength: 8,
+	MaxLength: 128,
+}
+
+var (
+	ErrTooShort    = errors.New("password is too short")
+	ErrTooLong     = errors.New("password is too long")
+	ErrNoLetter    = errors.New("password must contain a letter")
+	ErrNoDigit     = errors.New("password must contain a digit")
+	ErrHasLogin    = errors.New("password must not contain the login")
+	ErrWhitespaces = errors.New("password must not start or end with whitespace")
+)
+
+// проверка нового пароля по политике
+func (p Policy) Validate(password, login string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return fmt.Errorf("%w: minimum %d characters", ErrTooShort, p.MinLength)
+	}
+	if length > p.MaxLength {
+		return fmt.Errorf("%w: maximum %d characters", ErrTooLong, p.MaxLength)
+	}
+	if strings.TrimSpace(password) != password {
+		return ErrWhitespaces
+	}
+
+	var hasLetter, hasDigit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	if !hasLetter {
+		return ErrNoLetter
+	}
+	if !hasDigit {
+		return ErrNoDigit
+	}
+
+	if login != "" && strings.Contains(strings.ToLower(password), strings.ToLower(login)) {
+		return ErrHasLogin
+	}
+	return nil
+}
+
+// хеш для колонки user.PassHash (формат совпадает с уже сохранёнными)
+func Hash(password string) string {
+	return cipher.MD5(password)
+}
@@ -0,0 +1,46 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestPolicyValidate проверяет правила политики паролей
+func TestPolicyValidate(t *testing.T) {
+	policy := Policy{MinLength: 8, MaxLength: 16}
+	cases := []struct {
+		password string
+		want     error
+	}{
+		{"Secret123", nil},
+		{"Пароль123", nil},
+		{"Abc1234", ErrTooShort},
+		{"Abcdefgh123456789", ErrTooLong},
+		// длина в символах, а не в байтах: 16 кириллических букв - 32 байта
+		{strings.Repeat("я", 15) + "1", nil},
+		{"12345678", ErrNoLetter},
+		{"Password", ErrNoDigit},
+		{" Secret123", ErrWhitespaces},
+		{"Secret123\t", ErrWhitespaces},
+		{"myIvanov2024", ErrHasLogin},
+	}
+	for _, c := range cases {
+		err := policy.Validate(c.password, "ivanov")
+		if !errors.Is(err, c.want) {
+			t.Errorf("Validate(%q): expected %v, got %v", c.password, c.want, err)
+		}
+	}
+
+	// без логина проверка на вхождение не делается
+	if err := policy.Validate("ivanov2024", ""); err != nil {
+		t.Errorf("Expected password to be valid without login, got %v", err)
+	}
+}
+
+// TestHash проверяет формат хеша для колонки PassHash
+func TestHash(t *testing.T) {
+	if got := Hash("password"); got != "5f4dcc3b5aa765d61d8327deb882cf99" {
+		t.Errorf("Unexpected hash %q", got)
+	}
+}
@@ -0,0 +1,94 @@
+package pubsub
+
+import (
+	"sync"
+)
+
+// брокер событий; in-memory реализация ниже,
+// позже можно подменить на внешний (redis, nats) через SetBroker
+type Broker interface {
+	// отправка события всем подписчикам топика, не блокирует
+	Publish(topic string, data []byte) error
+	// подписка на топик; вызвать cancel после завершения чтения
+	Subscribe(topic string) (events <-chan []byte, cancel func())
+	// есть ли подписчики у топика; если брокер не знает, возвращает true
+	HasSubscribers(topic string) bool
+}
+
+// размер буфера канала подписчика; медленный подписчик теряет события
+const subscriberBuffer = 32
+
+type MemoryBroker struct {
+	mu     sync.RWMutex
+	topics map[string]map[chan []byte]struct{}
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		topics: make(map[string]map[chan []byte]struct{}),
+	}
+}
+
+func (b *MemoryBroker) Publish(topic string, data []byte) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.topics[topic] {
+		select {
+		case ch <- data:
+		default:
+			// буфер полон - пропускаем, чтобы не тормозить отметку
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBroker) HasSubscribers(topic string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.topics[topic]) > 0
+}
+
+func (b *MemoryBroker) Subscribe(topic string) (<-chan []byte, func()) {
+	ch := make(chan []byte, subscriberBuffer)
+
+	b.mu.Lock()
+	if b.topics[topic] == nil {
+		b.topics[topic] = make(map[chan []byte]struct{})
+	}
+	b.topics[topic][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.topics[topic], ch)
+			if len(b.topics[topic]) == 0 {
+				delete(b.topics, topic)
+			}
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+	return ch, cancel
+}
+
+var (
+	mu     sync.RWMutex
+	broker Broker = NewMemoryBroker()
+)
+
+// global broker instance
+func Get() Broker {
+	mu.RLock()
+	defer mu.RUnlock()
+	return broker
+}
+
+// замена брокера (например на внешний)
+func SetBroker(b Broker) {
+	mu.Lock()
+	defer mu.Unlock()
+	broker = b
+}
@@ -0,0 +1,105 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+)
+
+// TestMemoryBrokerDelivery проверяет доставку всем подписчикам своего топика
+func TestMemoryBrokerDelivery(t *testing.T) {
+	b := NewMemoryBroker()
+	first, cancelFirst := b.Subscribe("lesson:1")
+	defer cancelFirst()
+	second, cancelSecond := b.Subscribe("lesson:1")
+	defer cancelSecond()
+	other, cancelOther := b.Subscribe("lesson:2")
+	defer cancelOther()
+
+	if err := b.Publish("lesson:1", []byte("mark")); err != nil {
+		t.Fatal(err)
+	}
+	for i, ch := range []<-chan []byte{first, second} {
+		select {
+		case data := <-ch:
+			if string(data) != "mark" {
+				t.Errorf("subscriber %d: expected %q, got %q", i+1, "mark", data)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("subscriber %d: event not delivered", i+1)
+		}
+	}
+
+	// другой топик не получает событие
+	select {
+	case data := <-other:
+		t.Errorf("event leaked to another topic: %q", data)
+	default:
+	}
+}
+
+// TestMemoryBrokerCancel проверяет отписку: канал закрывается, топик удаляется
+func TestMemoryBrokerCancel(t *testing.T) {
+	b := NewMemoryBroker()
+	if b.HasSubscribers("lesson:1") {
+		t.Error("new broker should have no subscribers")
+	}
+
+	events, cancel := b.Subscribe("lesson:1")
+	if !b.HasSubscribers("lesson:1") {
+		t.Error("expected subscriber after Subscribe")
+	}
+	cancel()
+	cancel() // повторный вызов безопасен
+
+	if _, ok := <-events; ok {
+		t.Error("channel should be closed after cancel")
+	}
+	if b.HasSubscribers("lesson:1") || len(b.topics) != 0 {
+		t.Errorf("topic should be removed after last cancel, got %v", b.topics)
+	}
+
+	// публикация без подписчиков не ошибка
+	if err := b.Publish("lesson:1", []byte("mark")); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestMemoryBrokerSlowSubscriber проверяет что полный буфер не блокирует публикацию
+func TestMemoryBrokerSlowSubscriber(t *testing.T) {
+	b := NewMemoryBroker()
+	events, cancel := b.Subscribe("lesson:1")
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < subscriberBuffer+10; i++ {
+			b.Publish("lesson:1", []byte{byte(i)})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a full subscriber")
+	}
+
+	if len(events) != subscriberBuffer {
+		t.Errorf("Expected %d buffered events, got %d", subscriberBuffer, len(events))
+	}
+	// в буфере остаются первые события, лишние отброшены
+	if first := <-events; first[0] != 0 {
+		t.Errorf("Expected first event to be kept, got %v", first)
+	}
+}
+
+// TestSetBroker проверяет подмену глобального брокера
+func TestSetBroker(t *testing.T) {
+	saved := Get()
+	defer SetBroker(saved)
+
+	b := NewMemoryBroker()
+	SetBroker(b)
+	if Get() != Broker(b) {
+		t.Error("Get should return the broker passed to SetBroker")
+	}
+}
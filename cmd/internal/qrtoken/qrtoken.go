@@ -13,11 +13,13 @@ type QrToken struct {
 	Type        string `json:"type"`
 	TeacherName string `json:"teacherName"`
 	Created     int64  `json:"created"`
+	// номер выпуска кода занятия; у кодов до появления поколений - 0
+	Generation int64 `json:"gen"`
 }
 
 var qrTokenSecretKey = []byte("A3k9XpL2qR8mZbN7vGyJ4tHwE1cF6dS5")
 
-func Generate(id int64, nameLesson, date, typeLes, teacherName string) (string, error) {
+func Generate(id, generation int64, nameLesson, date, typeLes, teacherName string) (string, error) {
 	token := map[string]interface{}{
 		"id":          id,
 		"nameLesson":  nameLesson,
@@ -25,6 +27,7 @@ func Generate(id int64, nameLesson, date, typeLes, teacherName string) (string,
 		"type":        typeLes,
 		"teacherName": teacherName,
 		"created":     time.Now().Unix(),
+		"gen":         generation,
 	}
 
 	return cipher.EncryptAES(token, qrTokenSecretKey)
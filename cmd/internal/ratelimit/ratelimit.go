@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// хранилище бакетов. in-memory реализация работает в пределах одного процесса,
+// для нескольких инстансов нужна реализация поверх общего хранилища (redis и т.п.)
+type Store interface {
+	// забрать один токен из бакета key (rate - токенов в секунду, burst - ёмкость).
+	// если токенов нет - false и время до появления следующего
+	Take(key string, rate float64, burst int) (allowed bool, retryAfter time.Duration)
+	// сброс бакета (например после разблокировки админом)
+	Reset(key string)
+}
+
+// token bucket
+type Limiter struct {
+	store  Store
+	prefix string
+	rate   float64
+	burst  int
+}
+
+// perMinute запросов в минуту в среднем, burst подряд.
+// prefix разделяет ключи разных лимитеров в общем хранилище
+func New(store Store, prefix string, perMinute, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		store:  store,
+		prefix: prefix,
+		rate:   float64(perMinute) / 60,
+		burst:  burst,
+	}
+}
+
+// perMinute <= 0 - лимит выключен
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil || l.rate <= 0 {
+		return true, 0
+	}
+	return l.store.Take(l.prefix+key, l.rate, l.burst)
+}
+
+func (l *Limiter) Reset(key string) {
+	if l == nil {
+		return
+	}
+	l.store.Reset(l.prefix + key)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// время полного пополнения, для очистки
+	full time.Duration
+}
+
+// как часто чистить заполненные (неиспользуемые) бакеты
+const sweepInterval = time.Minute
+
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+func (s *MemoryStore) Take(key string, rate float64, burst int) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastSweep) > sweepInterval {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{
+			tokens: float64(burst),
+			last:   now,
+			full:   time.Duration(float64(burst) / rate * float64(time.Second)),
+		}
+		s.buckets[key] = b
+	}
+
+	// пополнение с момента последнего запроса
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	return false, wait
+}
+
+func (s *MemoryStore) Reset(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.buckets, key)
+}
+
+// удаление бакетов, которые уже успели бы полностью пополниться
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if now.Sub(b.last) > b.full {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
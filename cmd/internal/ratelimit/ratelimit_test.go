@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// TestLimiterBurstAndRefill проверяет ёмкость бакета и пополнение со временем
+func TestLimiterBurstAndRefill(t *testing.T) {
+	now := time.Unix(1000, 0)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	// 60 в минуту = 1 токен в секунду, подряд не больше 3
+	limiter := New(store, "test:", 60, 3)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.Allow("a"); !ok {
+			t.Fatalf("request %d should be allowed", i+1)
+		}
+	}
+
+	ok, wait := limiter.Allow("a")
+	if ok {
+		t.Fatal("request over burst should be rejected")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("Expected retry after (0, 1s], got %v", wait)
+	}
+
+	// другой ключ не затронут
+	if ok, _ := limiter.Allow("b"); !ok {
+		t.Error("other key should be allowed")
+	}
+
+	now = now.Add(time.Second)
+	if ok, _ := limiter.Allow("a"); !ok {
+		t.Error("request after refill should be allowed")
+	}
+}
+
+// TestLimiterReset проверяет сброс бакета
+func TestLimiterReset(t *testing.T) {
+	limiter := New(NewMemoryStore(), "test:", 1, 1)
+
+	limiter.Allow("a")
+	if ok, _ := limiter.Allow("a"); ok {
+		t.Fatal("second request should be rejected")
+	}
+
+	limiter.Reset("a")
+	if ok, _ := limiter.Allow("a"); !ok {
+		t.Error("request after reset should be allowed")
+	}
+}
+
+// TestLimiterDisabled проверяет что нулевой лимит ничего не ограничивает
+func TestLimiterDisabled(t *testing.T) {
+	limiter := New(NewMemoryStore(), "test:", 0, 1)
+	for i := 0; i < 100; i++ {
+		if ok, _ := limiter.Allow("a"); !ok {
+			t.Fatal("disabled limiter should allow everything")
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"qr_code/internal/config"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// пути проверок здоровья и готовности: в режиме redirect_http отвечают и по HTTP (балансировщики)
+const (
+	HealthPath = "/healthz"
+	ReadyPath  = "/readyz"
+)
+
+// HTTP и HTTPS серверы с общим жизненным циклом
+type Server struct {
+	http  *http.Server
+	https *http.Server
+	tls   config.HTTPServer
+
+	// внешний источник сертификатов (ACME); nil - файлы cert_file/key_file
+	getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	reloader       *certReloader
+	stopWatch      chan struct{}
+
+	// ошибки Serve после успешного старта
+	errs chan error
+	// адреса после bind (для ":0" в тестах)
+	httpAddr  net.Addr
+	httpsAddr net.Addr
+}
+
+func New(cfg config.HTTPServer, handler http.Handler) *Server {
+	newHTTPServer := func(addr string) *http.Server {
+		return &http.Server{
+			Addr:         addr,
+			Handler:      handler,
+			ReadTimeout:  cfg.Timeout,
+			WriteTimeout: cfg.Timeout,
+			IdleTimeout:  cfg.IdleTimeout,
+		}
+	}
+	httpServer := newHTTPServer(cfg.HttpAddress)
+	if cfg.RedirectHTTP {
+		httpServer.Handler = redirectToHTTPS(cfg.HttpsAddress, handler)
+	}
+	httpsServer := newHTTPServer(cfg.HttpsAddress)
+	if cfg.HSTSMaxAge > 0 {
+		httpsServer.Handler = hsts(cfg.HSTSMaxAge, cfg.HSTSIncludeSubdomains, handler)
+	}
+	return &Server{
+		http:      httpServer,
+		https:     httpsServer,
+		tls:       cfg,
+		stopWatch: make(chan struct{}),
+		errs:      make(chan error, 2),
+	}
+}
+
+// f вызывается в начале Shutdown: закрыть долгие соединения (SSE), которые сами не завершатся
+func (s *Server) RegisterOnShutdown(f func()) {
+	var once sync.Once
+	run := func() { once.Do(f) }
+	s.http.RegisterOnShutdown(run)
+	s.https.RegisterOnShutdown(run)
+}
+
+// UseCertificateSource подключает внешний источник сертификатов вместо файлов,
+// например GetCertificate из golang.org/x/crypto/acme/autocert. вызывать до Start
+func (s *Server) UseCertificateSource(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) {
+	s.getCertificate = getCertificate
+}
+
+// ReloadCertificate перечитывает сертификат с диска (SIGHUP)
+func (s *Server) ReloadCertificate() error {
+	if s.reloader == nil {
+		return nil
+	}
+	return s.reloader.Reload()
+}
+
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	minVersion, err := parseTLSVersion(s.tls.TLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := parseCipherSuites(s.tls.TLSCipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	getCertificate := s.getCertificate
+	if getCertificate == nil {
+		if s.tls.SelfSignedDevCert {
+			if _, err := os.Stat(s.tls.CertFile); errors.Is(err, os.ErrNotExist) {
+				slog.Warn("certificate not found, generating self-signed certificate for development", "cert_file", s.tls.CertFile)
+				if err := GenerateSelfSigned(s.tls.CertFile, s.tls.KeyFile, []string{"localhost", "127.0.0.1"}); err != nil {
+					return nil, fmt.Errorf("generate certificate: %w", err)
+				}
+			}
+		}
+		s.reloader, err = newCertReloader(s.tls.CertFile, s.tls.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		getCertificate = s.reloader.GetCertificate
+	}
+
+	return &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+		GetCertificate: getCertificate,
+	}, nil
+}
+
+// Start занимает оба порта и загружает сертификат; если что-то не удалось - ничего не запускается
+func (s *Server) Start() error {
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return err
+	}
+	s.https.TLSConfig = tlsConfig
+
+	httpListener, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		return fmt.Errorf("http listener: %w", err)
+	}
+	httpsListener, err := net.Listen("tcp", s.https.Addr)
+	if err != nil {
+		httpListener.Close()
+		return fmt.Errorf("https listener: %w", err)
+	}
+	s.httpAddr = httpListener.Addr()
+	s.httpsAddr = httpsListener.Addr()
+
+	slog.Info("starting HTTP server", "address", s.httpAddr.String())
+	go func() {
+		if err := s.http.Serve(httpListener); !errors.Is(err, http.ErrServerClosed) {
+			s.errs <- fmt.Errorf("http server: %w", err)
+		}
+	}()
+
+	slog.Info("starting HTTPS server", "address", s.httpsAddr.String())
+	go func() {
+		// сертификат берётся из TLSConfig.GetCertificate
+		if err := s.https.ServeTLS(httpsListener, "", ""); !errors.Is(err, http.ErrServerClosed) {
+			s.errs <- fmt.Errorf("https server: %w", err)
+		}
+	}()
+
+	if s.reloader != nil && s.tls.CertReloadInterval > 0 {
+		go s.reloader.watch(s.tls.CertReloadInterval, s.stopWatch)
+	}
+	return nil
+}
+
+// ошибка одного из серверов после старта; после неё нужно вызвать Shutdown
+func (s *Server) Err() <-chan error {
+	return s.errs
+}
+
+// Shutdown перестаёт принимать соединения и ждёт завершения текущих запросов обоих серверов
+func (s *Server) Shutdown(ctx context.Context) error {
+	select {
+	case <-s.stopWatch:
+	default:
+		close(s.stopWatch)
+	}
+
+	var (
+		wg                sync.WaitGroup
+		httpErr, httpsErr error
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		httpErr = s.http.Shutdown(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		httpsErr = s.https.Shutdown(ctx)
+	}()
+	wg.Wait()
+	return errors.Join(httpErr, httpsErr)
+}
+
+// перенаправление на тот же путь по HTTPS; 308 сохраняет метод и тело
+func redirectToHTTPS(httpsAddress string, next http.Handler) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddress)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == HealthPath || r.URL.Path == ReadyPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// Strict-Transport-Security: браузер дальше ходит только по HTTPS
+func hsts(maxAge time.Duration, includeSubdomains bool, next http.Handler) http.Handler {
+	value := "max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	if includeSubdomains {
+		value += "; includeSubDomains"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"qr_code/internal/config"
+	"testing"
+	"time"
+)
+
+// writeTestCert пишет самоподписанный сертификат во временную папку
+func writeTestCert(t *testing.T) (certFile, keyFile string) {
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "server.crt")
+	keyFile = filepath.Join(dir, "server.key")
+	if err := GenerateSelfSigned(certFile, keyFile, []string{"localhost", "127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func testConfig(t *testing.T) config.HTTPServer {
+	certFile, keyFile := writeTestCert(t)
+	return config.HTTPServer{
+		HttpAddress:  "127.0.0.1:0",
+		HttpsAddress: "127.0.0.1:0",
+		Timeout:      5 * time.Second,
+		IdleTimeout:  5 * time.Second,
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+	}
+}
+
+// TestStartFailsWhenPortBusy проверяет что занятый порт останавливает запуск целиком
+func TestStartFailsWhenPortBusy(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	cfg := testConfig(t)
+	cfg.HttpsAddress = busy.Addr().String()
+	srv := New(cfg, http.NotFoundHandler())
+	if err := srv.Start(); err == nil {
+		srv.Shutdown(context.Background())
+		t.Fatal("Expected error for busy https port")
+	}
+
+	// http порт освобождён после неудачного старта
+	if srv.httpAddr != nil {
+		t.Error("http listener should not be reported as started")
+	}
+}
+
+// TestStartFailsWithoutCertificate проверяет отказ без сертификата
+func TestStartFailsWithoutCertificate(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.CertFile = filepath.Join(t.TempDir(), "missing.crt")
+	if err := New(cfg, http.NotFoundHandler()).Start(); err == nil {
+		t.Fatal("Expected error for missing certificate")
+	}
+}
+
+// TestShutdownDrainsRequests проверяет что начатый запрос дорабатывает до конца
+func TestShutdownDrainsRequests(t *testing.T) {
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, "marked")
+	})
+
+	srv := New(testConfig(t), handler)
+	hookCalled := make(chan struct{})
+	srv.RegisterOnShutdown(func() { close(hookCalled) })
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		body string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + srv.httpAddr.String() + "/")
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		done <- result{string(body), err}
+	}()
+
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	res := <-done
+	if res.err != nil || res.body != "marked" {
+		t.Errorf("In-flight request was cut off: %q, %v", res.body, res.err)
+	}
+	// хуки http.Server запускает в отдельных горутинах
+	select {
+	case <-hookCalled:
+	case <-time.After(time.Second):
+		t.Error("Shutdown hook was not called")
+	}
+
+	if _, err := http.Get("http://" + srv.httpAddr.String() + "/"); err == nil {
+		t.Error("Server should not accept requests after Shutdown")
+	}
+}
+
+// TestRedirectToHTTPS проверяет перенаправление и исключение для проверки здоровья
+func TestRedirectToHTTPS(t *testing.T) {
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+
+	testCases := []struct {
+		name         string
+		httpsAddress string
+		target       string
+		wantCode     int
+		wantLocation string
+	}{
+		{"default port", ":443", "http://attendance.example.org/api/v1/lessons?page=2", http.StatusPermanentRedirect, "https://attendance.example.org/api/v1/lessons?page=2"},
+		{"custom port", ":8443", "http://localhost:8080/auth", http.StatusPermanentRedirect, "https://localhost:8443/auth"},
+		{"health", ":443", "http://attendance.example.org" + HealthPath, http.StatusOK, ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			redirectToHTTPS(tc.httpsAddress, api).ServeHTTP(w, httptest.NewRequest("POST", tc.target, nil))
+			if w.Code != tc.wantCode {
+				t.Errorf("Expected status %d, got %d", tc.wantCode, w.Code)
+			}
+			if got := w.Header().Get("Location"); got != tc.wantLocation {
+				t.Errorf("Expected Location %q, got %q", tc.wantLocation, got)
+			}
+		})
+	}
+}
+
+// TestHSTS проверяет заголовок Strict-Transport-Security
+func TestHSTS(t *testing.T) {
+	w := httptest.NewRecorder()
+	hsts(365*24*time.Hour, true, http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if got := w.Header().Get("Strict-Transport-Security"); got != "max-age=31536000; includeSubDomains" {
+		t.Errorf("Unexpected HSTS header: %q", got)
+	}
+}
+
+// TestCertificateReload проверяет подхват нового сертификата после замены файлов
+func TestCertificateReload(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := reloader.GetCertificate(nil)
+
+	// без изменений файлов сертификат тот же
+	reloader.reloadIfChanged()
+	if same, _ := reloader.GetCertificate(nil); same != before {
+		t.Fatal("Certificate should not change without file changes")
+	}
+
+	if err := GenerateSelfSigned(certFile, keyFile, []string{"renewed.local"}); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	reloader.reloadIfChanged()
+
+	after, _ := reloader.GetCertificate(nil)
+	leaf, err := x509.ParseCertificate(after.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.Subject.CommonName != "renewed.local" {
+		t.Errorf("Expected renewed certificate, got CN %q", leaf.Subject.CommonName)
+	}
+
+	// битый файл не заменяет рабочий сертификат
+	os.WriteFile(certFile, []byte("garbage"), 0o600)
+	later := future.Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	reloader.reloadIfChanged()
+	if kept, _ := reloader.GetCertificate(nil); kept != after {
+		t.Error("Broken certificate file should keep the previous certificate")
+	}
+}
+
+// TestTLSConfig проверяет версию TLS, шифры и генерацию dev сертификата
+func TestTLSConfig(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.TLSMinVersion = "1.3"
+	srv := New(cfg, http.NotFoundHandler())
+	tlsConfig, err := srv.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS13 {
+		t.Errorf("Expected TLS 1.3, got %x", tlsConfig.MinVersion)
+	}
+
+	cfg.TLSMinVersion = "1.0"
+	if _, err := New(cfg, http.NotFoundHandler()).tlsConfig(); err == nil {
+		t.Error("Expected error for TLS 1.0")
+	}
+
+	cfg.TLSMinVersion = "1.2"
+	cfg.TLSCipherSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"}
+	if _, err := New(cfg, http.NotFoundHandler()).tlsConfig(); err == nil {
+		t.Error("Expected error for insecure cipher suite")
+	}
+
+	cfg.TLSCipherSuites = nil
+	cfg.CertFile = filepath.Join(t.TempDir(), "dev", "server.crt")
+	cfg.KeyFile = filepath.Join(filepath.Dir(cfg.CertFile), "server.key")
+	cfg.SelfSignedDevCert = true
+	if _, err := New(cfg, http.NotFoundHandler()).tlsConfig(); err != nil {
+		t.Fatalf("Expected generated dev certificate, got %v", err)
+	}
+	if _, err := os.Stat(cfg.CertFile); err != nil {
+		t.Errorf("Dev certificate was not written: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// параметры RFC 6238, совместимые с Google Authenticator и аналогами
+const (
+	Digits = 6
+	Period = 30
+	// допустимое расхождение часов, в шагах
+	Skew = 1
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// новый секрет (160 бит, base32)
+func GenerateSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(buf), nil
+}
+
+// otpauth:// ссылка для qr-кода в приложении-аутентификаторе
+func ProvisioningURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(Digits))
+	q.Set("period", fmt.Sprint(Period))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// размер картинки qr-кода в пикселях
+const qrImageSize = 256
+
+// qr-код ссылки как data: URI с PNG, можно сразу вставить в <img src>
+func ProvisioningQR(uri string) (string, error) {
+	png, err := qrcode.Encode(uri, qrcode.Medium, qrImageSize)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png), nil
+}
+
+// номер временного шага
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// код для шага (HOTP по RFC 4226)
+func CodeAt(secret string, step int64) (string, error) {
+	key, err := b32.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%mod), nil
+}
+
+// проверка кода в окне ±Skew шагов. возвращает шаг совпавшего кода:
+// вызывающий должен сохранить его и не принимать шаги <= сохранённого (защита от повтора)
+func Validate(secret, code string, t time.Time) (bool, int64) {
+	code = strings.TrimSpace(code)
+	if len(code) != Digits {
+		return false, 0
+	}
+
+	current := Step(t)
+	for i := -Skew; i <= Skew; i++ {
+		expected, err := CodeAt(secret, current+int64(i))
+		if err != nil {
+			return false, 0
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return true, current + int64(i)
+		}
+	}
+	return false, 0
+}
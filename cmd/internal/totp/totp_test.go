@@ -0,0 +1,87 @@
+package totp
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+// секрет из приложения B RFC 6238 ("12345678901234567890")
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+// TestCodeAtRFCVectors проверяет коды по тестовым векторам RFC 6238 (SHA1, последние 6 цифр)
+func TestCodeAtRFCVectors(t *testing.T) {
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, v := range vectors {
+		code, err := CodeAt(rfcSecret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != v.code {
+			t.Errorf("time %d: expected %s, got %s", v.unix, v.code, code)
+		}
+	}
+}
+
+// TestValidateSkew проверяет окно допустимого расхождения часов
+func TestValidateSkew(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	code, _ := CodeAt(secret, Step(now))
+
+	if ok, step := Validate(secret, code, now); !ok || step != Step(now) {
+		t.Errorf("current code should be valid, got ok=%t step=%d", ok, step)
+	}
+	if ok, _ := Validate(secret, code, now.Add(Period*time.Second)); !ok {
+		t.Error("code from previous step should be valid")
+	}
+	if ok, _ := Validate(secret, code, now.Add(3*Period*time.Second)); ok {
+		t.Error("code older than skew should be rejected")
+	}
+	if ok, _ := Validate(secret, "12345", now); ok {
+		t.Error("short code should be rejected")
+	}
+}
+
+// TestProvisioningURI проверяет формат otpauth ссылки
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("QR Attendance", "teacher", "ABC")
+	if !strings.HasPrefix(uri, "otpauth://totp/QR%20Attendance:teacher?") {
+		t.Errorf("unexpected uri: %s", uri)
+	}
+	if !strings.Contains(uri, "secret=ABC") || !strings.Contains(uri, "issuer=QR+Attendance") {
+		t.Errorf("uri should contain secret and issuer: %s", uri)
+	}
+}
+
+// TestProvisioningQR проверяет что qr-код отдаётся как PNG в data: URI
+func TestProvisioningQR(t *testing.T) {
+	image, err := ProvisioningQR(ProvisioningURI("QR Attendance", "teacher", "ABC"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const prefix = "data:image/png;base64,"
+	if !strings.HasPrefix(image, prefix) {
+		t.Fatalf("unexpected data uri: %.40s", image)
+	}
+	png, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(image, prefix))
+	if err != nil || !bytes.HasPrefix(png, []byte("\x89PNG")) {
+		t.Errorf("expected PNG image, err %v", err)
+	}
+}
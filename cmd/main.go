@@ -1,19 +1,86 @@
 package main
 
 import (
-	"log"
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
 	"qr_code/internal/config"
 	"qr_code/internal/database"
 	"qr_code/internal/handlers"
+	"qr_code/internal/logger"
+	"qr_code/internal/notify"
+	"qr_code/internal/server"
+	"sync"
+	"syscall"
 )
 
 func main() {
-	log.Println("Starting application...")
+	cfg := config.MustLoad()
+	logger.Setup(cfg.Env)
+	slog.Info("starting application", "env", cfg.Env, "config", cfg.Path)
 
-	config.MustLoad()
 	database.MustInit()
+	slog.Info("database initialized", "storage_path", cfg.StoragePath)
 
-	log.Println("SUCCESS: Database initialized!")
+	notify.MustInit()
 
-	handlers.RegisterHTTPHandlers()
+	srv := server.New(cfg.HTTPServer, handlers.NewHTTPHandler())
+	srv.RegisterOnShutdown(handlers.CloseLiveStreams)
+	if err := srv.Start(); err != nil {
+		database.Close()
+		slog.Error("failed to start server", "err", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// фоновые задачи пишут в базу, она закрывается только после их завершения
+	var workers sync.WaitGroup
+	workers.Add(2)
+	// корзина занятий: удаление по сроку хранения
+	go func() {
+		defer workers.Done()
+		handlers.RunTrashPurge(ctx)
+	}()
+	// устаревшие текущие занятия - в архив
+	go func() {
+		defer workers.Done()
+		handlers.RunAutoArchive(ctx)
+	}()
+
+	// SIGHUP - перечитать сертификат после продления
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := srv.ReloadCertificate(); err != nil {
+				slog.Error("certificate reload failed", "err", err)
+				continue
+			}
+			slog.Info("certificate reloaded")
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		slog.Info("shutting down")
+	case err := <-srv.Err():
+		slog.Error("server error, shutting down", "err", err)
+	}
+	// при ошибке сервера ctx сам не отменяется
+	stop()
+
+	// текущие запросы (отметки посещаемости) дорабатывают до таймаута
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTPServer.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("shutdown failed", "err", err)
+	}
+	workers.Wait()
+	if err := database.Close(); err != nil {
+		slog.Error("database close failed", "err", err)
+	}
+	slog.Info("stopped")
 }